@@ -74,13 +74,11 @@ func main() {
 			Cmd:  "/bbin/modprobe",
 			Args: []string{"modprobe", "-a", "rbd", "squashfs", "overlay", "af_packet"},
 		},
-		{
-			Cmd:  "/bbin/dhclient",
-			Args: []string{"/bbin/dhclient", "-ipv6=false", "eth0"},
-		},
+		// rbd boot brings up the network from ip= or rbd.net on the cmdline,
+		// or runs DHCP on the first interface with carrier.
 		{
 			Cmd:  "/bbin/rbd",
-			Args: []string{"/bbin/rbd", "--verbose", "boot", "--mkdir", "--switch-root=/sbin/init"},
+			Args: []string{"/bbin/rbd", "--verbose", "boot", "--mkdir", "--net-default=dhcp", "--switch-root=/sbin/init"},
 			Exec: true,
 		},
 	}
@@ -3,8 +3,8 @@ module github.com/bensallen/rbd
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/google/goexpect v0.0.0-20200816234442-b5b77125c2c5 // indirect
-	github.com/rekby/gpt v0.0.0-20200614112001-7da10aec5566 // indirect
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.6.1 // indirect
 	github.com/u-root/u-root v7.0.0+incompatible
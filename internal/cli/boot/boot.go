@@ -1,16 +1,19 @@
 package boot
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"testing/iotest"
+	"time"
 
 	"github.com/bensallen/rbd/pkg/boot"
+	"github.com/bensallen/rbd/pkg/cephconf"
 	"github.com/bensallen/rbd/pkg/cmdline"
 	"github.com/bensallen/rbd/pkg/krbd"
-	"github.com/bensallen/rbd/pkg/mount"
+	"github.com/bensallen/rbd/pkg/netconf"
 	flag "github.com/spf13/pflag"
 )
 
@@ -28,20 +31,38 @@ var (
 	switchRoot  = flags.StringP("switch-root", "s", "", "Attempt to switch_root to root filesystem and execute provided init path")
 	unshareRoot = flags.StringP("unshare", "u", "", "Attempt to execute init in a namespaced context (container) inside the root filesystem")
 	procPath    = flags.StringP("cmdline", "c", "/proc/cmdline", "Path to kernel cmdline (default: /proc/cmdline)")
+	configFile  = flags.String("config", "", "Read the mounts from a configuration file, eg. /etc/rbd/rbdmap.json, instead of the kernel cmdline, which also skips configuring the network")
+	useKeyring  = flags.String("use-keyring", "", "Add secrets to the session or user kernel keyring and map via key= instead of secret=")
+	conf        = flags.String("conf", "", "Path to ceph.conf used for the monitors, fsid, and keyring of images that don't specify them")
+	timeout     = flags.Duration("timeout", 30*time.Second, "Deadline to map each image, including retries, and for its device to appear")
+	retries     = flags.Int("retries", krbd.DefaultRetry.Retries, "Times to retry mapping an image after errors that may be transient, eg. ETIMEDOUT while the network comes up")
+	backoff     = flags.Duration("retry-backoff", krbd.DefaultRetry.Backoff, "Wait before the first retry, doubled for each following retry")
+	maxBackoff  = flags.Duration("retry-max-backoff", krbd.DefaultRetry.MaxBackoff, "Maximum wait between retries")
+	netConf     = flags.Bool("net", true, "Configure the network from the ip= and rbd.net cmdline arguments before mapping")
+	netDefault  = flags.String("net-default", "", "ip= value used when the cmdline has neither ip= nor rbd.net, eg. dhcp")
+	netTimeout  = flags.Duration("net-timeout", 60*time.Second, "Time to wait for the network to come up, including carrier and DHCP")
+	dhclient    = flags.String("dhclient", netconf.DefaultDHClient, "DHCP client run for interfaces configured via DHCP")
+	check       = flags.Bool("check", true, "Check that a monitor of each image is reachable, with the same retries, before mapping it")
+	keep        = flags.Bool("keep-on-failure", false, "Leave the devices, mounts, and directories of a failed boot or mount in place for debugging instead of rolling them back")
+	forceUnmap  = flags.Bool("force-unmap", false, "Unmap devices with the force option when rolling back a failed boot")
+	caCert      = flags.String("ca-cert", "", "PEM bundle of CA certificates trusted when fetching rbd.config via https, instead of the system's")
+	confTimeout = flags.Duration("config-timeout", 30*time.Second, "Deadline to fetch the rbd.config document")
 )
 
+func init() {
+	flags.Lookup("use-keyring").NoOptDefVal = "session"
+}
+
 const (
 	// RootPath is the path that is prepended for all mounts, and is the path that will be switch_root'ed to
 	// if a root mounted is mounted.
 	RootPath = "/newroot"
 
-	// OverlayPath is used when the root mount indicates that a overlay should be used. The upper and work directories are
-	// created under this directory as OverlayPath + "/upper" and OverlayPath + "/work".
+	// OverlayPath holds a subdirectory per mount that indicates that a overlay should be used, and per lower layer of
+	// one. The image of the mount is mounted read-only to OverlayPath + "/<name>/lower", and the upper layer to
+	// OverlayPath + "/<name>/rw", which holds the upper and work directories. The overlay mounts to RootPath + the
+	// path of the mount, after its parent path mount, so that mounts nested in it are mounted on top of the overlay.
 	OverlayPath = "/run/overlayfs"
-
-	// OverlayRootPath is the path that is prepended for all mounts when the root mount indicates that a overlay
-	// should be mounted over it. The overlay mounts to RootPath and switch_root still switches to RootPath.
-	OverlayRootPath = "/run/overlayfs/lower"
 )
 
 // Usage of the boot subcommand
@@ -51,96 +72,152 @@ func Usage() {
 }
 
 // Run the boot subcommand
-func Run(args []string, verbose bool, noop bool) error {
+func Run(args []string, verbose bool, noop bool) (err error) {
 	flags.ParseErrorsWhitelist.UnknownFlags = true
 	if err := flags.Parse(args); err != nil {
 		Usage()
 		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
 		os.Exit(2)
 	}
-	procCmdline, err := cmdline.Read(*procPath)
-	if err != nil {
+	var fill func(*krbd.Image) error
+	if *conf != "" {
+		c, err := cephconf.Read(*conf)
+		if err != nil {
+			return err
+		}
+		fill = c.Apply
+	}
+
+	var mounts map[string]*cmdline.Mount
+	source := *configFile
+	if source != "" {
+		var diags cmdline.Diagnostics
+		if mounts, diags, err = cmdline.AnalyzeFile(source, fill); err != nil {
+			return err
+		}
+		if len(diags) != 0 {
+			return reportDiags(source, diags)
+		}
+	} else {
+		source = *procPath
+		if mounts, err = cmdlineMounts(fill, verbose, noop); err != nil {
+			return err
+		}
+	}
+
+	// Name per node images, eg. of clones, now that the network, which may set
+	// the hostname, is up
+	vars := boot.NodeVars(krbd.DefaultClient.SysRoot)
+	if verbose {
+		log.Printf("Boot: image name variables %v", vars)
+	}
+	if err := cmdline.Expand(mounts, vars); err != nil {
 		return err
 	}
 
-	mounts := cmdline.Parse(string(procCmdline))
+	var kr krbd.KernelKeyring
+	if *useKeyring != "" {
+		if kr, err = krbd.ParseKeyringID(*useKeyring); err != nil {
+			return err
+		}
+	}
 
 	wc, err := krbd.RBDBusAddWriter()
-	defer wc.Close()
-	w := wc.(io.Writer)
 	if err != nil {
 		return err
 	}
+	defer wc.Close()
+	w := io.Writer(wc)
 
 	if verbose {
-		w = iotest.NewWriteLogger("Boot:", w)
+		w = krbd.NewWriteLogger("Boot:", w)
 	}
 
-	// Set the prepended mount path
-	mntPrefix := RootPath
-	if root, ok := mounts["root"]; ok {
-		if root.Path != "/" {
-			return fmt.Errorf("root path must be set to '/'")
+	// Undo what was done so far if the boot fails
+	journal := &boot.Journal{}
+	defer func() {
+		if err == nil {
+			return
 		}
-		if root.Overlay {
-			mntPrefix = OverlayRootPath
+		if *keep {
+			log.Printf("Boot: failed, keeping %d action(s) for debugging", len(journal.Actions()))
+			return
+		}
+		rollback(journal, verbose)
+	}()
+
+	// Mounts that are lower layers of an overlay
+	layers := map[string]bool{}
+	for _, mnt := range mounts {
+		if mnt.Overlay.Enabled {
+			for _, l := range mnt.Overlay.Lower {
+				layers[l] = true
+			}
 		}
 	}
 
-	for name, mnt := range mounts {
-		log.Printf("Boot: mapping image %s from cmdline", name)
+	plan, err := cmdline.NewPlan(mounts)
+	if err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("Boot: mount order %v", plan.Order())
+	}
 
+	m := &boot.Mounter{
+		Writer:     w,
+		Keyring:    kr,
+		Retry:      krbd.Retry{Retries: *retries, Backoff: *backoff, MaxBackoff: *maxBackoff},
+		Timeout:    *timeout,
+		Check:      *check,
+		Mkdir:      *mkdir,
+		Prefix:     RootPath,
+		ForceUnmap: *forceUnmap,
+	}
+	if verbose {
+		m.Logf = func(format string, v ...interface{}) {
+			log.Printf("Boot: "+format, v...)
+		}
+	}
+	errs := plan.Run(func(name string, mnt *cmdline.Mount) error {
+		log.Printf("Boot: mapping image %s from %s", name, source)
 		if noop {
-			log.Printf("%s", mnt.Image)
+			log.Printf("%s", krbd.RedactSecret(mnt.Image.String()))
+			return nil
+		}
+		// Each mount has its own journal, so that one that fails without failing
+		// the boot doesn't leave its devices and directories behind
+		j := &boot.Journal{}
+		var err error
+		if layers[name] || mnt.Overlay.Enabled {
+			err = overlay(j, m, name, mnt, verbose)
 		} else {
+			err = m.Mount(j, mnt)
+		}
+		if err != nil && !*keep {
+			rollback(j, verbose)
+			return err
+		}
+		journal.Merge(j)
+		return err
+	})
 
-			// Map the RBD device
-			if err := mnt.Image.Map(w); err != nil {
-				return err
-			}
-
-			// Find the RBD device that was just mapped
-			dev := krbd.Device{Image: mnt.Image.Image, Pool: mnt.Image.Pool, Namespace: mnt.Image.Options.Namespace, Snapshot: mnt.Image.Snapshot}
-			if err := dev.Find(); err != nil {
-				return err
-			}
-
-			if verbose {
-				log.Printf("Boot: device found %#v\n", dev)
-			}
-
-			if mnt.Path == "" {
-				return fmt.Errorf("device path not set")
-			}
-
-			if mnt.Path[0] != '/' {
-				return fmt.Errorf("device path not an absolute path")
-			}
-
-			if *mkdir {
-				if err := os.MkdirAll(mntPrefix+mnt.Path, 0755); err != nil {
-					return err
-				}
-			}
-
-			// Attempt to mount the device
-			if err := mount.Mount(dev.DevPath(), mntPrefix+mnt.Path, mnt.FsType, mnt.MountOpts); err != nil {
-				return err
-			}
+	var failed []string
+	for _, name := range plan.Order() {
+		err, ok := errs[name]
+		if !ok {
+			continue
+		}
+		log.Printf("Boot: mount %s failed: %v", name, err)
+		if name == "root" || mounts[name].Required {
+			failed = append(failed, name)
 		}
 	}
+	if len(failed) != 0 {
+		return fmt.Errorf("required mounts failed: %v", failed)
+	}
 
-	if root, ok := mounts["root"]; ok {
-		if root.Overlay {
-			if verbose {
-				log.Printf("Boot: attempting to mount root overlay to %s\n", RootPath)
-			}
-			if !noop {
-				if err := mount.Overlay(OverlayRootPath, OverlayPath+"/upper", OverlayPath+"/work", RootPath); err != nil {
-					return err
-				}
-			}
-		}
+	if _, ok := mounts["root"]; ok {
 		if *switchRoot != "" {
 			if verbose {
 				log.Printf("Boot: attempting to switch root to %s with init %s\n", RootPath, *switchRoot)
@@ -160,3 +237,129 @@ func Run(args []string, verbose bool, noop bool) error {
 	}
 	return nil
 }
+
+// rollback undoes the actions of journal, logging them if verbose.
+func rollback(journal *boot.Journal, verbose bool) {
+	if verbose {
+		for _, a := range journal.Actions() {
+			log.Printf("Boot: rolling back %s", a)
+		}
+	}
+	if err := journal.Rollback(); err != nil {
+		log.Printf("Boot: rollback incomplete: %v", err)
+	}
+}
+
+// overlay mounts the image of mnt read-only to its lower directory under OverlayPath, and if it indicates that a
+// overlay should be used, mounts the overlay of it and its lower layers, which are already mounted, to RootPath + the
+// path of mnt.
+func overlay(journal *boot.Journal, m *boot.Mounter, name string, mnt *cmdline.Mount, verbose bool) error {
+	lower := OverlayPath + "/" + name + "/lower"
+	if err := journal.MkdirAll(lower, 0755); err != nil {
+		return err
+	}
+	ro := *mnt
+	ro.MountOpts = append([]string{"ro"}, mnt.MountOpts...)
+	if err := m.MountAt(journal, &ro, lower); err != nil {
+		return err
+	}
+	if !mnt.Overlay.Enabled {
+		return nil
+	}
+
+	dirs := []string{lower}
+	for _, l := range mnt.Overlay.Lower {
+		dirs = append(dirs, OverlayPath+"/"+l+"/lower")
+	}
+	if verbose {
+		log.Printf("Boot: attempting to mount overlay of %s to %s\n", name, RootPath+mnt.Path)
+	}
+	return m.Overlay(journal, mnt.Overlay, dirs, OverlayPath+"/"+name+"/rw", RootPath+mnt.Path)
+}
+
+// cmdlineMounts returns the mounts of the rbd arguments of the cmdline, and of
+// the rbd.config document it references, after configuring the network.
+func cmdlineMounts(fill func(*krbd.Image) error, verbose bool, noop bool) (map[string]*cmdline.Mount, error) {
+	procCmdline, err := cmdline.Read(*procPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The mounts are checked before the network is configured, unless they are
+	// in a rbd.config document, which can only be fetched afterwards.
+	configURL, configSum, diags := cmdline.ConfigURL(string(procCmdline))
+	var mounts map[string]*cmdline.Mount
+	if configURL == "" {
+		var d cmdline.Diagnostics
+		mounts, d = cmdline.Analyze(string(procCmdline), fill)
+		diags = append(diags, d...)
+	}
+	if len(diags) != 0 {
+		return nil, reportDiags(*procPath, diags)
+	}
+
+	if *netConf {
+		if err := configureNet(string(procCmdline), verbose, noop); err != nil {
+			return nil, err
+		}
+	}
+
+	if configURL != "" {
+		log.Printf("Boot: fetching configuration from %s", configURL)
+		ctx, cancel := context.WithTimeout(context.Background(), *confTimeout)
+		config, err := cmdline.FetchConfig(ctx, configURL, configSum, cmdline.FetchOptions{CACert: *caCert})
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if mounts, diags = cmdline.AnalyzeConfig(string(procCmdline), config, fill); len(diags) != 0 {
+			return nil, reportDiags(*procPath, diags)
+		}
+	}
+	return mounts, nil
+}
+
+// reportDiags prints the problems found with the rbd configuration in source,
+// the cmdline or a configuration file, and returns the error to fail the boot
+// with.
+func reportDiags(source string, diags cmdline.Diagnostics) error {
+	fmt.Fprintf(os.Stderr, "Boot: found %d problem(s) with the rbd configuration in %s:\n\n", len(diags), source)
+	diags.Report(os.Stderr)
+	fmt.Fprintln(os.Stderr)
+	return fmt.Errorf("invalid rbd configuration in %s, refusing to boot", source)
+}
+
+// configureNet brings up the network as configured by the ip= and rbd.net
+// arguments of procCmdline, or by --net-default if there are neither.
+func configureNet(procCmdline string, verbose bool, noop bool) error {
+	c, diags := cmdline.Net(procCmdline)
+	if len(diags) != 0 {
+		fmt.Fprintf(os.Stderr, "Boot: found %d problem(s) with the network cmdline arguments in %s:\n\n", len(diags), *procPath)
+		diags.Report(os.Stderr)
+		fmt.Fprintln(os.Stderr)
+		return errors.New("invalid network cmdline arguments, refusing to boot")
+	}
+	if c == nil && *netDefault != "" {
+		c = &netconf.Config{}
+		if err := netconf.ParseIP(*netDefault, c); err != nil {
+			return fmt.Errorf("--net-default: %w", err)
+		}
+	}
+	if c == nil || len(c.Interfaces) == 0 && c.Hostname == "" && len(c.DNS) == 0 {
+		return nil
+	}
+
+	if noop {
+		log.Printf("Boot: network %+v", *c)
+		return nil
+	}
+	opts := netconf.Options{DHClient: *dhclient}
+	if verbose {
+		opts.Logf = func(format string, v ...interface{}) {
+			log.Printf("Boot: net: "+format, v...)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *netTimeout)
+	defer cancel()
+	return netconf.Configure(ctx, c, opts)
+}
@@ -6,7 +6,9 @@ import (
 	"os"
 
 	"github.com/bensallen/rbd/internal/cli/device/list"
+	"github.com/bensallen/rbd/internal/cli/mapall"
 	"github.com/bensallen/rbd/internal/cli/rbdmap"
+	"github.com/bensallen/rbd/internal/cli/subcmd"
 	"github.com/bensallen/rbd/internal/cli/unmap"
 	flag "github.com/spf13/pflag"
 )
@@ -14,12 +16,14 @@ import (
 const usageHeader = `device - Manage RBD Devices
 
 Usage:
-  device [list|map|unmap]
+  device [list|map|unmap|map-all|unmap-all]
 
 Subcommands:
-  list     List connected devices
-  map      Map RBD Image
-  unmap    Unmap RBD Image
+  list       List connected devices
+  map        Map RBD Image
+  unmap      Unmap RBD Image
+  map-all    Map and mount the RBD images of a configuration file
+  unmap-all  Unmount and unmap the RBD images of a configuration file
 
 `
 
@@ -48,18 +52,23 @@ func Run(args []string, verbose bool, noop bool) error {
 		os.Exit(2)
 	}
 
-	if flags.NArg() < 2 {
+	if flags.NArg() < 1 {
 		usageErr(errors.New("missing subcommand"))
 		os.Exit(2)
 	}
 
-	switch flags.Arg(1) {
+	sub := subcmd.Args(flags, args)
+	switch flags.Arg(0) {
 	case "list", "ls":
-		return list.Run(args, verbose, noop)
+		return list.Run(sub, verbose, noop)
 	case "map":
-		return rbdmap.Run(args, verbose, noop)
+		return rbdmap.Run(sub, verbose, noop)
 	case "unmap":
-		return unmap.Run(args, verbose, noop)
+		return unmap.Run(sub, verbose, noop)
+	case "map-all":
+		return mapall.Run(sub, verbose, noop)
+	case "unmap-all":
+		return mapall.RunUnmap(sub, verbose, noop)
 	case "help":
 		Usage()
 	default:
@@ -1,36 +1,246 @@
 package list
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/bensallen/rbd/pkg/krbd"
+	flag "github.com/spf13/pflag"
 )
 
 const usageHeader = `list - List connected devices
 
+Usage:
+  list [--format table|json|plain] [--columns <column>,...] [--no-headings]
+
+Flags:
 `
 
+var (
+	flags      = flag.NewFlagSet("list", flag.ContinueOnError)
+	format     = flags.String("format", "table", "Output format: table, json, or plain")
+	columns    = flags.StringSlice("columns", nil, "Comma separated columns to output, from: "+strings.Join(columnNames(), ", ")+" (default "+strings.Join(defaultColumns, ",")+")")
+	noHeadings = flags.Bool("no-headings", false, "Don't print the column headings of the table format")
+)
+
 // Usage of the list subcommand
 func Usage() {
 	fmt.Fprintf(os.Stderr, usageHeader)
+	fmt.Fprintf(os.Stderr, flags.FlagUsagesWrapped(0)+"\n")
+}
+
+// column is an attribute of a krbd.Device that can be listed.
+type column struct {
+	name string
+	// key is the JSON object key, which matches upstream rbd where it has the same column.
+	key string
+	// value returns the JSON value of the column. The table and plain formats use
+	// fmt.Sprint of it unless text is set.
+	value func(d krbd.Device) interface{}
+	text  func(d krbd.Device) string
+}
+
+// parent is the JSON form of krbd.Parent.
+type parent struct {
+	PoolID    uint64 `json:"pool_id"`
+	Pool      string `json:"pool"`
+	Namespace string `json:"namespace"`
+	ImageID   string `json:"image_id"`
+	Image     string `json:"image"`
+	SnapID    uint64 `json:"snap_id"`
+	Snapshot  string `json:"snap"`
+	Overlap   uint64 `json:"overlap"`
+}
+
+var allColumns = []column{
+	// Upstream rbd outputs the id as a string.
+	{name: "id", key: "id", value: func(d krbd.Device) interface{} { return strconv.FormatInt(d.ID, 10) }},
+	{name: "pool", key: "pool", value: func(d krbd.Device) interface{} { return d.Pool }},
+	{name: "namespace", key: "namespace", value: func(d krbd.Device) interface{} { return d.Namespace }},
+	{name: "image", key: "name", value: func(d krbd.Device) interface{} { return d.Image }},
+	{name: "snap", key: "snap", value: func(d krbd.Device) interface{} { return d.Snapshot }},
+	{name: "device", key: "device", value: func(d krbd.Device) interface{} { return krbd.DefaultClient.DevPath(d) }},
+	{name: "size", key: "size", value: func(d krbd.Device) interface{} { return d.Size }},
+	{
+		name:  "features",
+		key:   "features",
+		value: func(d krbd.Device) interface{} { return d.Features.Names() },
+		text:  func(d krbd.Device) string { return d.Features.String() },
+	},
+	{name: "major", key: "major", value: func(d krbd.Device) interface{} { return d.Major }},
+	{name: "minor", key: "minor", value: func(d krbd.Device) interface{} { return d.Minor }},
+	{name: "client_id", key: "client_id", value: func(d krbd.Device) interface{} { return d.ClientID }},
+	{name: "client_addr", key: "client_addr", value: func(d krbd.Device) interface{} { return d.ClientAddr }},
+	{name: "cluster_fsid", key: "cluster_fsid", value: func(d krbd.Device) interface{} { return d.ClusterFsid }},
+	{name: "image_id", key: "image_id", value: func(d krbd.Device) interface{} { return d.ImageID }},
+	{name: "snap_id", key: "snap_id", value: func(d krbd.Device) interface{} { return d.SnapID }},
+	{name: "pool_id", key: "pool_id", value: func(d krbd.Device) interface{} { return d.PoolID }},
+	{
+		name: "parent",
+		key:  "parent",
+		value: func(d krbd.Device) interface{} {
+			parents := make([]parent, len(d.Parents))
+			for i, p := range d.Parents {
+				parents[i] = parent(p)
+			}
+			return parents
+		},
+		text: func(d krbd.Device) string {
+			specs := make([]string, len(d.Parents))
+			for i, p := range d.Parents {
+				img := krbd.Image{Pool: p.Pool, Image: p.Image, Snapshot: p.Snapshot, Options: &krbd.Options{Namespace: p.Namespace}}
+				specs[i] = img.Spec()
+			}
+			return strings.Join(specs, ",")
+		},
+	},
+	{name: "config_info", key: "config_info", value: func(d krbd.Device) interface{} { return krbd.RedactSecret(d.ConfigInfo) }},
+}
+
+// defaultColumns match the output of upstream rbd device list.
+var defaultColumns = []string{"id", "pool", "namespace", "image", "snap", "device"}
+
+func columnNames() []string {
+	names := make([]string, len(allColumns))
+	for i, c := range allColumns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// selectColumns returns the columns with the provided names, in order.
+func selectColumns(names []string) ([]column, error) {
+	if len(names) == 0 {
+		names = defaultColumns
+	}
+	selected := make([]column, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, c := range allColumns {
+			if c.name == name {
+				selected = append(selected, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %q, valid columns are: %s", name, strings.Join(columnNames(), ", "))
+		}
+	}
+	return selected, nil
+}
+
+func (c column) String(d krbd.Device) string {
+	if c.text != nil {
+		return c.text(d)
+	}
+	return fmt.Sprint(c.value(d))
 }
 
 // Run the list subcommand of device
 func Run(args []string, verbose bool, noop bool) error {
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	if err := flags.Parse(args); err != nil {
+		Usage()
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		os.Exit(2)
+	}
+
+	cols, err := selectColumns(*columns)
+	if err != nil {
+		Usage()
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		os.Exit(2)
+	}
+
+	// Without the rbd module loaded there is no rbd bus, and so no devices.
 	devices, err := krbd.Devices()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	switch *format {
+	case "table":
+		return writeTable(os.Stdout, devices, cols, !*noHeadings)
+	case "json":
+		return writeJSON(os.Stdout, devices, cols)
+	case "plain":
+		return writePlain(os.Stdout, devices, cols)
+	}
+	Usage()
+	fmt.Fprintf(os.Stderr, "Error: unknown format %q\n\n", *format)
+	os.Exit(2)
+	return nil
+}
+
+func writeTable(out io.Writer, devices []krbd.Device, cols []column, headings bool) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	row := make([]string, len(cols))
+	if headings {
+		for i, c := range cols {
+			row[i] = c.name
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	for _, d := range devices {
+		for i, c := range cols {
+			row[i] = c.String(d)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// writeJSON writes a JSON list with an object per device, always a valid
+// list even when there are no devices.
+func writeJSON(out io.Writer, devices []krbd.Device, cols []column) error {
+	list := make([]json.RawMessage, 0, len(devices))
+	for _, d := range devices {
+		// Build the object by hand to keep the order of the columns.
+		var b strings.Builder
+		b.WriteByte('{')
+		for i, c := range cols {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			key, _ := json.Marshal(c.key)
+			value, err := json.Marshal(c.value(d))
+			if err != nil {
+				return err
+			}
+			b.Write(key)
+			b.WriteByte(':')
+			b.Write(value)
+		}
+		b.WriteByte('}')
+		list = append(list, json.RawMessage(b.String()))
+	}
+	b, err := json.Marshal(list)
 	if err != nil {
 		return err
 	}
-	if len(devices) != 0 {
-		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
-		fmt.Fprintln(w, "id\tpool\tnamespace\timage\tsnap\tdevice")
-		for _, device := range devices {
-			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", device.ID, device.Pool, device.Namespace, device.Image, device.Snapshot, "/dev/rbd"+strconv.FormatInt(device.ID, 10))
+	_, err = fmt.Fprintf(out, "%s\n", b)
+	return err
+}
+
+// writePlain writes a "column: value" line per column, with a blank line
+// between devices.
+func writePlain(out io.Writer, devices []krbd.Device, cols []column) error {
+	for n, d := range devices {
+		if n > 0 {
+			fmt.Fprintln(out)
+		}
+		for _, c := range cols {
+			if _, err := fmt.Fprintf(out, "%s: %s\n", c.name, c.String(d)); err != nil {
+				return err
+			}
 		}
-		w.Flush()
 	}
 	return nil
 }
@@ -0,0 +1,106 @@
+package list
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bensallen/rbd/pkg/krbd"
+)
+
+func TestWrite(t *testing.T) {
+	devices := []krbd.Device{
+		{ID: 0, Pool: "rbd", Image: "image1", Snapshot: "-", Size: 1024},
+		{ID: 12, Pool: "rbd", Namespace: "ns1", Image: "image2", Snapshot: "snap1", Size: 2048},
+	}
+	tests := []struct {
+		name     string
+		format   string
+		devices  []krbd.Device
+		columns  []string
+		headings bool
+		want     string
+	}{
+		{
+			name:   "JSON without devices",
+			format: "json",
+			want:   "[]\n",
+		},
+		{
+			name:    "JSON upstream keys",
+			format:  "json",
+			devices: devices,
+			want: `[{"id":"0","pool":"rbd","namespace":"","name":"image1","snap":"-","device":"/dev/rbd0"},` +
+				`{"id":"12","pool":"rbd","namespace":"ns1","name":"image2","snap":"snap1","device":"/dev/rbd12"}]` + "\n",
+		},
+		{
+			name:    "JSON columns order",
+			format:  "json",
+			devices: devices[:1],
+			columns: []string{"device", "size", "id"},
+			want:    `[{"device":"/dev/rbd0","size":1024,"id":"0"}]` + "\n",
+		},
+		{
+			name:     "Table without devices",
+			format:   "table",
+			headings: true,
+			want:     "id  pool  namespace  image  snap  device\n",
+		},
+		{
+			name:     "Table",
+			format:   "table",
+			devices:  devices,
+			headings: true,
+			want: "id  pool  namespace  image   snap   device\n" +
+				"0   rbd              image1  -      /dev/rbd0\n" +
+				"12  rbd   ns1        image2  snap1  /dev/rbd12\n",
+		},
+		{
+			name:    "Table no headings and columns order",
+			format:  "table",
+			devices: devices,
+			columns: []string{"image", "id"},
+			want: "image1  0\n" +
+				"image2  12\n",
+		},
+		{
+			name:   "Plain without devices",
+			format: "plain",
+		},
+		{
+			name:    "Plain",
+			format:  "plain",
+			devices: devices,
+			columns: []string{"id", "image"},
+			want:    "id: 0\nimage: image1\n\nid: 12\nimage: image2\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cols, err := selectColumns(tt.columns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			switch tt.format {
+			case "json":
+				err = writeJSON(&out, tt.devices, cols)
+			case "table":
+				err = writeTable(&out, tt.devices, cols, tt.headings)
+			case "plain":
+				err = writePlain(&out, tt.devices, cols)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("write %s = %q, want %q", tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_selectColumns(t *testing.T) {
+	if _, err := selectColumns([]string{"id", "bogus"}); err == nil {
+		t.Error("selectColumns() of an unknown column succeeded, want an error")
+	}
+}
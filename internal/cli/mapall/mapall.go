@@ -0,0 +1,161 @@
+package mapall
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bensallen/rbd/pkg/boot"
+	"github.com/bensallen/rbd/pkg/cephconf"
+	"github.com/bensallen/rbd/pkg/cmdline"
+	"github.com/bensallen/rbd/pkg/krbd"
+	flag "github.com/spf13/pflag"
+)
+
+const usageHeader = `map-all - Map and mount the RBD images of a configuration file
+
+Usage:
+  map-all [--config <file>]
+
+Flags:
+`
+
+var (
+	flags      = flag.NewFlagSet("map-all", flag.ContinueOnError)
+	configFile = flags.String("config", "", "Configuration file, in the rbd= JSON, TOML, or rbdmap format (default the first found of "+strings.Join(cmdline.DefaultFiles, ", ")+")")
+	conf       = flags.StringP("conf", "c", cephconf.DefaultPath, "Path to ceph.conf used for the monitors, fsid, and keyring of images that don't specify them")
+	mkdir      = flags.BoolP("mkdir", "m", false, "Create the mount paths that don't exist")
+	useKeyring = flags.String("use-keyring", "", "Add secrets to the session or user kernel keyring and map via key= instead of secret=")
+	timeout    = flags.Duration("timeout", 30*time.Second, "Deadline to map each image, including retries, and for its device to appear")
+	retries    = flags.Int("retries", krbd.DefaultRetry.Retries, "Times to retry mapping an image after errors that may be transient")
+	backoff    = flags.Duration("retry-backoff", krbd.DefaultRetry.Backoff, "Wait before the first retry, doubled for each following retry")
+	maxBackoff = flags.Duration("retry-max-backoff", krbd.DefaultRetry.MaxBackoff, "Maximum wait between retries")
+	check      = flags.Bool("check", false, "Check that a monitor of each image is reachable before mapping it")
+)
+
+func init() {
+	flags.Lookup("use-keyring").NoOptDefVal = "session"
+}
+
+// Usage of the map-all subcommand
+func Usage() {
+	fmt.Fprintf(os.Stderr, usageHeader)
+	fmt.Fprintf(os.Stderr, flags.FlagUsagesWrapped(0)+"\n")
+}
+
+// Run the map-all subcommand
+func Run(args []string, verbose bool, noop bool) error {
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	if err := flags.Parse(args); err != nil {
+		Usage()
+		fmt.Printf("Error: %v\n\n", err)
+		os.Exit(2)
+	}
+
+	mounts, plan, err := load(*configFile, *conf)
+	if err != nil {
+		return err
+	}
+
+	m := &boot.Mounter{
+		Retry:   krbd.Retry{Retries: *retries, Backoff: *backoff, MaxBackoff: *maxBackoff},
+		Timeout: *timeout,
+		Check:   *check,
+		Reuse:   true,
+		Mkdir:   *mkdir,
+	}
+	if verbose {
+		m.Logf = func(format string, v ...interface{}) {
+			log.Printf("map-all: "+format, v...)
+		}
+	}
+	if *useKeyring != "" {
+		if m.Keyring, err = krbd.ParseKeyringID(*useKeyring); err != nil {
+			return err
+		}
+	}
+
+	if !noop {
+		wc, err := krbd.RBDBusAddWriter()
+		if err != nil {
+			return err
+		}
+		defer wc.Close()
+		m.Writer = io.Writer(wc)
+		if verbose {
+			m.Writer = krbd.NewWriteLogger("map-all", m.Writer)
+		}
+	}
+
+	// Like rbdmap, a failed mount doesn't stop the others. Only what was done
+	// for the failed mount itself is undone.
+	errs := plan.Run(func(name string, mnt *cmdline.Mount) error {
+		if noop {
+			log.Printf("map-all: %s %s", name, krbd.RedactSecret(mnt.Image.String()))
+			return nil
+		}
+		j := &boot.Journal{}
+		if err := m.Mount(j, mnt); err != nil {
+			if rerr := j.Rollback(); rerr != nil {
+				log.Printf("map-all: %s: rollback incomplete: %v", name, rerr)
+			}
+			return err
+		}
+		return nil
+	})
+
+	var failed []string
+	for _, name := range plan.Order() {
+		if err, ok := errs[name]; ok {
+			log.Printf("map-all: %s failed: %v", name, err)
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("%d of %d mounts failed: %v", len(failed), len(mounts), failed)
+	}
+	return nil
+}
+
+// load analyzes the configuration file, or the first of cmdline.DefaultFiles
+// found if empty, filling in the images from ceph.conf at cephConf if it
+// exists, and plans the order of its mounts.
+func load(file string, cephConf string) (map[string]*cmdline.Mount, *cmdline.Plan, error) {
+	if file == "" {
+		var err error
+		if file, err = cmdline.FindFile(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var fill func(*krbd.Image) error
+	if _, err := os.Stat(cephConf); err == nil {
+		c, err := cephconf.Read(cephConf)
+		if err != nil {
+			return nil, nil, err
+		}
+		fill = c.Apply
+	}
+
+	mounts, diags, err := cmdline.AnalyzeFile(file, fill)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(diags) != 0 {
+		fmt.Fprintf(os.Stderr, "found %d problem(s) in %s:\n\n", len(diags), file)
+		diags.Report(os.Stderr)
+		fmt.Fprintln(os.Stderr)
+		return nil, nil, fmt.Errorf("invalid configuration file %s", file)
+	}
+	if err := cmdline.Expand(mounts, boot.NodeVars(krbd.DefaultClient.SysRoot)); err != nil {
+		return nil, nil, err
+	}
+	plan, err := cmdline.NewPlan(mounts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mounts, plan, nil
+}
@@ -0,0 +1,36 @@
+package mapall
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun_noopRedactsSecret(t *testing.T) {
+	const secret = "AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q=="
+	dir, err := ioutil.TempDir("", "rbd-mapall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "rbdmap.json")
+	doc := `{"data":{"image":{"mons":["192.168.0.1"],"pool":"rbd","image":"data","opts":"name=admin,secret=` + secret + `"},"path":"/srv/data"}}`
+	if err := ioutil.WriteFile(config, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	if err := Run([]string{"--config", config, "--conf", filepath.Join(dir, "ceph.conf")}, false, true); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if out := buf.String(); strings.Contains(out, secret) || !strings.Contains(out, "secret=<redacted>") {
+		t.Errorf("Run() logged %q, want the secret redacted", out)
+	}
+}
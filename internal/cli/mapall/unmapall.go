@@ -0,0 +1,75 @@
+package mapall
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/bensallen/rbd/pkg/boot"
+	"github.com/bensallen/rbd/pkg/cephconf"
+	"github.com/bensallen/rbd/pkg/cmdline"
+	flag "github.com/spf13/pflag"
+)
+
+const unmapUsageHeader = `unmap-all - Unmount and unmap the RBD images of a configuration file
+
+Usage:
+  unmap-all [--config <file>]
+
+Flags:
+`
+
+var (
+	unmapFlags      = flag.NewFlagSet("unmap-all", flag.ContinueOnError)
+	unmapConfigFile = unmapFlags.String("config", "", "Configuration file, in the rbd= JSON, TOML, or rbdmap format (default the first found of "+strings.Join(cmdline.DefaultFiles, ", ")+")")
+	unmapConf       = unmapFlags.StringP("conf", "c", cephconf.DefaultPath, "Path to ceph.conf used for the monitors of images that don't specify them")
+	force           = unmapFlags.BoolP("force", "f", false, "Unmap with the force option, which waits for running requests")
+)
+
+// UnmapUsage of the unmap-all subcommand
+func UnmapUsage() {
+	fmt.Fprintf(os.Stderr, unmapUsageHeader)
+	fmt.Fprintf(os.Stderr, unmapFlags.FlagUsagesWrapped(0)+"\n")
+}
+
+// RunUnmap runs the unmap-all subcommand, which undoes map-all in the reverse
+// order of the mounts.
+func RunUnmap(args []string, verbose bool, noop bool) error {
+	unmapFlags.ParseErrorsWhitelist.UnknownFlags = true
+	if err := unmapFlags.Parse(args); err != nil {
+		UnmapUsage()
+		fmt.Printf("Error: %v\n\n", err)
+		os.Exit(2)
+	}
+
+	mounts, plan, err := load(*unmapConfigFile, *unmapConf)
+	if err != nil {
+		return err
+	}
+
+	m := &boot.Mounter{ForceUnmap: *force}
+	if verbose {
+		m.Logf = func(format string, v ...interface{}) {
+			log.Printf("unmap-all: "+format, v...)
+		}
+	}
+
+	order := plan.Order()
+	var failed []string
+	for n := len(order) - 1; n >= 0; n-- {
+		name := order[n]
+		if noop {
+			log.Printf("unmap-all: %s", name)
+			continue
+		}
+		if err := m.Unmount(mounts[name]); err != nil {
+			log.Printf("unmap-all: %s failed: %v", name, err)
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("%d of %d mounts failed: %v", len(failed), len(order), failed)
+	}
+	return nil
+}
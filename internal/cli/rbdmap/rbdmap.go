@@ -1,12 +1,14 @@
 package rbdmap
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"testing/iotest"
+	"time"
 
+	"github.com/bensallen/rbd/pkg/cephconf"
 	"github.com/bensallen/rbd/pkg/krbd"
 	flag "github.com/spf13/pflag"
 )
@@ -14,29 +16,45 @@ import (
 const usageHeader = `map - Map RBD Image
 
 Usage:
-  map
+  map [<pool>/[<namespace>/]]<image>[@<snap>]
 
 Flags:
 `
 
 var (
-	flags     = flag.NewFlagSet("map", flag.ContinueOnError)
-	monAddrs  = flags.StringSliceP("monitor", "m", []string{}, "Connect to one or more monitor addresses (192.168.0.1[:6789]). Multiple address are specified comma separated.")
-	pool      = flags.StringP("pool", "p", "", "Interact with the given pool.")
-	image     = flags.StringP("image", "i", "", "Image to map")
-	namespace = flags.String("namespace", "", "Use a pre-defined image namespace within a pool")
-	snap      = flags.String("snap", "", "Specifies a snapshot name")
-	id        = flags.String("id", "", "Specifies the username (without the 'client.' prefix)")
-	secret    = flags.String("secret", "", "Specifies the user authentication secret")
-	readOnly  = flags.Bool("read-only", false, "Map the image read-only")
+	flags      = flag.NewFlagSet("map", flag.ContinueOnError)
+	monAddrs   = flags.StringSliceP("monitor", "m", []string{}, "Connect to one or more monitor addresses (192.168.0.1[:6789]). Multiple address are specified comma separated.")
+	pool       = flags.StringP("pool", "p", "", "Interact with the given pool.")
+	image      = flags.StringP("image", "i", "", "Image to map")
+	namespace  = flags.String("namespace", "", "Use a pre-defined image namespace within a pool")
+	snap       = flags.String("snap", "", "Specifies a snapshot name")
+	id         = flags.String("id", krbd.DefaultUser, "Specifies the username (without the 'client.' prefix)")
+	secret     = flags.String("secret", "", "Specifies the user authentication secret")
+	keyring    = flags.String("keyring", "", "Read the user authentication secret from a keyring file")
+	keyfile    = flags.String("keyfile", "", "Read the user authentication secret from a file containing only the secret")
+	readOnly   = flags.Bool("read-only", false, "Map the image read-only")
+	options    = flags.StringP("options", "o", "", "Comma separated krbd map options, eg. queue_depth=128,lock_on_read")
+	useKeyring = flags.String("use-keyring", "", "Add the secret to the session or user kernel keyring and map via key= instead of secret=")
+	timeout    = flags.Duration("timeout", 30*time.Second, "Time to wait for the mapped device to appear")
+	conf       = flags.StringP("conf", "c", cephconf.DefaultPath, "Path to ceph.conf used for the monitors, fsid, and keyring when not otherwise specified")
+	check      = flags.Bool("check", false, "Check that a monitor is reachable before mapping, instead of waiting for mount_timeout when none is")
 )
 
+func init() {
+	flags.Lookup("use-keyring").NoOptDefVal = "session"
+}
+
 // Usage of the map subcommand
 func Usage() {
 	fmt.Fprintf(os.Stderr, usageHeader)
 	fmt.Fprintf(os.Stderr, flags.FlagUsagesWrapped(0)+"\n")
 }
 
+// positional returns the argument following the subcommand, if any.
+func positional() string {
+	return flags.Arg(0)
+}
+
 // Run the map subcommand
 func Run(args []string, verbose bool, noop bool) error {
 	flags.ParseErrorsWhitelist.UnknownFlags = true
@@ -46,28 +64,13 @@ func Run(args []string, verbose bool, noop bool) error {
 		os.Exit(2)
 	}
 
-	if len(*monAddrs) == 0 || *pool == "" || *image == "" || *id == "" || *secret == "" {
-		Usage()
-		fmt.Print("Error: --monitor, --pool, --image, --id, and --secret must be specified\n\n")
-		os.Exit(2)
-	}
-
-	wc, err := krbd.RBDBusAddWriter()
-	defer wc.Close()
-	w := wc.(io.Writer)
-	if err != nil {
-		return err
-	}
-
-	if verbose {
-		w = iotest.NewWriteLogger("map", w)
-	}
-
 	i := krbd.Image{
 		Monitors: *monAddrs,
 		Pool:     *pool,
 		Image:    *image,
 		Snapshot: *snap,
+		Keyring:  *keyring,
+		Keyfile:  *keyfile,
 		Options: &krbd.Options{
 			ReadOnly:  *readOnly,
 			Name:      *id,
@@ -76,11 +79,85 @@ func Run(args []string, verbose bool, noop bool) error {
 		},
 	}
 
+	if err := i.Options.Parse(*options); err != nil {
+		Usage()
+		fmt.Printf("Error: --options: %v\n\n", err)
+		os.Exit(2)
+	}
+
+	// An image spec positional argument takes precedence over the flags.
+	if spec := positional(); spec != "" {
+		if err := i.ParseSpec(spec); err != nil {
+			Usage()
+			fmt.Printf("Error: %v\n\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if i.Pool == "" || i.Image == "" || *id == "" {
+		Usage()
+		fmt.Print("Error: --pool and --image, or an image spec, and --id must be specified\n\n")
+		os.Exit(2)
+	}
+
+	// Fall back to ceph.conf for anything not provided via flags.
+	if len(i.Monitors) == 0 || (*secret == "" && *keyring == "" && *keyfile == "") {
+		c, err := cephconf.Read(*conf)
+		if err != nil {
+			return fmt.Errorf("--monitor and one of --secret, --keyring, or --keyfile not specified, and could not read ceph.conf: %v", err)
+		}
+		if err := c.Apply(&i); err != nil {
+			return err
+		}
+		if len(i.Monitors) == 0 {
+			return fmt.Errorf("no monitors found in %s", *conf)
+		}
+	}
+
+	// Checking the monitors doesn't change anything, so is also done with noop.
+	if *check {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		report := i.CheckMonitors(ctx, krbd.DefaultProbeTimeout)
+		cancel()
+		if verbose {
+			report.Write(os.Stderr)
+		}
+		if err := report.Err(); err != nil {
+			return err
+		}
+	}
+
 	if noop {
-		log.Printf("%s", i)
-	} else {
-		return i.Map(w)
+		log.Printf("%s", krbd.RedactSecret(i.String()))
+		return nil
+	}
+
+	wc, err := krbd.RBDBusAddWriter()
+	if err != nil {
+		return err
 	}
+	defer wc.Close()
+	w := io.Writer(wc)
 
+	if verbose {
+		w = krbd.NewWriteLogger("map", w)
+	}
+
+	if *useKeyring != "" {
+		kr, err := krbd.ParseKeyringID(*useKeyring)
+		if err != nil {
+			return err
+		}
+		if err := i.LoadKey(kr); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	dev, err := i.MapAndWait(ctx, w)
+	if err != nil {
+		return err
+	}
+	fmt.Println(krbd.DefaultClient.DevPath(dev))
 	return nil
 }
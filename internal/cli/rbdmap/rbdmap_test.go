@@ -0,0 +1,23 @@
+package rbdmap
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRun_noopRedactsSecret(t *testing.T) {
+	const secret = "AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q=="
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	if err := Run([]string{"--monitor", "192.168.0.1", "--secret", secret, "rbd/image1"}, false, true); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if out := buf.String(); strings.Contains(out, secret) || !strings.Contains(out, "secret=<redacted>") {
+		t.Errorf("Run() logged %q, want the secret redacted", out)
+	}
+}
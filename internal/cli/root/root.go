@@ -8,8 +8,11 @@ import (
 	"github.com/bensallen/rbd/internal/cli/boot"
 	"github.com/bensallen/rbd/internal/cli/device"
 	"github.com/bensallen/rbd/internal/cli/device/list"
+	"github.com/bensallen/rbd/internal/cli/mapall"
 	"github.com/bensallen/rbd/internal/cli/rbdmap"
+	"github.com/bensallen/rbd/internal/cli/subcmd"
 	"github.com/bensallen/rbd/internal/cli/unmap"
+	"github.com/bensallen/rbd/pkg/krbd"
 	flag "github.com/spf13/pflag"
 )
 
@@ -22,6 +25,8 @@ var (
 	version   = rootFlags.BoolP("version", "V", false, "Displays the program version string.")
 	noop      = rootFlags.BoolP("noop", "n", false, "No-op (don't actually perform action).")
 	verbose   = rootFlags.BoolP("verbose", "v", false, "Enable additional output.")
+	sysRoot   = rootFlags.String("sysfs", krbd.DefaultSysRoot, "Path where sysfs is mounted.")
+	devRoot   = rootFlags.String("devfs", krbd.DefaultDevRoot, "Path where the device nodes are found.")
 )
 
 const usageHeader = `rbd - Ceph RBD CLI
@@ -82,6 +87,10 @@ func Run(args []string) error {
 					rbdmap.Usage()
 				case "unmap":
 					unmap.Usage()
+				case "map-all":
+					mapall.Usage()
+				case "unmap-all":
+					mapall.UnmapUsage()
 				default:
 					device.Usage()
 				}
@@ -97,16 +106,19 @@ func Run(args []string) error {
 		os.Exit(2)
 	}
 
-	// Run subcommands
+	krbd.DefaultClient = krbd.NewClient(*sysRoot, *devRoot)
+
+	// Run subcommands with the arguments following their name
+	sub := subcmd.Args(rootFlags, args)
 	switch rootFlags.Arg(0) {
 	case "map":
-		return rbdmap.Run(args, *verbose, *noop)
+		return rbdmap.Run(sub, *verbose, *noop)
 	case "unmap":
-		return unmap.Run(args, *verbose, *noop)
+		return unmap.Run(sub, *verbose, *noop)
 	case "device":
-		return device.Run(args, *verbose, *noop)
+		return device.Run(sub, *verbose, *noop)
 	case "boot":
-		return boot.Run(args, *verbose, *noop)
+		return boot.Run(sub, *verbose, *noop)
 	case "help":
 		usage()
 	default:
@@ -0,0 +1,68 @@
+// Package subcmd hands the arguments of a command on to its subcommands.
+package subcmd
+
+import (
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Args returns the arguments following the first positional argument of args,
+// as parsed by fs, which is the name of the subcommand, for the subcommand to
+// parse. Flags before it were handled by fs. Flag values are skipped like pflag
+// does, including for unknown flags, so that a value equal to the name of the
+// subcommand isn't taken for it, and later arguments, eg. an image named map,
+// are passed on as is.
+func Args(fs *flag.FlagSet, args []string) []string {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--":
+			if i+1 < len(args) {
+				return args[i+2:]
+			}
+			return nil
+		case strings.HasPrefix(a, "--"):
+			if strings.Contains(a, "=") {
+				continue
+			}
+			if takesValue(fs.Lookup(a[2:]), args[i+1:]) {
+				i++
+			}
+		case strings.HasPrefix(a, "-") && len(a) > 1:
+			for shorts := a[1:]; len(shorts) > 0; {
+				f := fs.ShorthandLookup(shorts[:1])
+				switch {
+				case len(shorts) > 2 && shorts[1] == '=':
+					shorts = ""
+				case f == nil:
+					if takesValue(nil, args[i+1:]) {
+						i++
+					}
+					shorts = shorts[1:]
+				case f.NoOptDefVal != "":
+					shorts = shorts[1:]
+				case len(shorts) > 1:
+					// -fvalue
+					shorts = ""
+				default:
+					i++
+					shorts = ""
+				}
+			}
+		default:
+			return args[i+1:]
+		}
+	}
+	return nil
+}
+
+// takesValue reports whether the flag f, nil if unknown, takes the next
+// argument of rest as its value.
+func takesValue(f *flag.Flag, rest []string) bool {
+	if f != nil {
+		return f.NoOptDefVal == ""
+	}
+	// See pflag's stripUnknownFlagValue
+	return len(rest) > 0 && !strings.HasPrefix(rest[0], "-")
+}
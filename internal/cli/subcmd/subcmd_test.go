@@ -0,0 +1,45 @@
+package subcmd
+
+import (
+	"reflect"
+	"testing"
+
+	flag "github.com/spf13/pflag"
+)
+
+func TestArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "Subcommand only", args: []string{"map"}, want: []string{}},
+		{name: "Image named like the subcommand", args: []string{"map", "map"}, want: []string{"map"}},
+		{name: "Image named like a parent command", args: []string{"device", "map", "device"}, want: []string{"map", "device"}},
+		{name: "Flags before", args: []string{"-v", "--sysfs", "map", "unmap", "-f", "rbd/map"}, want: []string{"-f", "rbd/map"}},
+		{name: "Flag with value", args: []string{"--sysfs=/sys", "-n", "unmap", "img"}, want: []string{"img"}},
+		{name: "Short flag with value", args: []string{"-vs", "/sys", "unmap", "img"}, want: []string{"img"}},
+		{name: "Short flag with attached value", args: []string{"-s/sys", "unmap", "img"}, want: []string{"img"}},
+		{name: "Unknown flag with value", args: []string{"--pool", "rbd", "map", "img"}, want: []string{"img"}},
+		{name: "Unknown flag before flag", args: []string{"--read-only", "-v", "map", "img"}, want: []string{"img"}},
+		{name: "After --", args: []string{"-v", "--", "map", "img"}, want: []string{"img"}},
+		{name: "No subcommand", args: []string{"-v"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("root", flag.ContinueOnError)
+			fs.BoolP("verbose", "v", false, "")
+			fs.BoolP("noop", "n", false, "")
+			fs.StringP("sysfs", "s", "", "")
+			fs.ParseErrorsWhitelist.UnknownFlags = true
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got := Args(fs, tt.args)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Args() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"io"
 	"log"
 	"os"
-	"testing/iotest"
 
 	"github.com/bensallen/rbd/pkg/krbd"
 	flag "github.com/spf13/pflag"
@@ -15,15 +14,16 @@ import (
 const usageHeader = `unmap - Unmap RBD Image
 
 Usage:
-  unmap
+  unmap [/dev/rbdN | /dev/rbd/<pool>/[<ns>/]<image>[@<snap>] | [<pool>/[<ns>/]]<image>[@<snap>]]
 
 Flags:
 `
 
 var (
-	flags = flag.NewFlagSet("unmap", flag.ContinueOnError)
-	devid = flags.IntP("devid", "d", -1, "RBD Device ID")
-	force = flags.BoolP("force", "f", false, "Optional force argument will wait for running requests and then unmap the image")
+	flags       = flag.NewFlagSet("unmap", flag.ContinueOnError)
+	devid       = flags.IntP("devid", "d", -1, "RBD Device ID")
+	force       = flags.BoolP("force", "f", false, "Optional force argument will wait for running requests and then unmap the image")
+	allMatching = flags.BoolP("all-matching", "a", false, "Unmap every device matching the image spec instead of failing when more than one matches")
 )
 
 // Usage of the unmap subcommand
@@ -38,6 +38,11 @@ func usageErr(err error) {
 	fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
 }
 
+// target returns the positional argument following the subcommand, if any.
+func target() string {
+	return flags.Arg(0)
+}
+
 // Run the unmap subcommand
 func Run(args []string, verbose bool, noop bool) error {
 	flags.ParseErrorsWhitelist.UnknownFlags = true
@@ -47,33 +52,63 @@ func Run(args []string, verbose bool, noop bool) error {
 		os.Exit(2)
 	}
 
-	if *devid == -1 {
-		usageErr(errors.New("Device ID not specified"))
+	t := target()
+	switch {
+	case *devid == -1 && t == "":
+		usageErr(errors.New("Device ID, path, or image spec not specified"))
 		os.Exit(2)
+	case *devid != -1 && t != "":
+		usageErr(errors.New("Device ID and a device path or image spec are mutually exclusive"))
+		os.Exit(2)
+	}
+
+	ids := []int{*devid}
+	if t != "" {
+		devices, err := krbd.FindDevices(t)
+		if err != nil {
+			return err
+		}
+		if len(devices) > 1 && !*allMatching {
+			return &krbd.MultipleMatchesError{Target: t, Devices: devices}
+		}
+		ids = ids[:0]
+		for _, d := range devices {
+			ids = append(ids, int(d.ID))
+		}
+	}
+
+	images := make([]krbd.Image, len(ids))
+	for n, id := range ids {
+		images[n] = krbd.Image{
+			DevID: id,
+			Options: &krbd.Options{
+				Force: *force,
+			},
+		}
+	}
+
+	if noop {
+		for _, i := range images {
+			log.Printf("unmap %d", i.DevID)
+		}
+		return nil
 	}
 
 	wc, err := krbd.RBDBusRemoveWriter()
-	defer wc.Close()
-	w := wc.(io.Writer)
 	if err != nil {
 		return err
 	}
+	defer wc.Close()
+	w := io.Writer(wc)
 
 	if verbose {
-		w = iotest.NewWriteLogger("unmap", w)
+		w = krbd.NewWriteLogger("unmap", w)
 	}
 
-	i := krbd.Image{
-		DevID: *devid,
-		Options: &krbd.Options{
-			Force: *force,
-		},
-	}
-
-	if noop {
-		log.Printf("%s", i)
-	} else {
-		return i.Unmap(w)
+	for _, i := range images {
+		if err := i.Unmap(w); err != nil {
+			return fmt.Errorf("could not unmap %s: %v", krbd.DefaultClient.DevPath(krbd.Device{ID: int64(i.DevID)}), err)
+		}
 	}
 	return nil
 }
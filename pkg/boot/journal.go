@@ -0,0 +1,128 @@
+package boot
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Kinds of actions recorded in a Journal.
+const (
+	ActionMap     = "map"
+	ActionLoop    = "loop"
+	ActionMkdir   = "mkdir"
+	ActionMount   = "mount"
+	ActionOverlay = "overlay"
+)
+
+// Action is a step performed while booting, eg. mapping an image, along with how
+// to undo it.
+type Action struct {
+	Kind   string
+	Target string
+	Undo   func() error
+}
+
+func (a Action) String() string {
+	return a.Kind + " " + a.Target
+}
+
+// Journal records the actions performed while booting so that a failed boot can
+// be rolled back, leaving no mapped devices or mounts behind. It is safe for
+// concurrent use. Actions of one goroutine are undone in the reverse order they
+// were recorded in.
+type Journal struct {
+	mu      sync.Mutex
+	actions []Action
+
+	// mkdirMu serializes MkdirAll so a parent directory is always recorded
+	// before the directories created in it.
+	mkdirMu sync.Mutex
+}
+
+// Record adds an action of kind on target, undone by calling undo.
+func (j *Journal) Record(kind string, target string, undo func() error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.actions = append(j.actions, Action{Kind: kind, Target: target, Undo: undo})
+}
+
+// Actions returns the recorded actions in the order they were performed.
+func (j *Journal) Actions() []Action {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]Action(nil), j.actions...)
+}
+
+// Merge moves the actions of other to the end of j, eg. those of a mount that
+// succeeded into the journal of the whole boot.
+func (j *Journal) Merge(other *Journal) {
+	other.mu.Lock()
+	actions := other.actions
+	other.actions = nil
+	other.mu.Unlock()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.actions = append(j.actions, actions...)
+}
+
+// Rollback undoes the recorded actions in reverse order, and clears the
+// journal. An action failing to undo doesn't stop the others from being undone,
+// the errors of all that failed are returned together.
+func (j *Journal) Rollback() error {
+	j.mu.Lock()
+	actions := j.actions
+	j.actions = nil
+	j.mu.Unlock()
+
+	var errs []string
+	for i := len(actions) - 1; i >= 0; i-- {
+		if err := actions[i].Undo(); err != nil {
+			errs = append(errs, fmt.Sprintf("undo %s: %v", actions[i], err))
+		}
+	}
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// MkdirAll is os.MkdirAll, recording each directory it creates so that a
+// rollback removes them again. A directory created meanwhile by someone else,
+// eg. for a mount with its own journal, isn't recorded.
+func (j *Journal) MkdirAll(path string, perm os.FileMode) error {
+	j.mkdirMu.Lock()
+	defer j.mkdirMu.Unlock()
+
+	path = filepath.Clean(path)
+	var missing []string
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		missing = append(missing, dir)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		dir := missing[i]
+		if err := os.Mkdir(dir, perm); errors.Is(err, os.ErrExist) {
+			if fi, serr := os.Stat(dir); serr == nil && fi.IsDir() {
+				continue
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+		j.Record(ActionMkdir, dir, func() error { return os.Remove(dir) })
+	}
+	return nil
+}
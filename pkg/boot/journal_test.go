@@ -0,0 +1,124 @@
+package boot
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJournal_Rollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    map[string]bool
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "Reverse order",
+			want: []string{"overlay /newroot", "mount /lower/var", "mount /lower", "map rbd/root"},
+		},
+		{
+			name:    "Continues after an error",
+			fail:    map[string]bool{"mount /lower": true},
+			want:    []string{"overlay /newroot", "mount /lower/var", "mount /lower", "map rbd/root"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var j Journal
+			var got []string
+			record := func(kind, target string) {
+				a := Action{Kind: kind, Target: target}
+				j.Record(kind, target, func() error {
+					got = append(got, a.String())
+					if tt.fail[a.String()] {
+						return errors.New("busy")
+					}
+					return nil
+				})
+			}
+			record(ActionMap, "rbd/root")
+			record(ActionMount, "/lower")
+			record(ActionMount, "/lower/var")
+			record(ActionOverlay, "/newroot")
+
+			err := j.Rollback()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Journal.Rollback() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "undo mount /lower: busy") {
+				t.Errorf("Journal.Rollback() error = %v, want the failed action", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Journal.Rollback() undid %v, want %v", got, tt.want)
+			}
+			if len(j.Actions()) != 0 {
+				t.Errorf("Journal.Actions() = %v after rollback, want none", j.Actions())
+			}
+		})
+	}
+}
+
+func TestJournal_MkdirAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "newroot"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var j Journal
+	path := filepath.Join(dir, "newroot", "var", "lib")
+	if err := j.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range j.Actions() {
+		got = append(got, a.String())
+	}
+	want := []string{"mkdir " + filepath.Join(dir, "newroot", "var"), "mkdir " + path}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Journal.MkdirAll() recorded %v, want %v", got, want)
+	}
+
+	if err := j.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "newroot", "var")); !os.IsNotExist(err) {
+		t.Errorf("Journal.Rollback() left %s/newroot/var: %v", dir, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "newroot")); err != nil {
+		t.Errorf("Journal.Rollback() removed the existing %s/newroot: %v", dir, err)
+	}
+}
+
+func TestJournal_Merge(t *testing.T) {
+	var boot, mnt Journal
+	boot.Record(ActionMap, "rbd/root", func() error { return nil })
+	mnt.Record(ActionMap, "rbd/var", func() error { return nil })
+	mnt.Record(ActionMount, "/newroot/var", func() error { return nil })
+	boot.Merge(&mnt)
+
+	var got []string
+	for _, a := range boot.Actions() {
+		got = append(got, a.String())
+	}
+	want := []string{"map rbd/root", "map rbd/var", "mount /newroot/var"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Journal.Merge() = %v, want %v", got, want)
+	}
+	if len(mnt.Actions()) != 0 {
+		t.Errorf("Journal.Actions() = %v of the merged journal, want none", mnt.Actions())
+	}
+}
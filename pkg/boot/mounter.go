@@ -0,0 +1,305 @@
+package boot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bensallen/rbd/pkg/cmdline"
+	"github.com/bensallen/rbd/pkg/krbd"
+	"github.com/bensallen/rbd/pkg/mount"
+	"golang.org/x/sys/unix"
+)
+
+// Mounter maps the images of mounts and mounts them, as done by rbd boot and
+// rbd device map-all.
+type Mounter struct {
+	// Writer is where the map commands are written to, see
+	// krbd.RBDBusAddWriter.
+	Writer io.Writer
+	// Keyring, if not nil, is the kernel keyring the secret of each image is
+	// added to, to map it via key= instead of secret=.
+	Keyring krbd.KernelKeyring
+	// Retry and Timeout are the defaults for retrying the map, and the overall
+	// deadline to map an image and wait for its device, which mounts can
+	// override.
+	Retry   krbd.Retry
+	Timeout time.Duration
+	// Check the monitors of each image are reachable before mapping it.
+	Check bool
+	// Reuse an already mapped device of the image instead of mapping it again,
+	// and skip mounting a path that is already mounted from it.
+	Reuse bool
+	// Mkdir creates the mount path if it doesn't exist.
+	Mkdir bool
+	// Prefix is prepended to the path of each mount, eg. /newroot.
+	Prefix string
+	// ForceUnmap unmaps devices with the force option when rolled back.
+	ForceUnmap bool
+	// Logf, if not nil, is called with each step taken.
+	Logf func(format string, v ...interface{})
+}
+
+func (m *Mounter) logf(format string, v ...interface{}) {
+	if m.Logf != nil {
+		m.Logf(format, v...)
+	}
+}
+
+// Mount maps the image of mnt, waiting for it and the partition if any to
+// appear, and mounts it under Prefix, unless it has no path. Each step is
+// recorded in j.
+func (m *Mounter) Mount(j *Journal, mnt *cmdline.Mount) error {
+	if mnt.Path == "" {
+		return m.MountAt(j, mnt, "")
+	}
+	return m.MountAt(j, mnt, m.Prefix+mnt.Path)
+}
+
+// MountAt is Mount at path instead of the path of mnt, eg. for a lower layer
+// of an overlay. Mkdir applies to path, and if path is empty the image is only
+// mapped.
+func (m *Mounter) MountAt(j *Journal, mnt *cmdline.Mount, path string) error {
+	if m.Keyring != nil {
+		if err := mnt.Image.LoadKey(m.Keyring); err != nil {
+			return err
+		}
+	}
+
+	// Map the RBD device, retrying transient errors, and wait for it, and the
+	// partition if any, to appear
+	retry := m.Retry
+	if mnt.Retries != nil {
+		retry.Retries = *mnt.Retries
+	}
+	if mnt.Backoff != 0 {
+		retry.Backoff = time.Duration(mnt.Backoff)
+	}
+	deadline := m.Timeout
+	if mnt.Timeout != 0 {
+		deadline = time.Duration(mnt.Timeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+
+	dev, err := m.mapped(mnt.Image)
+	if err != nil {
+		return err
+	}
+	if dev == nil {
+		if m.Check {
+			err := retry.Do(ctx, func() error {
+				report := mnt.Image.CheckMonitors(ctx, krbd.DefaultProbeTimeout)
+				for _, c := range report {
+					m.logf("monitor %s", c)
+				}
+				return report.Err()
+			})
+			if err != nil {
+				return fmt.Errorf("%s: %w", mnt.Image.Spec(), err)
+			}
+		}
+		d, err := mnt.Image.MapAndWaitRetry(ctx, m.Writer, retry)
+		// The device may have been mapped even though its node never appeared
+		if d.Image != "" {
+			j.Record(ActionMap, mnt.Image.Spec(), func() error { return m.unmap(d) })
+		}
+		if err != nil {
+			return err
+		}
+		dev = &d
+	}
+	devPath := krbd.DefaultClient.DevPath(*dev)
+	// Not %#v, config_info may include the secret
+	m.logf("device found %s for %s", devPath, mnt.Image.Spec())
+	if path == "" {
+		return nil
+	}
+	if m.Reuse {
+		source, err := mountedFrom(path, devPath)
+		if err != nil {
+			return err
+		}
+		if source != "" {
+			m.logf("%s already mounted from %s", path, source)
+			return nil
+		}
+	}
+
+	readOnly := mnt.Image.Options != nil && mnt.Image.Options.ReadOnly
+	fsType := mnt.FsType
+	switch {
+	case mnt.Part != "":
+		devPath, err = partitionDevice(ctx, j, *dev, mnt.Part, readOnly)
+	case mnt.UUID != "" || mnt.Label != "":
+		var probed string
+		devPath, probed, err = filesystemDevice(ctx, j, *dev, mnt.UUID, mnt.Label, readOnly)
+		if fsType == "" {
+			fsType = probed
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if devPath != krbd.DefaultClient.DevPath(*dev) {
+		m.logf("using %s of %s", devPath, mnt.Image.Spec())
+	}
+
+	if m.Mkdir {
+		if err := j.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+	}
+
+	// Attempt to mount the device
+	if err := mount.Mount(devPath, path, fsType, mnt.MountOpts); err != nil {
+		return err
+	}
+	j.Record(ActionMount, path, func() error { return mount.Unmount(path, false, false) })
+	return nil
+}
+
+// Overlay mounts a R/W overlay on dest of the lower directories, the top most
+// first, which are expected to be mounted. The upper layer configured by o, a
+// tmpfs, an image, or a local disk, is mounted on dir, which holds the upper
+// and work directories of the overlay. Each step is recorded in j.
+func (m *Mounter) Overlay(j *Journal, o cmdline.Overlay, lower []string, dir string, dest string) error {
+	if err := j.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	switch {
+	case o.Image != nil:
+		upper := &cmdline.Mount{Image: o.Image, FsType: o.FsType, MountOpts: o.MountOpts}
+		if err := m.MountAt(j, upper, dir); err != nil {
+			return fmt.Errorf("overlay upper layer: %w", err)
+		}
+	case o.Label != "":
+		dev, fs, err := mount.FindBlockDevice(krbd.DefaultClient.SysRoot, krbd.DefaultClient.DevRoot, "", o.Label)
+		if err != nil {
+			return fmt.Errorf("overlay upper layer %s: %w", o.Label, err)
+		}
+		m.logf("using %s labeled %s as overlay upper layer", dev, o.Label)
+		fsType := o.FsType
+		if fsType == "" {
+			fsType = fs.Type
+		}
+		if err := mount.Mount(dev, dir, fsType, o.MountOpts); err != nil {
+			return err
+		}
+		j.Record(ActionMount, dir, func() error { return mount.Unmount(dir, false, false) })
+	default:
+		if err := mount.Tmpfs(dir, o.Size); err != nil {
+			return err
+		}
+		j.Record(ActionMount, dir, func() error { return mount.Unmount(dir, false, false) })
+	}
+
+	upper, work := dir+"/upper", dir+"/work"
+	for _, d := range []string{upper, work, dest} {
+		if err := j.MkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+	if err := mount.Overlay(lower, upper, work, dest); err != nil {
+		return err
+	}
+	j.Record(ActionOverlay, dest, func() error { return mount.Unmount(dest, false, false) })
+	return nil
+}
+
+// mapped returns the device the image is already mapped to if Reuse is set,
+// nil if there is none.
+func (m *Mounter) mapped(i *krbd.Image) (*krbd.Device, error) {
+	if !m.Reuse {
+		return nil, nil
+	}
+	devices, err := krbd.DefaultClient.FindDevices(i.Spec())
+	if errors.Is(err, krbd.ErrNoMatch) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) > 1 {
+		return nil, &krbd.MultipleMatchesError{Target: i.Spec(), Devices: devices}
+	}
+	m.logf("%s already mapped", i.Spec())
+	return &devices[0], nil
+}
+
+// mountedFrom returns the source path is mounted from if it is devPath, one of
+// its partitions, or a loop device attached to it, eg. by an earlier run of rbd
+// device map-all, otherwise "".
+func mountedFrom(path string, devPath string) (string, error) {
+	source, err := mount.Source(path)
+	if err != nil || source == "" {
+		return "", err
+	}
+	if source == devPath || strings.HasPrefix(source, devPath+"p") {
+		return source, nil
+	}
+	loops, err := mount.LoopDevices(devPath)
+	if err != nil {
+		return "", err
+	}
+	for _, l := range loops {
+		if source == l {
+			return source, nil
+		}
+	}
+	return "", nil
+}
+
+// unmap unmaps dev, with the force option if ForceUnmap is set.
+func (m *Mounter) unmap(dev krbd.Device) error {
+	i := &krbd.Image{DevID: int(dev.ID), Options: &krbd.Options{Force: m.ForceUnmap}}
+	return krbd.DefaultClient.Unmap(i)
+}
+
+// Unmount undoes Mount for mnt without a Journal, eg. of an earlier run for rbd
+// device unmap-all. The path of mnt under Prefix is unmounted if mounted, and
+// every device the image is mapped to is unmapped, after detaching loop
+// devices attached to it.
+func (m *Mounter) Unmount(mnt *cmdline.Mount) error {
+	if mnt.Path != "" {
+		path := m.Prefix + mnt.Path
+		err := mount.Unmount(path, false, false)
+		switch {
+		case err == nil:
+			m.logf("unmounted %s", path)
+		case errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOENT):
+			// Not mounted
+		default:
+			return fmt.Errorf("unmounting %s: %w", path, err)
+		}
+	}
+
+	devices, err := krbd.DefaultClient.FindDevices(mnt.Image.Spec())
+	if errors.Is(err, krbd.ErrNoMatch) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, dev := range devices {
+		devPath := krbd.DefaultClient.DevPath(dev)
+		loops, err := mount.LoopDevices(devPath)
+		if err != nil {
+			return err
+		}
+		for _, l := range loops {
+			if err := mount.LoopDetach(l); err != nil {
+				return fmt.Errorf("detaching %s from %s: %w", l, devPath, err)
+			}
+			m.logf("detached %s from %s", l, devPath)
+		}
+		if err := m.unmap(dev); err != nil {
+			return fmt.Errorf("unmapping %s: %w", devPath, err)
+		}
+		m.logf("unmapped %s of %s", devPath, mnt.Image.Spec())
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package boot
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bensallen/rbd/pkg/krbd"
+	"github.com/bensallen/rbd/pkg/mount"
+	"github.com/bensallen/rbd/pkg/partition"
+)
+
+// partitionDevice returns the path of the partition of dev matching part, see
+// partition.Table.Find.
+func partitionDevice(ctx context.Context, j *Journal, dev krbd.Device, part string, readOnly bool) (string, error) {
+	path := krbd.DefaultClient.DevPath(dev)
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	table, err := partition.Read(f)
+	f.Close()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	p, err := table.Find(part)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return partitionPath(ctx, j, dev, p, readOnly)
+}
+
+// filesystemDevice returns the path of the whole device dev, or of its
+// partition, holding the filesystem or partition with the uuid or label, along
+// with the type of the filesystem if it was recognized.
+func filesystemDevice(ctx context.Context, j *Journal, dev krbd.Device, uuid string, label string, readOnly bool) (string, string, error) {
+	path := krbd.DefaultClient.DevPath(dev)
+	m, err := mount.FindFilesystemFile(path, uuid, label)
+	if err != nil {
+		return "", "", err
+	}
+	if m.Partition != nil {
+		path, err = partitionPath(ctx, j, dev, *m.Partition, readOnly)
+	}
+	return path, m.Filesystem.Type, err
+}
+
+// partitionPath waits for the kernel's device of partition p of dev, or when
+// the kernel didn't scan the partition table attaches a loop device to the
+// partition instead, recording it in j.
+func partitionPath(ctx context.Context, j *Journal, dev krbd.Device, p partition.Partition, readOnly bool) (string, error) {
+	if krbd.DefaultClient.HasPartition(dev, p.Number) {
+		return krbd.DefaultClient.WaitPartition(ctx, dev, p.Number)
+	}
+	loopDevice, err := mount.LoopSetupRange(krbd.DefaultClient.DevPath(dev), p.Start, p.Size, readOnly)
+	if err != nil {
+		return "", err
+	}
+	j.Record(ActionLoop, loopDevice, func() error { return mount.LoopDetach(loopDevice) })
+	return loopDevice, nil
+}
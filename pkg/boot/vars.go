@@ -0,0 +1,78 @@
+package boot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NodeVars returns the values of the variables of image name templates on this
+// node, see cmdline.Expand, read from sysfs at sysRoot:
+//
+//	hostname  the hostname up to the first dot, eg. as set by ip= or DHCP
+//	mac       the MAC address of the first Ethernet interface that is up, or else of the first one, eg. 52-54-00-12-34-56
+//	serial    the DMI system serial number
+//
+// Characters that can't be part of an image name are replaced by _. Variables
+// that can't be determined are left out.
+func NodeVars(sysRoot string) map[string]string {
+	hostname, _ := os.Hostname()
+	return nodeVars(sysRoot, hostname)
+}
+
+func nodeVars(sysRoot string, hostname string) map[string]string {
+	vars := map[string]string{}
+	// (none) is the hostname of the kernel until one is set
+	if hostname != "" && hostname != "(none)" {
+		vars["hostname"] = strings.SplitN(hostname, ".", 2)[0]
+	}
+	if mac := nodeMAC(filepath.Join(sysRoot, "class", "net")); mac != "" {
+		vars["mac"] = strings.Replace(strings.ToLower(mac), ":", "-", -1)
+	}
+	if serial, err := ioutil.ReadFile(filepath.Join(sysRoot, "class", "dmi", "id", "product_serial")); err == nil {
+		if s := strings.TrimSpace(string(serial)); s != "" {
+			vars["serial"] = s
+		}
+	}
+	for k, v := range vars {
+		vars[k] = strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+				return r
+			}
+			return '_'
+		}, v)
+	}
+	return vars
+}
+
+// nodeMAC returns the address of the first Ethernet interface in the sysfs
+// directory dir, in name order, that is up, or else of the first one.
+func nodeMAC(dir string) string {
+	ifaces, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	var first string
+	for _, i := range ifaces {
+		attr := func(name string) string {
+			b, _ := ioutil.ReadFile(filepath.Join(dir, i.Name(), name))
+			return strings.TrimSpace(string(b))
+		}
+		// ARPHRD_ETHER, which excludes the loopback interface
+		if attr("type") != "1" {
+			continue
+		}
+		addr := attr("address")
+		if addr == "" || addr == "00:00:00:00:00:00" {
+			continue
+		}
+		if attr("operstate") == "up" {
+			return addr
+		}
+		if first == "" {
+			first = addr
+		}
+	}
+	return first
+}
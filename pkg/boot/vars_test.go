@@ -0,0 +1,77 @@
+package boot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_nodeVars(t *testing.T) {
+	type iface struct {
+		name, typ, address, operstate string
+	}
+	tests := []struct {
+		name     string
+		hostname string
+		ifaces   []iface
+		serial   string
+		want     map[string]string
+	}{
+		{
+			name:     "All",
+			hostname: "node1.example.com",
+			ifaces: []iface{
+				{"eno1", "1", "52:54:00:AA:BB:01", "down"},
+				{"eno2", "1", "52:54:00:AA:BB:02", "up"},
+				{"lo", "772", "00:00:00:00:00:00", "unknown"},
+			},
+			serial: "SN 1234/5\n",
+			want:   map[string]string{"hostname": "node1", "mac": "52-54-00-aa-bb-02", "serial": "SN_1234_5"},
+		},
+		{
+			name:     "None up",
+			hostname: "(none)",
+			ifaces: []iface{
+				{"eno1", "1", "52:54:00:aa:bb:01", "down"},
+				{"eno2", "1", "52:54:00:aa:bb:02", "down"},
+			},
+			want: map[string]string{"mac": "52-54-00-aa-bb-01"},
+		},
+		{
+			name: "Nothing",
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sys, err := ioutil.TempDir("", "sys")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(sys)
+			write := func(name string, data string) {
+				name = filepath.Join(sys, name)
+				if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, i := range tt.ifaces {
+				write(filepath.Join("class", "net", i.name, "type"), i.typ+"\n")
+				write(filepath.Join("class", "net", i.name, "address"), i.address+"\n")
+				write(filepath.Join("class", "net", i.name, "operstate"), i.operstate+"\n")
+			}
+			if tt.serial != "" {
+				write(filepath.Join("class", "dmi", "id", "product_serial"), tt.serial)
+			}
+
+			if got := nodeVars(sys, tt.hostname); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nodeVars() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
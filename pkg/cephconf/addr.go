@@ -0,0 +1,226 @@
+package cephconf
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/bensallen/rbd/pkg/krbd"
+)
+
+const (
+	// LegacyPort is the default monitor port of the v1 (legacy) messenger protocol.
+	LegacyPort = krbd.LegacyMonPort
+	// Msgr2Port is the default monitor port of the v2 messenger protocol.
+	Msgr2Port = krbd.Msgr2MonPort
+
+	// DefaultMsMode is the ms_mode map option used to reach monitors that
+	// only have v2 addresses, which accepts both the crc and secure modes.
+	DefaultMsMode = "prefer-crc"
+)
+
+// Addr is a single monitor address, eg. v2:192.168.0.1:3300.
+type Addr struct {
+	// Type is v1, v2, or empty when the address didn't specify the protocol.
+	Type string
+	Host string
+	// Port is 0 when the address didn't specify a port.
+	Port int
+}
+
+// String returns the address as host[:port] without the type, IPv6 addresses
+// are enclosed in brackets when a port is present.
+func (a Addr) String() string {
+	if a.Port == 0 {
+		return a.Host
+	}
+	return net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+}
+
+// AddrVec are the addresses a single monitor listens on, eg.
+// [v2:192.168.0.1:3300,v1:192.168.0.1:6789].
+type AddrVec []Addr
+
+// Krbd returns the address krbd should use to reach the monitor. krbd only
+// takes a single address per monitor, the v1 address is preferred as it is
+// supported by every kernel, otherwise v2 is used which requires the ms_mode
+// map option, see Config.Monitors. An address without a type is used as is.
+func (v AddrVec) Krbd() string {
+	if a, ok := v.find("v1"); ok {
+		return a.String()
+	}
+	if a, ok := v.find(""); ok {
+		return a.String()
+	}
+	if len(v) != 0 {
+		return v[0].String()
+	}
+	return ""
+}
+
+// find returns the first address of type typ.
+func (v AddrVec) find(typ string) (Addr, bool) {
+	for _, a := range v {
+		if a.Type == typ {
+			return a, true
+		}
+	}
+	return Addr{}, false
+}
+
+// msgr2Only reports whether the monitor can only be reached via the v2
+// protocol.
+func (v AddrVec) msgr2Only() bool {
+	_, v1 := v.find("v1")
+	_, untyped := v.find("")
+	return len(v) != 0 && !v1 && !untyped
+}
+
+// ParseMonHost parses the value of mon_host, a list of monitors separated by
+// commas, semicolons, or spaces. Each monitor is either a single address, eg.
+// 192.168.0.1, 192.168.0.1:6789, [::1]:6789, v2:192.168.0.1:3300, or mon1.example.com
+// or a bracketed address vector, eg. [v2:192.168.0.1:3300,v1:192.168.0.1:6789].
+func ParseMonHost(s string) ([]AddrVec, error) {
+	var vecs []AddrVec
+	for len(s) > 0 {
+		switch c := s[0]; {
+		case c == ',' || c == ';' || c == ' ' || c == '\t':
+			s = s[1:]
+		case c == '[' && isTyped(s[1:]):
+			end := closing(s)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated address vector %q", s)
+			}
+			var vec AddrVec
+			for _, a := range splitVec(s[1:end]) {
+				addr, err := parseAddr(strings.TrimSpace(a))
+				if err != nil {
+					return nil, err
+				}
+				vec = append(vec, addr)
+			}
+			vecs = append(vecs, vec)
+			s = s[end+1:]
+		default:
+			end := len(s)
+			// Don't split within brackets of an IPv6 address.
+			from := 0
+			if c == '[' {
+				from = strings.IndexRune(s, ']')
+				if from < 0 {
+					return nil, fmt.Errorf("unterminated IPv6 address %q", s)
+				}
+			}
+			if n := strings.IndexAny(s[from:], ",; \t"); n >= 0 {
+				end = from + n
+			}
+			addr, err := parseAddr(s[:end])
+			if err != nil {
+				return nil, err
+			}
+			vecs = append(vecs, AddrVec{addr})
+			s = s[end:]
+		}
+	}
+	return vecs, nil
+}
+
+// closing returns the index of the bracket closing the one s starts with, or -1.
+func closing(s string) int {
+	depth := 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitVec splits the addresses of an address vector on commas outside of
+// the brackets of IPv6 addresses.
+func splitVec(s string) []string {
+	var out []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				out = append(out, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(out, s[start:])
+}
+
+func isTyped(s string) bool {
+	return strings.HasPrefix(s, "v1:") || strings.HasPrefix(s, "v2:") || strings.HasPrefix(s, "any:")
+}
+
+// parseAddr parses a single [type:]host[:port][/nonce] address.
+func parseAddr(s string) (Addr, error) {
+	a := Addr{}
+	orig := s
+	if isTyped(s) {
+		n := strings.IndexRune(s, ':')
+		a.Type, s = s[:n], s[n+1:]
+		if a.Type == "any" {
+			a.Type = ""
+		}
+	}
+	// Drop the nonce, eg. 192.168.0.1:6789/0
+	if n := strings.IndexRune(s, '/'); n >= 0 {
+		s = s[:n]
+	}
+	if s == "" {
+		return a, fmt.Errorf("empty address %q", orig)
+	}
+
+	host, port := s, ""
+	switch {
+	case s[0] == '[':
+		end := strings.IndexRune(s, ']')
+		if end < 0 {
+			return a, fmt.Errorf("unterminated IPv6 address %q", orig)
+		}
+		host = s[1:end]
+		if rest := s[end+1:]; rest != "" {
+			if rest[0] != ':' {
+				return a, fmt.Errorf("invalid address %q", orig)
+			}
+			port = rest[1:]
+		}
+	case strings.Count(s, ":") == 1:
+		n := strings.IndexRune(s, ':')
+		host, port = s[:n], s[n+1:]
+	case strings.Count(s, ":") > 1:
+		// Bare IPv6 address without a port
+		if net.ParseIP(s) == nil {
+			return a, fmt.Errorf("invalid address %q", orig)
+		}
+	}
+	if host == "" {
+		return a, fmt.Errorf("empty host in address %q", orig)
+	}
+	a.Host = host
+
+	if port != "" {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || p == 0 {
+			return a, fmt.Errorf("invalid port in address %q", orig)
+		}
+		a.Port = int(p)
+	}
+	return a, nil
+}
@@ -0,0 +1,267 @@
+// Package cephconf parses the subset of ceph.conf needed to map RBD images, in
+// particular the monitor addresses, fsid, and keyring location.
+package cephconf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bensallen/rbd/pkg/krbd"
+)
+
+// DefaultPath is the default location of ceph.conf.
+const DefaultPath = "/etc/ceph/ceph.conf"
+
+// DefaultCluster is the cluster name used to expand $cluster.
+const DefaultCluster = "ceph"
+
+// defaultKeyring is the upstream default keyring search path.
+const defaultKeyring = "/etc/ceph/$cluster.$name.keyring,/etc/ceph/$cluster.keyring,/etc/ceph/keyring"
+
+// Config is a parsed ceph.conf. Option names are normalized so that spaces,
+// underscores, and dashes are equivalent, eg. "mon host" and "mon_host".
+type Config struct {
+	Cluster  string
+	sections map[string]map[string]string
+}
+
+// Read parses the ceph.conf at path.
+func Read(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Parse parses ceph.conf INI syntax. Comments start with # or ;, both on their
+// own line or following a value, and a trailing backslash continues a line.
+func Parse(r io.Reader) (*Config, error) {
+	c := &Config{Cluster: DefaultCluster, sections: map[string]map[string]string{}}
+	section := ""
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		start := n
+		for strings.HasSuffix(line, "\\") && s.Scan() {
+			n++
+			line = line[:len(line)-1] + s.Text()
+		}
+		line = strings.TrimSpace(stripComment(line))
+		switch {
+		case line == "":
+			continue
+		case line[0] == '[':
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("line %d: unterminated section", start)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if _, ok := c.sections[section]; !ok {
+				c.sections[section] = map[string]string{}
+			}
+		default:
+			eq := strings.IndexRune(line, '=')
+			if eq < 0 {
+				return nil, fmt.Errorf("line %d: expected key = value", start)
+			}
+			if section == "" {
+				return nil, fmt.Errorf("line %d: option outside of a section", start)
+			}
+			key := normalize(line[:eq])
+			if key == "" {
+				return nil, fmt.Errorf("line %d: empty option name", start)
+			}
+			c.sections[section][key] = unquote(strings.TrimSpace(line[eq+1:]))
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get returns the value of the option key for the entity, eg. client.admin.
+// Like Ceph the [<entity>] section is consulted first, then the section of the
+// entity type, eg. [client], and finally [global].
+func (c *Config) Get(entity string, key string) (string, bool) {
+	key = normalize(key)
+	for _, section := range c.lookupOrder(entity) {
+		if v, ok := c.sections[section][key]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func (c *Config) lookupOrder(entity string) []string {
+	order := []string{}
+	if entity != "" {
+		order = append(order, entity)
+		if n := strings.IndexRune(entity, '.'); n > 0 {
+			order = append(order, entity[:n])
+		}
+	}
+	return append(order, "global")
+}
+
+// Fsid returns the cluster fsid, if set.
+func (c *Config) Fsid() string {
+	v, _ := c.Get("", "fsid")
+	return v
+}
+
+// MonAddrs returns the address vector of every monitor, taken from mon_host or
+// if not set, the mon_addr option of each [mon.<id>] section.
+func (c *Config) MonAddrs() ([]AddrVec, error) {
+	if v, ok := c.Get("", "mon host"); ok && v != "" {
+		return ParseMonHost(v)
+	}
+	sections := []string{}
+	for section := range c.sections {
+		if strings.HasPrefix(section, "mon.") {
+			sections = append(sections, section)
+		}
+	}
+	sort.Strings(sections)
+
+	var mons []AddrVec
+	for _, section := range sections {
+		if v, ok := c.sections[section]["mon_addr"]; ok && v != "" {
+			vecs, err := ParseMonHost(v)
+			if err != nil {
+				return nil, fmt.Errorf("[%s] mon_addr: %v", section, err)
+			}
+			mons = append(mons, vecs...)
+		}
+	}
+	return mons, nil
+}
+
+// Monitors returns the monitor addresses in the form expected by krbd, one
+// per monitor, see AddrVec.Krbd, and the ms_mode map option needed to reach
+// them. The ms_mode is empty when every monitor has a v1 address, otherwise it
+// is DefaultMsMode and the v2 addresses are returned, as ms_mode applies to all
+// monitors. A monitor with only a v1 address among v2 only ones is an error.
+func (c *Config) Monitors() ([]string, string, error) {
+	vecs, err := c.MonAddrs()
+	if err != nil {
+		return nil, "", err
+	}
+	msgr2 := false
+	for _, v := range vecs {
+		msgr2 = msgr2 || v.msgr2Only()
+	}
+	mons := make([]string, 0, len(vecs))
+	for _, v := range vecs {
+		if !msgr2 {
+			mons = append(mons, v.Krbd())
+			continue
+		}
+		a, ok := v.find("v2")
+		if !ok {
+			a, ok = v.find("")
+		}
+		if !ok {
+			return nil, "", fmt.Errorf("monitor %s has no v2 address, which is required as other monitors only have one", v.Krbd())
+		}
+		mons = append(mons, a.String())
+	}
+	if msgr2 {
+		return mons, DefaultMsMode, nil
+	}
+	return mons, "", nil
+}
+
+// Keyring returns the keyring search path for the user, without the "client."
+// prefix, with the $cluster, $name, $type, and $id metavariables expanded.
+func (c *Config) Keyring(user string) []string {
+	v, ok := c.Get("client."+user, "keyring")
+	if !ok || v == "" {
+		v = defaultKeyring
+	}
+	r := strings.NewReplacer("$cluster", c.Cluster, "$name", "client."+user, "$type", "client", "$id", user)
+	paths := []string{}
+	for _, p := range strings.FieldsFunc(v, func(c rune) bool { return c == ',' || c == ';' || c == ' ' }) {
+		paths = append(paths, r.Replace(p))
+	}
+	return paths
+}
+
+// Apply fills attributes of i that aren't set from the Config: the monitors,
+// and the ms_mode they require, the fsid, and if no secret is provided, the
+// first keyring found on the keyring search path of the user.
+func (c *Config) Apply(i *krbd.Image) error {
+	if i.Options == nil {
+		i.Options = &krbd.Options{}
+	}
+	if len(i.Monitors) == 0 {
+		mons, msMode, err := c.Monitors()
+		if err != nil {
+			return err
+		}
+		i.Monitors = mons
+		if i.Options.MsMode == "" {
+			i.Options.MsMode = msMode
+		}
+	}
+	if i.Options.Fsid == "" {
+		i.Options.Fsid = c.Fsid()
+	}
+	if i.Options.Secret == "" && i.Options.Key == "" && i.Keyring == "" && i.Keyfile == "" {
+		user := i.Options.Name
+		if user == "" {
+			user = krbd.DefaultUser
+		}
+		for _, p := range c.Keyring(user) {
+			if _, err := os.Stat(p); err == nil {
+				i.Keyring = p
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// normalize converts an option name to its canonical form, underscores
+// separating lowercase words.
+func normalize(key string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(key), func(c rune) bool {
+		return c == ' ' || c == '_' || c == '-' || c == '\t'
+	}), "_")
+}
+
+// stripComment removes a # or ; comment, ignoring those within quotes.
+func stripComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' || c == ';':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquote removes a single pair of matching surrounding quotes.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
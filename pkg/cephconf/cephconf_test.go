@@ -0,0 +1,250 @@
+package cephconf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bensallen/rbd/pkg/krbd"
+)
+
+const testConf = `# minimal ceph.conf for clients
+[global]
+	fsid = 2b7d5a5c-0a4b-4c5e-9f2e-6b1e3c7f0d11
+	mon_host = [v2:192.168.0.1:3300,v1:192.168.0.1:6789] [v2:192.168.0.2:3300,v1:192.168.0.2:6789], \
+		[v2:192.168.0.3:3300/0,v1:192.168.0.3:6789/0] ; trailing comment
+
+[client]
+	keyring = /etc/ceph/$cluster.$name.keyring
+
+[client.rbd]
+	Keyring = "/etc/ceph/rbd.keyring"
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		conf      string
+		entity    string
+		key       string
+		want      string
+		wantFound bool
+		wantErr   bool
+	}{
+		{name: "Global option", conf: testConf, key: "fsid", want: "2b7d5a5c-0a4b-4c5e-9f2e-6b1e3c7f0d11", wantFound: true},
+		{name: "Equivalent option names", conf: testConf, key: "mon-host", want: "[v2:192.168.0.1:3300,v1:192.168.0.1:6789] [v2:192.168.0.2:3300,v1:192.168.0.2:6789], \t\t[v2:192.168.0.3:3300/0,v1:192.168.0.3:6789/0]", wantFound: true},
+		{name: "Entity section", conf: testConf, entity: "client.rbd", key: "keyring", want: "/etc/ceph/rbd.keyring", wantFound: true},
+		{name: "Type section", conf: testConf, entity: "client.admin", key: "keyring", want: "/etc/ceph/$cluster.$name.keyring", wantFound: true},
+		{name: "Falls back to global", conf: testConf, entity: "client.admin", key: "fsid", want: "2b7d5a5c-0a4b-4c5e-9f2e-6b1e3c7f0d11", wantFound: true},
+		{name: "Missing option", conf: testConf, key: "keyring"},
+		{name: "Quoted comment characters", conf: "[global]\nkey = \"a#b;c\" # comment", key: "key", want: "a#b;c", wantFound: true},
+		{name: "Unterminated section", conf: "[global\n", wantErr: true},
+		{name: "Option outside section", conf: "fsid = x\n", wantErr: true},
+		{name: "Missing equals", conf: "[global]\nfsid\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Parse(strings.NewReader(tt.conf))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, found := c.Get(tt.entity, tt.key)
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("Config.Get() = %q, %v, want %q, %v", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestParseMonHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []AddrVec
+		wantErr bool
+	}{
+		{
+			name: "Bare addresses",
+			s:    "192.168.0.1,192.168.0.2:6789 mon3.example.com;[::1]:6789 fe80::1",
+			want: []AddrVec{
+				{{Host: "192.168.0.1"}},
+				{{Host: "192.168.0.2", Port: 6789}},
+				{{Host: "mon3.example.com"}},
+				{{Host: "::1", Port: 6789}},
+				{{Host: "fe80::1"}},
+			},
+		},
+		{
+			name: "Address vectors",
+			s:    "[v2:192.168.0.1:3300,v1:192.168.0.1:6789],[v2:[::1]:3300/0,v1:[::1]:6789/0]",
+			want: []AddrVec{
+				{{Type: "v2", Host: "192.168.0.1", Port: 3300}, {Type: "v1", Host: "192.168.0.1", Port: 6789}},
+				{{Type: "v2", Host: "::1", Port: 3300}, {Type: "v1", Host: "::1", Port: 6789}},
+			},
+		},
+		{
+			name: "Typed single addresses",
+			s:    "v2:192.168.0.1:3300 any:192.168.0.2",
+			want: []AddrVec{
+				{{Type: "v2", Host: "192.168.0.1", Port: 3300}},
+				{{Host: "192.168.0.2"}},
+			},
+		},
+		{name: "Unterminated vector", s: "[v2:192.168.0.1:3300", wantErr: true},
+		{name: "Invalid port", s: "192.168.0.1:http", wantErr: true},
+		{name: "Empty vector address", s: "[v2:192.168.0.1:3300,]", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMonHost(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMonHost() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMonHost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Monitors(t *testing.T) {
+	tests := []struct {
+		name       string
+		conf       string
+		want       []string
+		wantMsMode string
+		wantErr    bool
+	}{
+		{
+			name: "mon_host prefers v1",
+			conf: testConf,
+			want: []string{"192.168.0.1:6789", "192.168.0.2:6789", "192.168.0.3:6789"},
+		},
+		{
+			name:       "v2 only",
+			conf:       "[global]\nmon host = [v2:192.168.0.1:3300],[v2:[::1]:3300]\n",
+			want:       []string{"192.168.0.1:3300", "[::1]:3300"},
+			wantMsMode: "prefer-crc",
+		},
+		{
+			name:       "v2 only and both",
+			conf:       "[global]\nmon host = [v2:192.168.0.1:3300],[v2:192.168.0.2:3300,v1:192.168.0.2:6789],192.168.0.3\n",
+			want:       []string{"192.168.0.1:3300", "192.168.0.2:3300", "192.168.0.3"},
+			wantMsMode: "prefer-crc",
+		},
+		{
+			name:    "v2 only and v1 only",
+			conf:    "[global]\nmon host = [v2:192.168.0.1:3300],[v1:192.168.0.2:6789]\n",
+			wantErr: true,
+		},
+		{
+			name: "mon sections",
+			conf: "[mon.b]\nmon addr = 192.168.0.2:6789\n[mon.a]\nmon addr = [v2:192.168.0.1:3300,v1:192.168.0.1:6789]\n",
+			want: []string{"192.168.0.1:6789", "192.168.0.2:6789"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Parse(strings.NewReader(tt.conf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, msMode, err := c.Monitors()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Config.Monitors() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Config.Monitors() = %v, want %v", got, tt.want)
+			}
+			if msMode != tt.wantMsMode {
+				t.Errorf("Config.Monitors() ms_mode = %q, want %q", msMode, tt.wantMsMode)
+			}
+		})
+	}
+}
+
+func TestConfig_Apply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cephconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyring := filepath.Join(dir, "ceph.client.admin.keyring")
+	if err := ioutil.WriteFile(keyring, []byte("[client.admin]\n\tkey = AQCvCbtToC6MDhAATtuT70Sl+DymPCfDSsyV4w==\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Parse(strings.NewReader("[global]\nfsid = abc\nmon host = 192.168.0.1\n[client]\nkeyring = " + filepath.Join(dir, "$cluster.$name.keyring") + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		image krbd.Image
+		want  krbd.Image
+	}{
+		{
+			name:  "Empty image",
+			image: krbd.Image{},
+			want:  krbd.Image{Monitors: []string{"192.168.0.1"}, Options: &krbd.Options{Fsid: "abc"}, Keyring: keyring},
+		},
+		{
+			name:  "Already set",
+			image: krbd.Image{Monitors: []string{"10.0.0.1"}, Options: &krbd.Options{Fsid: "def", Secret: "x"}},
+			want:  krbd.Image{Monitors: []string{"10.0.0.1"}, Options: &krbd.Options{Fsid: "def", Secret: "x"}},
+		},
+		{
+			name:  "No keyring for user",
+			image: krbd.Image{Options: &krbd.Options{Name: "rbd"}},
+			want:  krbd.Image{Monitors: []string{"192.168.0.1"}, Options: &krbd.Options{Fsid: "abc", Name: "rbd"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := tt.image
+			if err := c.Apply(&i); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(i, tt.want) {
+				t.Errorf("Config.Apply() = %#v, want %#v", i, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Apply_msMode(t *testing.T) {
+	c, err := Parse(strings.NewReader("[global]\nmon host = [v2:192.168.0.1:3300]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		image krbd.Image
+		want  string
+	}{
+		{name: "v2 only", image: krbd.Image{Options: &krbd.Options{Secret: "x"}}, want: "prefer-crc"},
+		{name: "Already set", image: krbd.Image{Options: &krbd.Options{Secret: "x", MsMode: "secure"}}, want: "secure"},
+		{name: "Own monitors", image: krbd.Image{Monitors: []string{"192.168.0.1"}, Options: &krbd.Options{Secret: "x"}}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := tt.image
+			if err := c.Apply(&i); err != nil {
+				t.Fatal(err)
+			}
+			if i.Options.MsMode != tt.want {
+				t.Errorf("Config.Apply() ms_mode = %q, want %q", i.Options.MsMode, tt.want)
+			}
+			if got := i.Options.String(); tt.want != "" && !strings.Contains(got, "ms_mode="+tt.want) {
+				t.Errorf("Options.String() = %q, want ms_mode=%s", got, tt.want)
+			}
+		})
+	}
+}
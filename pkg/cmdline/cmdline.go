@@ -2,10 +2,12 @@ package cmdline
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/bensallen/rbd/pkg/krbd"
@@ -17,16 +19,321 @@ type Mount struct {
 	Image     *krbd.Image
 	MountOpts []string `json:"mntopts"`
 	Part      string
-	Overlay   bool
-	Path      string
-	FsType    string
+	// UUID or Label of a filesystem or partition on the image, instead of Part.
+	UUID    string `json:"uuid"`
+	Label   string `json:"label"`
+	Overlay Overlay
+	// Clone is the name of the per node image holding the upper layer of the
+	// overlay, in the pool and namespace of Image, which is a snapshot, eg. a
+	// golden image shared by all nodes. It may contain variables, see Expand.
+	Clone  string `json:"clone"`
+	Path   string
+	FsType string
+	// After are names of mounts to mount before this one, in addition to the
+	// mount of the parent path. Requires are the same, but this mount is
+	// skipped if any of them fail.
+	After    []string `json:"after"`
+	Requires []string `json:"requires"`
+	// Required mounts fail the boot if they can't be mounted, the root mount is
+	// always required.
+	Required bool `json:"required"`
+	// Retries, Backoff, and Timeout override the defaults of boot for retrying
+	// the map, see krbd.Retry, and the overall deadline to map the image and
+	// wait for its device. Nil or zero when not set.
+	Retries *int     `json:"retries"`
+	Backoff Duration `json:"backoff"`
+	Timeout Duration `json:"timeout"`
+}
+
+// Overlay is a R/W overlay mounted over a mount, which becomes its read-only
+// lower layer. The upper layer is a tmpfs, unless Image or Label is set. In JSON
+// it is either a bool, true for the defaults, or an object, eg. {"size":"2G"}.
+type Overlay struct {
+	Enabled bool `json:"enabled"`
+	// Size limits the tmpfs upper layer, eg. 2G or 50%, unlimited if empty.
+	Size string `json:"size"`
+	// Image is a RBD image holding the upper layer, eg. a persistent writable
+	// layer per node. Its monitors and credentials default to those of the
+	// mount.
+	Image *krbd.Image `json:"image"`
+	// Label of a filesystem or GPT partition on a local disk holding the upper
+	// layer.
+	Label string `json:"label"`
+	// FsType and MountOpts of the filesystem of Image or Label, FsType is
+	// probed when not set.
+	FsType    string   `json:"fstype"`
+	MountOpts []string `json:"mntopts"`
+	// Lower are the names of mounts stacked below the mount, the top most
+	// first, eg. read-only images of applications above a base image. They are
+	// only used as layers and have no path.
+	Lower []string `json:"lower"`
+}
+
+// UnmarshalJSON parses the overlay from a bool or an object, which enables the
+// overlay unless it sets enabled to false.
+func (o *Overlay) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &o.Enabled); err == nil {
+		return nil
+	}
+	// Without the methods of Overlay
+	type overlay Overlay
+	o.Enabled = true
+	return json.Unmarshal(b, (*overlay)(o))
+}
+
+// inherit sets the monitors of the overlay image, and its credentials, to
+// those of the image of the mount when they aren't set.
+func (o *Overlay) inherit(i *krbd.Image) {
+	if o.Image == nil || i == nil {
+		return
+	}
+	if len(o.Image.Monitors) == 0 {
+		o.Image.Monitors = i.Monitors
+	}
+	if o.Image.Options != nil && o.Image.Options.Secret != "" || o.Image.Keyring != "" || o.Image.Keyfile != "" {
+		return
+	}
+	o.Image.Keyring, o.Image.Keyfile = i.Keyring, i.Keyfile
+	if i.Options != nil {
+		opts := options(o.Image)
+		opts.Secret = i.Options.Secret
+		if opts.Name == "" {
+			opts.Name = i.Options.Name
+		}
+	}
+}
+
+// cloneOverlay enables the overlay with the per node image Clone as its upper
+// layer, unless the overlay image is set otherwise, which validate reports.
+func (m *Mount) cloneOverlay() {
+	if m.Clone == "" || m.Image == nil || m.Overlay.Image != nil {
+		return
+	}
+	img := &krbd.Image{Pool: m.Image.Pool, Image: m.Clone}
+	if m.Image.Options != nil && m.Image.Options.Namespace != "" {
+		options(img).Namespace = m.Image.Options.Namespace
+	}
+	m.Overlay.Enabled = true
+	m.Overlay.Image = img
+}
+
+// Duration is a time.Duration given as a string, eg. 30s, in JSON.
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration from a JSON string, see time.ParseDuration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.set(s)
+}
+
+func (d *Duration) set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
 }
 
 // Leading prefix for cmdline arguments
 const prefix = "rbd"
 
+// mountAttrs are the setters for rbd.<name>.<attr>=<value> keys.
+var mountAttrs = map[string]func(m *Mount, value string) error{
+	"image": func(m *Mount, value string) error {
+		m.image().Image = value
+		return nil
+	},
+	"mntopts": func(m *Mount, value string) error {
+		m.MountOpts = splitList(value)
+		return nil
+	},
+	"part": func(m *Mount, value string) error {
+		m.Part = value
+		return nil
+	},
+	"uuid": func(m *Mount, value string) error {
+		m.UUID = value
+		return nil
+	},
+	"label": func(m *Mount, value string) error {
+		m.Label = value
+		return nil
+	},
+	"overlay": func(m *Mount, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		m.Overlay.Enabled = b
+		return nil
+	},
+	"clone": func(m *Mount, value string) error {
+		m.Clone = value
+		return nil
+	},
+	"path": func(m *Mount, value string) error {
+		m.Path = value
+		return nil
+	},
+	"fstype": func(m *Mount, value string) error {
+		m.FsType = value
+		return nil
+	},
+	"after": func(m *Mount, value string) error {
+		m.After = splitList(value)
+		return nil
+	},
+	"requires": func(m *Mount, value string) error {
+		m.Requires = splitList(value)
+		return nil
+	},
+	"required": func(m *Mount, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		m.Required = b
+		return nil
+	},
+	"retries": func(m *Mount, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		m.Retries = &n
+		return nil
+	},
+	"backoff": func(m *Mount, value string) error {
+		return m.Backoff.set(value)
+	},
+	"timeout": func(m *Mount, value string) error {
+		return m.Timeout.set(value)
+	},
+}
+
+// overlayAttrs are the setters for rbd.<name>.overlay.<attr>=<value> keys,
+// which enable the overlay.
+var overlayAttrs = map[string]func(o *Overlay, value string) error{
+	"size": func(o *Overlay, value string) error {
+		o.Size = value
+		return nil
+	},
+	"image": func(o *Overlay, value string) error {
+		if o.Image == nil {
+			o.Image = &krbd.Image{}
+		}
+		return o.Image.ParseSpec(value)
+	},
+	"label": func(o *Overlay, value string) error {
+		o.Label = value
+		return nil
+	},
+	"fstype": func(o *Overlay, value string) error {
+		o.FsType = value
+		return nil
+	},
+	"mntopts": func(o *Overlay, value string) error {
+		o.MountOpts = splitList(value)
+		return nil
+	},
+	"lower": func(o *Overlay, value string) error {
+		o.Lower = splitList(value)
+		return nil
+	},
+}
+
+// imageAttrs are the setters for rbd.<name>.image.<attr>=<value> keys.
+var imageAttrs = map[string]func(i *krbd.Image, value string) error{
+	"spec": func(i *krbd.Image, value string) error {
+		return i.ParseSpec(value)
+	},
+	"pool": func(i *krbd.Image, value string) error {
+		i.Pool = value
+		return nil
+	},
+	"mons": func(i *krbd.Image, value string) error {
+		i.Monitors = splitList(value)
+		return nil
+	},
+	"snap": func(i *krbd.Image, value string) error {
+		i.Snapshot = value
+		return nil
+	},
+	"opts": func(i *krbd.Image, value string) error {
+		return options(i).Parse(value)
+	},
+	"user": func(i *krbd.Image, value string) error {
+		options(i).Name = value
+		return nil
+	},
+	"secret": func(i *krbd.Image, value string) error {
+		options(i).Secret = value
+		return nil
+	},
+	"namespace": func(i *krbd.Image, value string) error {
+		options(i).Namespace = value
+		return nil
+	},
+	"keyring": func(i *krbd.Image, value string) error {
+		i.Keyring = value
+		return nil
+	},
+	"keyfile": func(i *krbd.Image, value string) error {
+		i.Keyfile = value
+		return nil
+	},
+}
+
+// image returns the Image of the mount, allocating it if needed.
+func (m *Mount) image() *krbd.Image {
+	if m.Image == nil {
+		m.Image = &krbd.Image{}
+	}
+	return m.Image
+}
+
+// clone returns a copy of the mount including its Image and Options. A nil mount
+// results in a new empty Mount.
+func (m *Mount) clone() *Mount {
+	c := &Mount{}
+	if m == nil {
+		return c
+	}
+	*c = *m
+	c.Image = cloneImage(m.Image)
+	c.Overlay.Image = cloneImage(m.Overlay.Image)
+	return c
+}
+
+// cloneImage returns a copy of i including its Options, nil if i is nil.
+func cloneImage(i *krbd.Image) *krbd.Image {
+	if i == nil {
+		return nil
+	}
+	c := *i
+	if i.Options != nil {
+		opts := *i.Options
+		c.Options = &opts
+	}
+	return &c
+}
+
+// options returns the Options of the image, allocating them if needed.
+func options(i *krbd.Image) *krbd.Options {
+	if i.Options == nil {
+		i.Options = &krbd.Options{}
+	}
+	return i.Options
+}
+
 // Parse attempts to find rbd options from input kernel cmdline and return one
-// or more Images. Only JSON formats below is implemented.
+// or more Images. Both the dotted key=value and the JSON formats below are
+// supported, and may be mixed for the same mount name. Later arguments override
+// attributes set by earlier ones.
 //
 // rbd.<name>... where <name> is an arbitrary string identifer for the mount
 // rbd.root.image=test-image1
@@ -35,68 +342,287 @@ const prefix = "rbd"
 // rbd.root.image.user=admin
 // rbd.root.image.secret=<key>
 //
+// Instead of the secret, a keyring or a file with just the secret, for example
+// shipped in the initramfs, can be referenced. Either is only read at map time.
+// rbd.root.image.keyring=/etc/ceph/ceph.client.admin.keyring
+// rbd.root.image.keyfile=/etc/ceph/admin.secret
+//
 // Optional
 // rbd.root.image.snap=snap1
+// rbd.root.image.namespace=ns1
+// rbd.root.image.spec=rbd/ns1/test-image1@snap1 (instead of pool, namespace, image, and snap)
 // rbd.root.image.opts=rw,share
-// rbd.root.part=1
+// rbd.root.part=1 (or a GPT partition label or UUID, eg. root, PARTLABEL=root, PARTUUID=<uuid>)
+// rbd.root.uuid=<uuid> (of a filesystem or partition on the image, instead of part)
+// rbd.root.label=root (of a filesystem or GPT partition on the image, instead of part)
 // rbd.root.mntopts=defaults
-// rbd.root.fstype=ext4
+// rbd.root.fstype=ext4 (probed from the device when not set)
 // rbd.root.overlay=false
+// rbd.root.overlay.size=2G (of the tmpfs upper layer, enables the overlay like the following)
+// rbd.root.overlay.image=rbd/node1-upper (image holding the upper layer, with the monitors and credentials of the mount)
+// rbd.root.overlay.label=upper (filesystem or GPT partition on a local disk holding the upper layer)
+// rbd.root.overlay.fstype=xfs (of the image or label, probed when not set)
+// rbd.root.overlay.mntopts=noatime
+// rbd.root.overlay.lower=apps,base (mounts without a path stacked below the mount)
+// rbd.root.clone=node-${hostname} (per node image holding the upper layer over a snapshot, see Expand)
 // rbd.root.path=/newroot
+// rbd.var.after=home,srv (mount after these, in addition to the mount of the parent path)
+// rbd.var.requires=home (mount after and only if these were mounted)
+// rbd.var.required=true (fail the boot if this mount fails, always true for root)
+// rbd.root.retries=10 (times to retry mapping after errors like ETIMEDOUT)
+// rbd.root.backoff=1s (wait before the first retry, doubled for each retry)
+// rbd.root.timeout=2m (deadline to map, including retries, and for the device to appear)
 //
 // JSON
 // rbd={"root": {"image":{"mons": ["192.168.0.1","192.168.0.2","192.168.0.3:6789"], "opts":{"name": "admin", "secret": "AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q=="}, "pool":"rbd", "image":"test-image1"}, "path":"/", "fstype":"ext4"}}
 // rbd.root={"image":{"mons": ["192.168.0.1","192.168.0.2","192.168.0.3:6789"], "opts":{"name": "admin", "secret": "AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q=="}, "pool":"rbd", "image":"test-image1"}, "path":"/", "fstype":"ext4"}
-func Parse(cmdline string) map[string]*Mount {
-	log.Printf("Debug: %s", cmdline)
+// rbd.root={"image":{"mons": ["192.168.0.1"], "spec":"rbd/test-image1", "keyring":"/etc/ceph/ceph.client.admin.keyring"}, "path":"/", "fstype":"ext4"}
+//
+// Arguments which can't be parsed are skipped and returned as Diagnostics, the
+// mounts parsed from the remaining arguments are always returned. The mounts are
+// not validated, see Analyze.
+func Parse(cmdline string) (map[string]*Mount, error) {
+	mounts, _, diags := parse(cmdline, nil)
+	if len(diags) != 0 {
+		return mounts, diags
+	}
+	return mounts, nil
+}
 
-	mounts := map[string]*Mount{}
-	for _, part := range split(cmdline) {
-		switch {
-		case strings.HasPrefix(part, prefix+"."):
-			splitN := strings.IndexRune(part, '=')
-			if splitN > 0 {
-				keySplit := strings.Split(part[len(prefix)+1:splitN], ".")
-				mount := &Mount{}
-				// Check if a mount is already in the map
-				if _, ok := mounts[keySplit[0]]; ok {
-					mount = mounts[keySplit[0]]
-				}
+// Analyze parses the cmdline like Parse and then validates the resulting mounts,
+// returning the mounts along with every problem found. If fill is not nil, it is
+// called before validation with the Image of each mount to set attributes from
+// other sources, eg. the monitors from ceph.conf.
+func Analyze(cmdline string, fill func(*krbd.Image) error) (map[string]*Mount, Diagnostics) {
+	return AnalyzeConfig(cmdline, nil, fill)
+}
+
+// AnalyzeConfig is Analyze with the mounts of config, a document in the rbd=
+// JSON format fetched from rbd.config, see FetchConfig. The rbd arguments of
+// the cmdline are applied on top of the mounts of config, so they can override
+// its attributes. Problems with config are reported with the offset of the
+// rbd.config argument.
+func AnalyzeConfig(cmdline string, config []byte, fill func(*krbd.Image) error) (map[string]*Mount, Diagnostics) {
+	mounts, offsets, diags := parse(cmdline, config)
+	return mounts, append(diags, analyze(mounts, offsets, fill, true)...)
+}
 
-				switch len(keySplit) {
-				case 1:
-					// Image label and no attribute as part of key, eg. rbd.root=
-					// so assume the value is JSON.
-					if err := json.Unmarshal([]byte(part[splitN+1:]), mount); err != nil {
-						//log.Printf("Error parsing json: %v", err)
-						continue
-					}
-				case 2:
-					// Volume label with attribute, eg. rbd.root.image=
-					// [TODO]
-				default:
+// analyze fills and validates the parsed mounts, see Analyze.
+func analyze(mounts map[string]*Mount, offsets map[string]int, fill func(*krbd.Image) error, requirePath bool) Diagnostics {
+	var diags Diagnostics
+	for _, m := range mounts {
+		m.cloneOverlay()
+		m.Overlay.inherit(m.Image)
+	}
+	if fill != nil {
+		for _, name := range sortedNames(mounts) {
+			for _, img := range []struct {
+				attr  string
+				image *krbd.Image
+			}{{"image", mounts[name].Image}, {"overlay.image", mounts[name].Overlay.Image}} {
+				if img.image == nil {
 					continue
 				}
-				mounts[keySplit[0]] = mount
+				if err := fill(img.image); err != nil {
+					diags = append(diags, &Diagnostic{Offset: offsets[name], Key: prefix + "." + name + "." + img.attr, Err: err})
+				}
 			}
-		case strings.HasPrefix(part, prefix+"="):
+		}
+	}
+	return append(diags, validate(mounts, offsets, requirePath)...)
+}
+
+// parse does the work of Parse, additionally returning the offset of the first
+// argument that referenced each mount.
+func parse(cmdline string, config []byte) (map[string]*Mount, map[string]int, Diagnostics) {
+	mounts := map[string]*Mount{}
+	offsets := map[string]int{}
+	var diags Diagnostics
+
+	if config != nil {
+		offset := -1
+		for _, f := range fields(cmdline) {
+			if strings.HasPrefix(f.s, prefix+".config=") {
+				offset = f.offset
+			}
+		}
+		if err := parseConfig(mounts, config); err != nil {
+			diags = append(diags, &Diagnostic{Offset: offset, Key: prefix + ".config", Err: err})
+		}
+		for name := range mounts {
+			offsets[name] = offset
+		}
+	}
+	for _, f := range fields(cmdline) {
+		var err error
+		switch {
+		case strings.HasPrefix(f.s, prefix+".") && reserved[argName(f.s[len(prefix)+1:])]:
+			// See Net
+			continue
+		case strings.HasPrefix(f.s, prefix+"."):
+			err = parseKey(mounts, f.s[len(prefix)+1:])
+		case strings.HasPrefix(f.s, prefix+"="):
 			// Bare rbd key, assume value is JSON
-			if err := json.Unmarshal([]byte(part[len(prefix)+1:]), &mounts); err != nil {
-				log.Printf("Error parsing json: %v", err)
-				continue
+			err = parseJSON(mounts, f.s[len(prefix)+1:])
+		default:
+			continue
+		}
+		if err != nil {
+			key, value := f.s, ""
+			if n := strings.IndexRune(f.s, '='); n > 0 {
+				key, value = f.s[:n], f.s[n+1:]
+			}
+			diags = append(diags, &Diagnostic{Offset: f.offset, Key: key, Value: redact(key, value), Err: err})
+		}
+		for name := range mounts {
+			if _, ok := offsets[name]; !ok {
+				offsets[name] = f.offset
+			}
+		}
+	}
+	return mounts, offsets, diags
+}
+
+// parseKey parses a single <name>[.<attr>[.<attr>]]=<value> argument, with the rbd.
+// prefix already removed, into mounts.
+func parseKey(mounts map[string]*Mount, arg string) error {
+	splitN := strings.IndexRune(arg, '=')
+	if splitN < 0 {
+		return fmt.Errorf("%w: missing value", ErrMalformedKey)
+	}
+	keySplit := strings.Split(arg[:splitN], ".")
+	for _, k := range keySplit {
+		if k == "" {
+			return fmt.Errorf("%w: empty key element", ErrMalformedKey)
+		}
+	}
+	value := arg[splitN+1:]
+
+	// Work on a copy so a failure doesn't leave a partially updated mount behind.
+	mount := mounts[keySplit[0]].clone()
+
+	switch len(keySplit) {
+	case 1:
+		// Image label and no attribute as part of key, eg. rbd.root=
+		// so assume the value is JSON.
+		if err := json.Unmarshal([]byte(value), mount); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+		}
+	case 2:
+		// Volume label with attribute, eg. rbd.root.path=
+		set, ok := mountAttrs[keySplit[1]]
+		if !ok {
+			return fmt.Errorf("%w %q", ErrUnknownAttr, keySplit[1])
+		}
+		if err := set(mount, value); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+		}
+	case 3:
+		// Volume label with image or overlay attribute, eg. rbd.root.image.pool=
+		var err error
+		switch keySplit[1] {
+		case "image":
+			set, ok := imageAttrs[keySplit[2]]
+			if !ok {
+				return fmt.Errorf("%w %q", ErrUnknownAttr, keySplit[1]+"."+keySplit[2])
 			}
+			err = set(mount.image(), value)
+		case "overlay":
+			set, ok := overlayAttrs[keySplit[2]]
+			if !ok {
+				return fmt.Errorf("%w %q", ErrUnknownAttr, keySplit[1]+"."+keySplit[2])
+			}
+			mount.Overlay.Enabled = true
+			err = set(&mount.Overlay, value)
 		default:
+			return fmt.Errorf("%w %q", ErrUnknownAttr, keySplit[1]+"."+keySplit[2])
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+		}
+	default:
+		return fmt.Errorf("%w: too many key elements", ErrMalformedKey)
+	}
+	mounts[keySplit[0]] = mount
+	return nil
+}
+
+// parseJSON parses the value of a bare rbd= argument, a JSON object of mounts
+// keyed by name. Mounts already in mounts are updated rather than replaced.
+func parseJSON(mounts map[string]*Mount, value string) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+	}
+	parsed := map[string]*Mount{}
+	for name, msg := range raw {
+		if reserved[name] {
 			continue
 		}
+		mount := mounts[name].clone()
+		if err := json.Unmarshal(msg, mount); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+		}
+		parsed[name] = mount
+	}
+	for name, mount := range parsed {
+		mounts[name] = mount
+	}
+	return nil
+}
+
+// argName returns the name of a <name>[.<attr>...]=<value> argument.
+func argName(arg string) string {
+	if n := strings.IndexAny(arg, ".="); n >= 0 {
+		return arg[:n]
+	}
+	return arg
+}
+
+// parseConfig parses a rbd.config document into mounts. Unlike for rbd=, the
+// network can't be configured by it, as it is needed to fetch the document.
+func parseConfig(mounts map[string]*Mount, config []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(config, &raw); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+	}
+	for name := range raw {
+		if reserved[name] {
+			return fmt.Errorf("%w: %q can't be set by rbd.config", ErrInvalidValue, name)
+		}
+	}
+	return parseJSON(mounts, string(config))
+}
+
+// splitList splits a comma separated value, an empty value results in an empty list.
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
 	}
-	return mounts
+	return strings.Split(s, ",")
+}
+
+// field is a single argument of the cmdline and its byte offset.
+type field struct {
+	offset int
+	s      string
 }
 
-//Split strings on spaces except when a space is within a quoted, bracketed, or braced string.
-//Supports nesting multiple brackets or braces.
+// Split strings on spaces except when a space is within a quoted, bracketed, or braced string.
+// Supports nesting multiple brackets or braces.
 func split(s string) []string {
+	fs := fields(s)
+	out := make([]string, len(fs))
+	for i, f := range fs {
+		out[i] = f.s
+	}
+	return out
+}
+
+// fields does the work of split, additionally recording the offset of each field.
+func fields(s string) []field {
 	lastRune := map[rune]int{}
-	f := func(c rune) bool {
+	isSep := func(c rune) bool {
 		switch {
 		case lastRune[c] > 0:
 			lastRune[c]--
@@ -113,10 +639,26 @@ func split(s string) []string {
 		case mapGreaterThan(lastRune, 0):
 			return false
 		default:
-			return c == ' '
+			return unicode.IsSpace(c)
 		}
 	}
-	return strings.FieldsFunc(s, f)
+
+	out := []field{}
+	start := -1
+	for i, c := range s {
+		if isSep(c) {
+			if start >= 0 {
+				out = append(out, field{offset: start, s: s[start:i]})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		out = append(out, field{offset: start, s: s[start:]})
+	}
+	return out
 }
 
 // mapGreaterThan ranges across the provided map[rune]int looking for any values greater than
@@ -1,8 +1,10 @@
 package cmdline
 
 import (
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/bensallen/rbd/pkg/krbd"
 )
@@ -81,14 +83,15 @@ func TestParse(t *testing.T) {
 		cmdline string
 	}
 	tests := []struct {
-		name string
-		args args
-		want map[string]*Mount
+		name    string
+		args    args
+		want    map[string]*Mount
+		wantErr error
 	}{
 		{
 			name: "rbd.root=",
 			args: args{cmdline: `rbd={"root": {"image":{"mons": ["192.168.0.1","192.168.0.2","192.168.0.3:6789"], "opts":{"name": "admin", "secret": "AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q==", "readonly": true}, "pool":"rbd", "image":"test-image1"}, "path":"/", "fstype":"ext4", "overlay": true}}`},
-			want: map[string]*Mount{"root": {Image: &krbd.Image{Monitors: []string{"192.168.0.1", "192.168.0.2", "192.168.0.3:6789"}, Options: &krbd.Options{Name: "admin", Secret: "AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q==", ReadOnly: true}, Pool: "rbd", Image: "test-image1"}, Path: "/", FsType: "ext4", Overlay: true}},
+			want: map[string]*Mount{"root": {Image: &krbd.Image{Monitors: []string{"192.168.0.1", "192.168.0.2", "192.168.0.3:6789"}, Options: &krbd.Options{Name: "admin", Secret: "AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q==", ReadOnly: true}, Pool: "rbd", Image: "test-image1"}, Path: "/", FsType: "ext4", Overlay: Overlay{Enabled: true}}},
 		},
 		{
 			name: "rbd.root= specified twice with different attributes",
@@ -101,19 +104,158 @@ func TestParse(t *testing.T) {
 			want: map[string]*Mount{"root": {Image: &krbd.Image{Pool: "rbd", Image: "test-image1"}, Path: "/", FsType: "ext4"}},
 		},
 		{
-			name: "Garbage JSON",
-			args: args{cmdline: `rbd={"root": "asdf"}}`},
-			want: map[string]*Mount{},
+			name: "rbd= with opts as a string",
+			args: args{cmdline: `rbd={"root":{"image":{"pool":"rbd","image":"test-image1","opts":"ro,queue_depth=128"},"path":"/","fstype":"ext4"}}`},
+			want: map[string]*Mount{"root": {Image: &krbd.Image{Pool: "rbd", Image: "test-image1", Options: &krbd.Options{ReadOnly: true, QueueDepth: 128}}, Path: "/", FsType: "ext4"}},
 		},
 		{
-			name: "Garbage JSON 2",
-			args: args{cmdline: `rbd.root={"root": "asdf"}}`},
-			want: map[string]*Mount{},
+			name:    "rbd= with conflicting opts",
+			args:    args{cmdline: `rbd={"root":{"image":{"pool":"rbd","image":"test-image1","opts":"ro,rw"},"path":"/","fstype":"ext4"}}`},
+			want:    map[string]*Mount{},
+			wantErr: ErrInvalidJSON,
 		},
 		{
-			name: "Malformed key",
-			args: args{cmdline: "rbd.root.pool.test=pool1"},
-			want: map[string]*Mount{},
+			name:    "Conflicting opts value",
+			args:    args{cmdline: "rbd.root.image.opts=crc,nocrc"},
+			want:    map[string]*Mount{},
+			wantErr: ErrInvalidValue,
+		},
+		{
+			name:    "Garbage JSON",
+			args:    args{cmdline: `rbd={"root": "asdf"}}`},
+			want:    map[string]*Mount{},
+			wantErr: ErrInvalidJSON,
+		},
+		{
+			name:    "Garbage JSON 2",
+			args:    args{cmdline: `rbd.root={"root": "asdf"}}`},
+			want:    map[string]*Mount{},
+			wantErr: ErrInvalidJSON,
+		},
+		{
+			name:    "Malformed key",
+			args:    args{cmdline: "rbd.root.pool.test=pool1"},
+			want:    map[string]*Mount{},
+			wantErr: ErrUnknownAttr,
+		},
+		{
+			name:    "Too many key elements",
+			args:    args{cmdline: "rbd.root.image.pool.test=pool1"},
+			want:    map[string]*Mount{},
+			wantErr: ErrMalformedKey,
+		},
+		{
+			name:    "Empty key element",
+			args:    args{cmdline: "rbd..path=/"},
+			want:    map[string]*Mount{},
+			wantErr: ErrMalformedKey,
+		},
+		{
+			name:    "Missing value",
+			args:    args{cmdline: "rbd.root.path"},
+			want:    map[string]*Mount{},
+			wantErr: ErrMalformedKey,
+		},
+		{
+			name:    "Unknown mount attribute",
+			args:    args{cmdline: "rbd.root.pool=rbd"},
+			want:    map[string]*Mount{},
+			wantErr: ErrUnknownAttr,
+		},
+		{
+			name:    "Unknown image attribute",
+			args:    args{cmdline: "rbd.root.image.size=1"},
+			want:    map[string]*Mount{},
+			wantErr: ErrUnknownAttr,
+		},
+		{
+			name:    "Invalid overlay value",
+			args:    args{cmdline: "rbd.root.path=/ rbd.root.overlay=maybe"},
+			want:    map[string]*Mount{"root": {Path: "/"}},
+			wantErr: ErrInvalidValue,
+		},
+		{
+			name:    "Invalid opts value",
+			args:    args{cmdline: "rbd.root.image.opts=queue_depth=deep"},
+			want:    map[string]*Mount{},
+			wantErr: ErrInvalidValue,
+		},
+		{
+			name: "Dotted keys",
+			args: args{cmdline: "rbd.root.image=test-image1 rbd.root.image.pool=rbd rbd.root.image.mons=192.168.0.1,192.168.0.2,192.168.0.3:6789 rbd.root.image.user=admin rbd.root.image.secret=AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q== rbd.root.image.snap=snap1 rbd.root.image.namespace=ns1 rbd.root.image.opts=ro,queue_depth=128 rbd.root.part=1 rbd.root.mntopts=ro,noatime rbd.root.fstype=ext4 rbd.root.overlay=true rbd.root.path=/"},
+			want: map[string]*Mount{"root": {
+				Image: &krbd.Image{
+					Monitors: []string{"192.168.0.1", "192.168.0.2", "192.168.0.3:6789"},
+					Options:  &krbd.Options{Name: "admin", Secret: "AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q==", Namespace: "ns1", ReadOnly: true, QueueDepth: 128},
+					Pool:     "rbd",
+					Image:    "test-image1",
+					Snapshot: "snap1",
+				},
+				Part:      "1",
+				MountOpts: []string{"ro", "noatime"},
+				FsType:    "ext4",
+				Overlay:   Overlay{Enabled: true},
+				Path:      "/",
+			}},
+		},
+		{
+			name: "Overlay",
+			args: args{cmdline: `rbd.root.overlay.size=2G rbd.root.overlay.lower=apps,base rbd.var={"overlay":{"image":{"pool":"rbd","image":"var-upper"},"fstype":"xfs"}} rbd.srv.overlay.label=upper rbd.srv={"overlay":false} rbd.home={"overlay":true}`},
+			want: map[string]*Mount{
+				"root": {Overlay: Overlay{Enabled: true, Size: "2G", Lower: []string{"apps", "base"}}},
+				"var":  {Overlay: Overlay{Enabled: true, Image: &krbd.Image{Pool: "rbd", Image: "var-upper"}, FsType: "xfs"}},
+				"srv":  {Overlay: Overlay{Label: "upper"}},
+				"home": {Overlay: Overlay{Enabled: true}},
+			},
+		},
+		{
+			name:    "Unknown overlay attribute",
+			args:    args{cmdline: "rbd.root.overlay.upper=tmpfs"},
+			want:    map[string]*Mount{},
+			wantErr: ErrUnknownAttr,
+		},
+		{
+			name: "Image spec",
+			args: args{cmdline: `rbd.root.image.spec=rbd/ns1/test-image1@snap1 rbd.var={"image":{"spec":"rbd/test-image2"}}`},
+			want: map[string]*Mount{
+				"root": {Image: &krbd.Image{Pool: "rbd", Image: "test-image1", Snapshot: "snap1", Options: &krbd.Options{Namespace: "ns1"}}},
+				"var":  {Image: &krbd.Image{Pool: "rbd", Image: "test-image2"}},
+			},
+		},
+		{
+			name:    "Invalid image spec",
+			args:    args{cmdline: `rbd.root.image.spec=rbd/ns1/x/test-image1`},
+			want:    map[string]*Mount{},
+			wantErr: ErrInvalidValue,
+		},
+		{
+			name: "Keyring and keyfile",
+			args: args{cmdline: `rbd.root.image.keyring=/etc/ceph/ceph.client.admin.keyring rbd.var={"image":{"keyfile":"/etc/ceph/admin.secret"}}`},
+			want: map[string]*Mount{
+				"root": {Image: &krbd.Image{Keyring: "/etc/ceph/ceph.client.admin.keyring"}},
+				"var":  {Image: &krbd.Image{Keyfile: "/etc/ceph/admin.secret"}},
+			},
+		},
+		{
+			name: "Dotted keys merged with rbd.root=",
+			args: args{cmdline: `rbd.root.image.user=admin rbd.root={"image":{"pool":"rbd", "image":"test-image1"}, "path":"/"} rbd.root.fstype=ext4`},
+			want: map[string]*Mount{"root": {Image: &krbd.Image{Pool: "rbd", Image: "test-image1", Options: &krbd.Options{Name: "admin"}}, Path: "/", FsType: "ext4"}},
+		},
+		{
+			name: "Dotted keys merged with rbd=",
+			args: args{cmdline: `rbd.root.fstype=ext4 rbd.var.path=/var rbd={"root":{"image":{"pool":"rbd", "image":"test-image1"}, "path":"/"}}`},
+			want: map[string]*Mount{
+				"root": {Image: &krbd.Image{Pool: "rbd", Image: "test-image1"}, Path: "/", FsType: "ext4"},
+				"var":  {Path: "/var"},
+			},
+		},
+		{
+			name: "Retries and timeout",
+			args: args{cmdline: `rbd.root.retries=10 rbd.root.backoff=250ms rbd.var={"retries":0, "timeout":"2m"}`},
+			want: map[string]*Mount{
+				"root": {Retries: intPtr(10), Backoff: Duration(250 * time.Millisecond)},
+				"var":  {Retries: intPtr(0), Timeout: Duration(2 * time.Minute)},
+			},
 		},
 		{
 			name: "Unrelated cmdline args no rbd",
@@ -128,10 +270,17 @@ func TestParse(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := Parse(tt.args.cmdline)
+			got, err := Parse(tt.args.cmdline)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("Parse() = %#v, want %#v", got, tt.want)
 			}
 		})
 	}
 }
+
+func intPtr(n int) *int {
+	return &n
+}
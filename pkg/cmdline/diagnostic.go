@@ -0,0 +1,99 @@
+package cmdline
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	// ErrMalformedKey is returned for rbd keys that don't follow rbd.<name>[.<attr>[.<attr>]]=<value>.
+	ErrMalformedKey = errors.New("malformed key")
+	// ErrUnknownAttr is returned for rbd keys with an attribute that isn't supported.
+	ErrUnknownAttr = errors.New("unknown attribute")
+	// ErrInvalidValue is returned when the value of a key could not be parsed or is not allowed.
+	ErrInvalidValue = errors.New("invalid value")
+	// ErrInvalidJSON is returned when a JSON value could not be unmarshalled.
+	ErrInvalidJSON = errors.New("invalid JSON")
+	// ErrMissingField is returned by validation when a mount lacks a required attribute.
+	ErrMissingField = errors.New("missing required field")
+	// ErrDuplicatePath is returned by validation when more than one mount uses the same path.
+	ErrDuplicatePath = errors.New("duplicate mount path")
+)
+
+// redacted replaces secret values in Diagnostic.Value.
+const redacted = "<redacted>"
+
+// Diagnostic is a problem found with the rbd arguments of the cmdline, either
+// while parsing or validating them.
+type Diagnostic struct {
+	// Offset is the byte offset within the cmdline of the argument the problem
+	// was found in. For validation problems it is the offset of the first argument
+	// that referenced the mount.
+	Offset int
+	Key    string
+	// Value of the argument with any secrets redacted.
+	Value string
+	// Err is the reason, which wraps one of the Err* values of this package.
+	Err error
+}
+
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("offset %d: %s: %v", d.Offset, d.Key, d.Err)
+}
+
+// Unwrap returns the underlying error, eg. ErrMalformedKey.
+func (d *Diagnostic) Unwrap() error {
+	return d.Err
+}
+
+// Diagnostics is a list of problems found in the cmdline.
+type Diagnostics []*Diagnostic
+
+func (d Diagnostics) Error() string {
+	s := make([]string, len(d))
+	for i, diag := range d {
+		s[i] = diag.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// Is reports whether any of the diagnostics in the list matches target.
+func (d Diagnostics) Is(target error) bool {
+	for _, diag := range d {
+		if errors.Is(diag, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Report writes a human readable table of the diagnostics to w.
+func (d Diagnostics) Report(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "offset\tkey\treason\tvalue")
+	for _, diag := range d {
+		fmt.Fprintf(tw, "%d\t%s\t%v\t%s\n", diag.Offset, diag.Key, diag.Err, diag.Value)
+	}
+	tw.Flush()
+}
+
+var (
+	// JSON "secret": "..." members, JSON keys are matched case-insensitively like encoding/json.
+	jsonSecret = regexp.MustCompile(`(?i)("secret"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+	// secret=... within a comma separated option list, which may be a JSON
+	// string, eg. "opts":"secret=...,ro".
+	optSecret = regexp.MustCompile(`(^|[,"])(secret=)[^,"]*`)
+)
+
+// redact removes cephx secrets from the value of the argument key.
+func redact(key string, value string) string {
+	if strings.HasSuffix(key, ".secret") {
+		return redacted
+	}
+	value = jsonSecret.ReplaceAllString(value, `${1}"`+redacted+`"`)
+	return optSecret.ReplaceAllString(value, "${1}${2}"+redacted)
+}
@@ -0,0 +1,243 @@
+package cmdline
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bensallen/rbd/pkg/krbd"
+)
+
+func TestAnalyze(t *testing.T) {
+	const valid = `rbd.root={"image":{"mons":["192.168.0.1"], "pool":"rbd", "image":"test-image1"}, "path":"/", "fstype":"ext4"}`
+	type want struct {
+		offset int
+		key    string
+		value  string
+		err    error
+	}
+	tests := []struct {
+		name    string
+		cmdline string
+		want    []want
+	}{
+		{
+			name:    "Valid",
+			cmdline: "console=ttyS0 " + valid,
+		},
+		{
+			name:    "Valid without fstype",
+			cmdline: `rbd.root={"image":{"mons":["192.168.0.1"], "pool":"rbd", "image":"test-image1"}, "path":"/"}`,
+		},
+		{
+			name:    "Parse error offset",
+			cmdline: valid + " rbd.root.image.secret=AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q== rbd.root.bogus=x",
+			want: []want{
+				{offset: len(valid) + 64, key: "rbd.root.bogus", value: "x", err: ErrUnknownAttr},
+			},
+		},
+		{
+			name:    "Invalid opts with secret",
+			cmdline: valid + " rbd.root.image.opts=secret=AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q==,queue_depth=x",
+			want: []want{
+				{offset: len(valid) + 1, key: "rbd.root.image.opts", value: "secret=<redacted>,queue_depth=x", err: ErrInvalidValue},
+			},
+		},
+		{
+			name:    "Invalid JSON with secret",
+			cmdline: valid + ` rbd.var={"image":{"opts":{"Secret": "AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q=="}}}}`,
+			want: []want{
+				{offset: len(valid) + 1, key: "rbd.var", value: `{"image":{"opts":{"Secret": "<redacted>"}}}}`, err: ErrInvalidJSON},
+			},
+		},
+		{
+			name:    "Invalid JSON with secret in opts",
+			cmdline: valid + ` rbd.var={"image":{"opts":"secret=AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q==,ro"}}}`,
+			want: []want{
+				{offset: len(valid) + 1, key: "rbd.var", value: `{"image":{"opts":"secret=<redacted>,ro"}}}`, err: ErrInvalidJSON},
+			},
+		},
+		{
+			name:    "Invalid JSON with secret last in opts",
+			cmdline: valid + ` rbd.var={"image":{"opts":"ro,secret=AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q=="}}}`,
+			want: []want{
+				{offset: len(valid) + 1, key: "rbd.var", value: `{"image":{"opts":"ro,secret=<redacted>"}}}`, err: ErrInvalidJSON},
+			},
+		},
+		{
+			name:    "Missing fields",
+			cmdline: "quiet rbd.root.path=/",
+			want: []want{
+				{offset: 6, key: "rbd.root.image", err: ErrMissingField},
+			},
+		},
+		{
+			name:    "Missing image fields",
+			cmdline: "rbd.root.path=/ rbd.root.fstype=ext4 rbd.root.image.snap=snap1",
+			want: []want{
+				{offset: 0, key: "rbd.root.image.mons", err: ErrMissingField},
+				{offset: 0, key: "rbd.root.image.pool", err: ErrMissingField},
+				{offset: 0, key: "rbd.root.image.image", err: ErrMissingField},
+			},
+		},
+		{
+			name:    "Duplicate and relative paths",
+			cmdline: valid + ` rbd={"var":{"image":{"mons":["192.168.0.1"], "pool":"rbd", "image":"test-image2"}, "path":"/var/", "fstype":"ext4"}, "var2":{"image":{"mons":["192.168.0.1"], "pool":"rbd", "image":"test-image3"}, "path":"/var", "fstype":"ext4"}, "tmp":{"image":{"mons":["192.168.0.1"], "pool":"rbd", "image":"test-image4"}, "path":"tmp", "fstype":"ext4"}}`,
+			want: []want{
+				{offset: len(valid) + 1, key: "rbd.tmp.path", value: "tmp", err: ErrInvalidValue},
+				{offset: len(valid) + 1, key: "rbd.var2.path", value: "/var", err: ErrDuplicatePath},
+			},
+		},
+		{
+			name:    "Partition zero",
+			cmdline: valid + " rbd.root.part=0",
+			want: []want{
+				{offset: 0, key: "rbd.root.part", value: "0", err: ErrInvalidValue},
+			},
+		},
+		{
+			name:    "Partition label",
+			cmdline: valid + " rbd.root.part=PARTLABEL=root",
+		},
+		{
+			name:    "Label and part",
+			cmdline: valid + " rbd.root.part=1 rbd.root.label=root",
+			want: []want{
+				{offset: 0, key: "rbd.root.label", value: "root", err: ErrInvalidValue},
+			},
+		},
+		{
+			name:    "UUID",
+			cmdline: valid + " rbd.root.uuid=1b4e28ba-2fa1-11d2-883f-0016d3cc427e",
+		},
+		{
+			name:    "Unknown after",
+			cmdline: valid + " rbd.root.after=var",
+			want: []want{
+				{offset: 0, key: "rbd.root.after", value: "var", err: ErrUnknownMount},
+			},
+		},
+		{
+			name:    "Dependency cycle",
+			cmdline: valid + ` rbd.var={"image":{"mons":["192.168.0.1"], "pool":"rbd", "image":"test-image2"}, "path":"/var", "requires":["home"]} rbd.home={"image":{"mons":["192.168.0.1"], "pool":"rbd", "image":"test-image3"}, "path":"/home", "after":["var"]}`,
+			want: []want{
+				{offset: len(valid) + 1 + 116, key: "rbd.home", err: ErrDependencyCycle},
+				{offset: len(valid) + 1, key: "rbd.var", err: ErrDependencyCycle},
+			},
+		},
+		{
+			name:    "Retries",
+			cmdline: valid + ` rbd.root.retries=10 rbd.root.timeout=2m rbd.var={"image":{"mons":["192.168.0.1"], "pool":"rbd", "image":"test-image2"}, "path":"/var", "retries":3, "backoff":"1s"}`,
+		},
+		{
+			name:    "Negative retries and timeout",
+			cmdline: valid + " rbd.root.retries=-1 rbd.root.timeout=-1s",
+			want: []want{
+				{offset: 0, key: "rbd.root.retries", value: "-1", err: ErrInvalidValue},
+				{offset: 0, key: "rbd.root.timeout", value: "-1s", err: ErrInvalidValue},
+			},
+		},
+		{
+			name:    "Invalid timeout",
+			cmdline: valid + " rbd.root.timeout=soon",
+			want: []want{
+				{offset: len(valid) + 1, key: "rbd.root.timeout", value: "soon", err: ErrInvalidValue},
+			},
+		},
+		{
+			name:    "Overlay image and lower layers",
+			cmdline: valid + ` rbd.root.overlay.image=rbd/node1-upper rbd.root.overlay.lower=apps rbd.apps={"image":{"mons":["192.168.0.1"], "pool":"rbd", "image":"apps"}}`,
+		},
+		{
+			name:    "Overlay image and label",
+			cmdline: valid + " rbd.root.overlay.image=rbd/node1-upper rbd.root.overlay.label=upper",
+			want: []want{
+				{offset: 0, key: "rbd.root.overlay.label", value: "upper", err: ErrInvalidValue},
+			},
+		},
+		{
+			name:    "Overlay size",
+			cmdline: valid + " rbd.root.overlay.size=2GiB",
+			want: []want{
+				{offset: 0, key: "rbd.root.overlay.size", value: "2GiB", err: ErrInvalidValue},
+			},
+		},
+		{
+			name:    "Overlay lower layers",
+			cmdline: valid + ` rbd.root.overlay.lower=apps,base rbd.apps={"image":{"mons":["192.168.0.1"], "pool":"rbd", "image":"apps"}, "path":"/apps"}`,
+			want: []want{
+				{offset: len(valid) + 1 + 33, key: "rbd.apps.path", value: "/apps", err: ErrInvalidValue},
+				{offset: 0, key: "rbd.root.overlay.lower", value: "apps,base", err: ErrUnknownMount},
+			},
+		},
+		{
+			name:    "Clone",
+			cmdline: valid + " rbd.root.image.snap=golden rbd.root.clone=node-${hostname}",
+		},
+		{
+			name:    "Clone without snapshot",
+			cmdline: valid + " rbd.root.clone=node-${hostname}",
+			want: []want{
+				{offset: 0, key: "rbd.root.clone", value: "node-${hostname}", err: ErrInvalidValue},
+			},
+		},
+		{
+			name:    "Clone and overlay image",
+			cmdline: valid + " rbd.root.image.snap=golden rbd.root.clone=node-${hostname} rbd.root.overlay.image=rbd/node1-upper",
+			want: []want{
+				{offset: 0, key: "rbd.root.clone", value: "node-${hostname}", err: ErrInvalidValue},
+			},
+		},
+		{
+			name:    "Unknown template variable",
+			cmdline: valid + " rbd.root.image.snap=golden rbd.root.clone=node-${uuid}",
+			want: []want{
+				{offset: 0, key: "rbd.root.clone", value: "node-${uuid}", err: ErrInvalidValue},
+			},
+		},
+		{
+			name:    "Root not at /",
+			cmdline: `rbd.root={"image":{"mons":["192.168.0.1"], "pool":"rbd", "image":"test-image1"}, "path":"/root", "fstype":"ext4"}`,
+			want: []want{
+				{offset: 0, key: "rbd.root.path", value: "/root", err: ErrInvalidValue},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, diags := Analyze(tt.cmdline, nil)
+			if len(diags) != len(tt.want) {
+				t.Fatalf("Analyze() = %v, want %d diagnostics", diags, len(tt.want))
+			}
+			for i, w := range tt.want {
+				d := diags[i]
+				if d.Offset != w.offset || d.Key != w.key || d.Value != w.value || !errors.Is(d, w.err) {
+					t.Errorf("Analyze()[%d] = {%d %s %q %v}, want {%d %s %q %v}", i, d.Offset, d.Key, d.Value, d.Err, w.offset, w.key, w.value, w.err)
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyze_fill(t *testing.T) {
+	cmdline := `rbd.root={"image":{"pool":"rbd", "image":"test-image1"}, "path":"/", "fstype":"ext4"} rbd.var.path=/var`
+
+	mounts, diags := Analyze(cmdline, func(i *krbd.Image) error {
+		i.Monitors = []string{"192.168.0.1"}
+		return nil
+	})
+	if len(diags) != 1 || diags[0].Key != "rbd.var.image" {
+		t.Errorf("Analyze() = %v, want only missing var image", diags)
+	}
+	if got := mounts["root"].Image.Monitors; !reflect.DeepEqual(got, []string{"192.168.0.1"}) {
+		t.Errorf("Analyze() root monitors = %v, want filled", got)
+	}
+
+	fillErr := errors.New("bad mon_host")
+	_, diags = Analyze(cmdline, func(i *krbd.Image) error {
+		return fillErr
+	})
+	if len(diags) == 0 || diags[0].Key != "rbd.root.image" || !errors.Is(diags[0], fillErr) {
+		t.Errorf("Analyze() = %v, want fill error for rbd.root.image", diags)
+	}
+}
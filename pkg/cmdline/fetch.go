@@ -0,0 +1,183 @@
+package cmdline
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var (
+	// ErrChecksum is returned by FetchConfig when the document doesn't match
+	// the rbd.config.sha256 pinned on the cmdline.
+	ErrChecksum = errors.New("checksum mismatch")
+	// ErrUnsupportedScheme is returned by FetchConfig for URLs other than http,
+	// https, and tftp.
+	ErrUnsupportedScheme = errors.New("unsupported URL scheme")
+)
+
+// maxConfigSize limits the size of a fetched configuration document.
+const maxConfigSize = 1 << 20
+
+// FetchOptions of FetchConfig.
+type FetchOptions struct {
+	// CACert is the path of a PEM bundle of CA certificates trusted for https,
+	// eg. shipped in the initramfs. The system's are used if empty.
+	CACert string
+}
+
+// ConfigURL returns the URL of the rbd.config argument of the cmdline, and
+// the SHA-256 of the document in hex from rbd.config.sha256, both empty if not
+// set.
+//
+// rbd.config=https://boot.example.com/rbd/node1.json
+// rbd.config.sha256=<hex digest>
+func ConfigURL(cmdline string) (string, string, Diagnostics) {
+	var u, sum string
+	var diags Diagnostics
+	for _, f := range fields(cmdline) {
+		if !strings.HasPrefix(f.s, prefix+".config") {
+			continue
+		}
+		key, value := f.s, ""
+		if n := strings.IndexRune(f.s, '='); n > 0 {
+			key, value = f.s[:n], f.s[n+1:]
+		}
+		switch key {
+		case prefix + ".config":
+			if _, err := url.Parse(value); err != nil || value == "" {
+				diags = append(diags, &Diagnostic{Offset: f.offset, Key: key, Value: value, Err: fmt.Errorf("%w: invalid URL", ErrInvalidValue)})
+				continue
+			}
+			u = value
+		case prefix + ".config.sha256":
+			if b, err := hex.DecodeString(value); err != nil || len(b) != sha256.Size {
+				diags = append(diags, &Diagnostic{Offset: f.offset, Key: key, Value: value, Err: fmt.Errorf("%w: expected %d hex digits", ErrInvalidValue, 2*sha256.Size)})
+				continue
+			}
+			sum = strings.ToLower(value)
+		default:
+			if argName(key[len(prefix)+1:]) == "config" {
+				diags = append(diags, &Diagnostic{Offset: f.offset, Key: key, Value: value, Err: ErrUnknownAttr})
+			}
+		}
+	}
+	if sum != "" && u == "" {
+		diags = append(diags, &Diagnostic{Offset: -1, Key: prefix + ".config", Err: fmt.Errorf("%w: rbd.config.sha256 without rbd.config", ErrMissingField)})
+	}
+	return u, sum, diags
+}
+
+// FetchConfig fetches the configuration document at rawURL, via http, https,
+// or tftp, and returns it in the rbd= JSON format, see AnalyzeConfig. A
+// document ending in .toml, or served as TOML, or not starting with { is parsed
+// as TOML. If sum is not empty the document must have that SHA-256 in hex.
+func FetchConfig(ctx context.Context, rawURL string, sum string, opts FetchOptions) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var doc []byte
+	var contentType string
+	switch u.Scheme {
+	case "http", "https":
+		doc, contentType, err = fetchHTTP(ctx, u, opts)
+	case "tftp":
+		doc, err = fetchTFTP(ctx, u)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedScheme, u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", redactURL(u), err)
+	}
+
+	if sum != "" {
+		got := sha256.Sum256(doc)
+		if hex.EncodeToString(got[:]) != strings.ToLower(sum) {
+			return nil, fmt.Errorf("%s: %w: got sha256 %x, want %s", redactURL(u), ErrChecksum, got, sum)
+		}
+	}
+
+	doc, err = decodeConfig(doc, path.Ext(u.Path) == ".toml" || strings.Contains(contentType, "toml"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", redactURL(u), err)
+	}
+	return doc, nil
+}
+
+// decodeConfig returns doc in the rbd= JSON format, parsing it as TOML if toml
+// is set or it doesn't start with {.
+func decodeConfig(doc []byte, toml bool) ([]byte, error) {
+	trimmed := bytes.TrimSpace(doc)
+	if !toml && bytes.HasPrefix(trimmed, []byte("{")) {
+		if !json.Valid(trimmed) {
+			return nil, ErrInvalidJSON
+		}
+		return trimmed, nil
+	}
+	t, err := parseTOML(string(doc))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOML: %w", err)
+	}
+	return json.Marshal(t)
+}
+
+func fetchHTTP(ctx context.Context, u *url.URL, opts FetchOptions) ([]byte, string, error) {
+	client := http.DefaultClient
+	if opts.CACert != "" {
+		pem, err := ioutil.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, "", err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, "", fmt.Errorf("no certificates found in %s", opts.CACert)
+		}
+		client = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%s", resp.Status)
+	}
+	doc, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxConfigSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(doc) > maxConfigSize {
+		return nil, "", fmt.Errorf("larger than %d bytes", maxConfigSize)
+	}
+	return doc, resp.Header.Get("Content-Type"), nil
+}
+
+// redactURL returns u without the password, if any, for error messages.
+func redactURL(u *url.URL) string {
+	if _, ok := u.User.Password(); !ok {
+		return u.String()
+	}
+	r := *u
+	r.User = url.UserPassword(u.User.Username(), "xxxxx")
+	return r.String()
+}
@@ -0,0 +1,312 @@
+package cmdline
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bensallen/rbd/pkg/krbd"
+)
+
+const (
+	configJSON = `{"root":{"image":{"mons":["192.168.0.1"],"pool":"rbd","image":"test-image1"},"path":"/","fstype":"ext4"}}`
+	configTOML = `[root]
+path = "/"
+fstype = "ext4"
+[root.image]
+mons = ["192.168.0.1"]
+pool = "rbd"
+image = "test-image1"
+`
+)
+
+func TestConfigURL(t *testing.T) {
+	const sum = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	tests := []struct {
+		name    string
+		cmdline string
+		wantURL string
+		wantSum string
+		wantErr error
+	}{
+		{
+			name:    "None",
+			cmdline: "console=ttyS0 rbd.root.path=/",
+		},
+		{
+			name:    "URL and checksum",
+			cmdline: "rbd.config=https://boot.example.com/rbd/node1.json rbd.config.sha256=" + strings.ToUpper(sum),
+			wantURL: "https://boot.example.com/rbd/node1.json",
+			wantSum: sum,
+		},
+		{
+			name:    "Invalid checksum",
+			cmdline: "rbd.config=tftp://10.0.0.1/node1.toml rbd.config.sha256=abc",
+			wantErr: ErrInvalidValue,
+		},
+		{
+			name:    "Checksum without URL",
+			cmdline: "rbd.config.sha256=" + sum,
+			wantErr: ErrMissingField,
+		},
+		{
+			name:    "Unknown attribute",
+			cmdline: "rbd.config.md5=x",
+			wantErr: ErrUnknownAttr,
+		},
+		{
+			name:    "Mount named like config",
+			cmdline: "rbd.configs.path=/configs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, sum, diags := ConfigURL(tt.cmdline)
+			if tt.wantErr == nil && len(diags) != 0 || tt.wantErr != nil && !errors.Is(diags, tt.wantErr) {
+				t.Fatalf("ConfigURL() diagnostics = %v, wantErr %v", diags, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if u != tt.wantURL || sum != tt.wantSum {
+				t.Errorf("ConfigURL() = %q, %q, want %q, %q", u, sum, tt.wantURL, tt.wantSum)
+			}
+		})
+	}
+}
+
+func TestFetchConfig(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/node1.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(configJSON))
+	})
+	mux.HandleFunc("/node1.toml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(configTOML))
+	})
+	mux.HandleFunc("/node1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/toml")
+		w.Write([]byte(configTOML))
+	})
+	mux.HandleFunc("/broken.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"root":`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	tlsSrv := httptest.NewUnstartedServer(mux)
+	// Quiet the handshake error of the unknown CA test
+	tlsSrv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+
+	dir, err := ioutil.TempDir("", "rbd-fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caCert := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsSrv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tftp := tftpServer(t, map[string]string{
+		"node1.json": configJSON + strings.Repeat(" ", 1200),
+		"node1.toml": configTOML,
+	})
+
+	jsonSum := sha256.Sum256([]byte(configJSON))
+	tests := []struct {
+		name    string
+		url     string
+		sum     string
+		opts    FetchOptions
+		wantErr error
+	}{
+		{name: "HTTP JSON", url: srv.URL + "/node1.json", sum: hex.EncodeToString(jsonSum[:])},
+		{name: "HTTP TOML", url: srv.URL + "/node1.toml"},
+		{name: "HTTP TOML content type", url: srv.URL + "/node1"},
+		{name: "HTTPS with CA", url: tlsSrv.URL + "/node1.json", opts: FetchOptions{CACert: caCert}},
+		{name: "HTTPS unknown CA", url: tlsSrv.URL + "/node1.json", wantErr: errAny},
+		{name: "TFTP multiple blocks", url: "tftp://" + tftp + "/node1.json"},
+		{name: "TFTP TOML", url: "tftp://" + tftp + "/node1.toml"},
+		{name: "TFTP not found", url: "tftp://" + tftp + "/missing.json", wantErr: errAny},
+		{name: "Not found", url: srv.URL + "/missing.json", wantErr: errAny},
+		{name: "Checksum mismatch", url: srv.URL + "/node1.toml", sum: hex.EncodeToString(jsonSum[:]), wantErr: ErrChecksum},
+		{name: "Invalid JSON", url: srv.URL + "/broken.json", wantErr: ErrInvalidJSON},
+		{name: "Unsupported scheme", url: "nfs://10.0.0.1/node1.json", wantErr: ErrUnsupportedScheme},
+	}
+	want := map[string]*Mount{"root": {Image: &krbd.Image{Monitors: []string{"192.168.0.1"}, Pool: "rbd", Image: "test-image1"}, Path: "/", FsType: "ext4"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			doc, err := FetchConfig(ctx, tt.url, tt.sum, tt.opts)
+			if tt.wantErr == errAny && err != nil {
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FetchConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			got := map[string]*Mount{}
+			if err := parseJSON(got, string(doc)); err != nil {
+				t.Fatalf("FetchConfig() = %s: %v", doc, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("FetchConfig() = %s, want %s", doc, configJSON)
+			}
+		})
+	}
+}
+
+// errAny stands for any error in tests.
+var errAny = errors.New("any error")
+
+// tftpServer serves files via TFTP on localhost until the test ends, and
+// returns its address. The first ACK of each transfer is ignored to exercise
+// retransmission.
+func tftpServer(t *testing.T, files map[string]string) string {
+	t.Helper()
+	timeout := tftpTimeout
+	tftpTimeout = 100 * time.Millisecond
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		tftpTimeout = timeout
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, client, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n < 4 || binary.BigEndian.Uint16(buf) != tftpRRQ {
+				continue
+			}
+			file := string(buf[2:n])
+			file = file[:strings.IndexByte(file, 0)]
+			go tftpTransfer(client, files, file)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// tftpTransfer sends a file to client from a new port, the transfer ID.
+func tftpTransfer(client *net.UDPAddr, files map[string]string, file string) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	data, ok := files[file]
+	if !ok {
+		pkt := []byte{0, tftpERROR, 0, 1}
+		conn.WriteToUDP(append(append(pkt, "File not found"...), 0), client)
+		return
+	}
+	ack := make([]byte, 4)
+	dropped := false
+	for block := 1; ; block++ {
+		end := block * tftpBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		pkt := make([]byte, 4, 4+tftpBlockSize)
+		binary.BigEndian.PutUint16(pkt, tftpDATA)
+		binary.BigEndian.PutUint16(pkt[2:], uint16(block))
+		pkt = append(pkt, data[(block-1)*tftpBlockSize:end]...)
+		for {
+			conn.WriteToUDP(pkt, client)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, _, err := conn.ReadFromUDP(ack); err != nil {
+				return
+			}
+			if !dropped {
+				dropped = true
+				continue
+			}
+			if binary.BigEndian.Uint16(ack[2:]) == uint16(block) {
+				break
+			}
+		}
+		if len(pkt)-4 < tftpBlockSize {
+			return
+		}
+	}
+}
+
+func TestAnalyzeConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmdline string
+		config  string
+		want    map[string]*Mount
+		wantErr error
+	}{
+		{
+			name:    "Config only",
+			cmdline: "rbd.config=http://10.0.0.1/node1.json",
+			config:  configJSON,
+			want:    map[string]*Mount{"root": {Image: &krbd.Image{Monitors: []string{"192.168.0.1"}, Pool: "rbd", Image: "test-image1"}, Path: "/", FsType: "ext4"}},
+		},
+		{
+			name:    "Cmdline overrides config",
+			cmdline: `rbd.config=http://10.0.0.1/node1.json rbd.root.fstype=xfs rbd.var={"image":{"mons":["192.168.0.2"],"pool":"rbd","image":"var"},"path":"/var"}`,
+			config:  configJSON,
+			want: map[string]*Mount{
+				"root": {Image: &krbd.Image{Monitors: []string{"192.168.0.1"}, Pool: "rbd", Image: "test-image1"}, Path: "/", FsType: "xfs"},
+				"var":  {Image: &krbd.Image{Monitors: []string{"192.168.0.2"}, Pool: "rbd", Image: "var"}, Path: "/var"},
+			},
+		},
+		{
+			name:    "Invalid config",
+			cmdline: "rbd.config=http://10.0.0.1/node1.json",
+			config:  `{"root":"/"}`,
+			wantErr: ErrInvalidJSON,
+		},
+		{
+			name:    "Network in config",
+			cmdline: "rbd.config=http://10.0.0.1/node1.json",
+			config:  `{"net":{"interfaces":[{"dhcp":true}]}}`,
+			wantErr: ErrInvalidValue,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, diags := AnalyzeConfig(tt.cmdline, []byte(tt.config), nil)
+			if tt.wantErr == nil && len(diags) != 0 || tt.wantErr != nil && !errors.Is(diags, tt.wantErr) {
+				t.Fatalf("AnalyzeConfig() diagnostics = %v, wantErr %v", diags, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if diags[0].Key != "rbd.config" || diags[0].Offset != 0 {
+					t.Errorf("AnalyzeConfig() = %v, want reported at rbd.config", diags)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AnalyzeConfig() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package cmdline
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bensallen/rbd/pkg/krbd"
+)
+
+// DefaultFiles are the configuration files looked for, in order, when none is
+// given, see FindFile.
+var DefaultFiles = []string{"/etc/rbd/rbdmap.json", "/etc/ceph/rbdmap"}
+
+// FindFile returns the first of DefaultFiles that exists.
+func FindFile() (string, error) {
+	for _, f := range DefaultFiles {
+		if _, err := os.Stat(f); err == nil {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("none of %s found", strings.Join(DefaultFiles, ", "))
+}
+
+// rbdmapAttrs are the options of the rbdmap format that are named after the
+// flags of upstream rbd map, rather than after an attribute of the rbd.<name>
+// arguments.
+var rbdmapAttrs = map[string]func(m *Mount, value string) error{
+	"id":         imageAttr("user"),
+	"secretfile": imageAttr("keyfile"),
+	"options":    imageAttr("opts"),
+	"read-only": func(m *Mount, value string) error {
+		options(m.image()).ReadOnly = true
+		return nil
+	},
+}
+
+func imageAttr(attr string) func(m *Mount, value string) error {
+	return func(m *Mount, value string) error {
+		return imageAttrs[attr](m.image(), value)
+	}
+}
+
+// AnalyzeFile is Analyze for a configuration file instead of the cmdline, eg.
+// to map and mount outside of the initramfs. A file ending in .json or .toml,
+// or starting with {, is in the rbd= JSON format or its TOML equivalent, see
+// FetchConfig. Otherwise it is in the format of Ceph's rbdmap script, an image
+// spec, in the rbd pool if it has none, and comma separated options per line:
+//
+//	# <pool>/[<namespace>/]<image>[@<snap>]  <options>
+//	rbd/data1  id=admin,keyring=/etc/ceph/ceph.client.admin.keyring
+//	rbd/data2  id=admin,secretfile=/etc/ceph/admin.secret,path=/srv/data2,mntopts="noatime,discard"
+//
+// The options id, secretfile, options, and read-only are those of upstream rbd
+// map, any other option is an attribute of rbd.<name>, or rbd.<name>.image
+// if there is no such attribute, eg. path, fstype, or mons. Values containing
+// commas are quoted. The name of the mount is the image spec unless set by the
+// name option. The Offset of Diagnostics is the line number, or 0 for the JSON
+// and TOML formats.
+//
+// Unlike on the cmdline, path is optional, mounts without one are only mapped.
+// The error is for reading the file, problems with its content are returned as
+// Diagnostics.
+func AnalyzeFile(name string, fill func(*krbd.Image) error) (map[string]*Mount, Diagnostics, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mounts := map[string]*Mount{}
+	offsets := map[string]int{}
+	var diags Diagnostics
+	ext := filepath.Ext(name)
+	if ext == ".json" || ext == ".toml" || bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		doc, err := decodeConfig(data, ext == ".toml")
+		if err == nil {
+			err = parseConfig(mounts, doc)
+		}
+		if err != nil {
+			diags = append(diags, &Diagnostic{Offset: 0, Key: name, Err: err})
+		}
+		for name := range mounts {
+			offsets[name] = 0
+		}
+	} else {
+		mounts, offsets, diags = parseRBDMap(data)
+	}
+	return mounts, append(diags, analyze(mounts, offsets, fill, false)...), nil
+}
+
+// parseRBDMap parses the lines of a file in the rbdmap format into mounts, see
+// AnalyzeFile, additionally returning the line number of each mount.
+func parseRBDMap(data []byte) (map[string]*Mount, map[string]int, Diagnostics) {
+	mounts := map[string]*Mount{}
+	lines := map[string]int{}
+	var diags Diagnostics
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		spec, opts := f[0], ""
+		if len(f) > 1 {
+			opts = strings.Join(f[1:], " ")
+		}
+		name, m, diag := parseRBDMapLine(spec, opts)
+		if diag != nil {
+			diag.Offset = n
+			diags = append(diags, diag)
+			continue
+		}
+		if other, ok := lines[name]; ok {
+			diags = append(diags, &Diagnostic{Offset: n, Key: name, Value: spec, Err: fmt.Errorf("%w: name also used on line %d", ErrInvalidValue, other)})
+			continue
+		}
+		mounts[name] = m
+		lines[name] = n
+	}
+	return mounts, lines, diags
+}
+
+// parseRBDMapLine parses the image spec and options of a single line.
+func parseRBDMapLine(spec string, opts string) (string, *Mount, *Diagnostic) {
+	m := &Mount{}
+	if err := m.image().ParseSpec(spec); err != nil {
+		return "", nil, &Diagnostic{Key: spec, Value: spec, Err: fmt.Errorf("%w: %v", ErrInvalidValue, err)}
+	}
+	if m.Image.Pool == "" {
+		m.Image.Pool = krbd.DefaultPool
+	}
+	name := spec
+	for _, opt := range splitQuoted(opts) {
+		key, value := opt, ""
+		if n := strings.IndexRune(opt, '='); n >= 0 {
+			key, value = opt[:n], strings.Trim(opt[n+1:], `"`)
+		}
+		var err error
+		switch {
+		case rbdmapAttrs[key] != nil:
+			err = rbdmapAttrs[key](m, value)
+		case key == "name":
+			name = value
+		case mountAttrs[key] != nil && key != "image":
+			err = mountAttrs[key](m, value)
+		case imageAttrs[key] != nil:
+			err = imageAttrs[key](m.image(), value)
+		default:
+			err = fmt.Errorf("%w %q", ErrUnknownAttr, key)
+		}
+		if err == nil && name == "" {
+			err = fmt.Errorf("%w: empty name", ErrInvalidValue)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrUnknownAttr) && !errors.Is(err, ErrInvalidValue) {
+				err = fmt.Errorf("%w: %v", ErrInvalidValue, err)
+			}
+			return "", nil, &Diagnostic{Key: spec + "." + key, Value: redact(spec+"."+key, value), Err: err}
+		}
+	}
+	return name, m, nil
+}
+
+// splitQuoted splits a comma separated list of options, except for commas
+// within double quotes.
+func splitQuoted(s string) []string {
+	var out []string
+	quoted := false
+	start := 0
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			if i > start {
+				out = append(out, s[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if start < len(s) {
+		out = append(out, s[start:])
+	}
+	return out
+}
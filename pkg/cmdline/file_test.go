@@ -0,0 +1,118 @@
+package cmdline
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bensallen/rbd/pkg/krbd"
+)
+
+func TestAnalyzeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbd-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	type want struct {
+		offset int
+		key    string
+		err    error
+	}
+	tests := []struct {
+		name      string
+		file      string
+		content   string
+		want      map[string]*Mount
+		wantDiags []want
+	}{
+		{
+			name: "rbdmap",
+			file: "rbdmap",
+			content: `# RBD images to map
+rbd/data1	id=admin,keyring=/etc/ceph/ceph.client.admin.keyring,mons=192.168.0.1
+
+rbd/ns1/data2@snap1 id=admin,secretfile=/etc/ceph/admin.secret,read-only,mons="192.168.0.1,192.168.0.2",path=/srv/data2,mntopts="noatime,discard",name=data2
+`,
+			want: map[string]*Mount{
+				"rbd/data1": {Image: &krbd.Image{Monitors: []string{"192.168.0.1"}, Pool: "rbd", Image: "data1", Keyring: "/etc/ceph/ceph.client.admin.keyring", Options: &krbd.Options{Name: "admin"}}},
+				"data2": {
+					Image:     &krbd.Image{Monitors: []string{"192.168.0.1", "192.168.0.2"}, Pool: "rbd", Image: "data2", Snapshot: "snap1", Keyfile: "/etc/ceph/admin.secret", Options: &krbd.Options{Name: "admin", Namespace: "ns1", ReadOnly: true}},
+					Path:      "/srv/data2",
+					MountOpts: []string{"noatime", "discard"},
+				},
+			},
+		},
+		{
+			name:    "rbdmap without pool",
+			file:    "rbdmap",
+			content: "data1 id=admin,mons=192.168.0.1\nother/data2 id=admin,mons=192.168.0.1\ndata3 id=admin,mons=192.168.0.1,pool=other\n",
+			want: map[string]*Mount{
+				"data1":       {Image: &krbd.Image{Monitors: []string{"192.168.0.1"}, Pool: "rbd", Image: "data1", Options: &krbd.Options{Name: "admin"}}},
+				"other/data2": {Image: &krbd.Image{Monitors: []string{"192.168.0.1"}, Pool: "other", Image: "data2", Options: &krbd.Options{Name: "admin"}}},
+				"data3":       {Image: &krbd.Image{Monitors: []string{"192.168.0.1"}, Pool: "other", Image: "data3", Options: &krbd.Options{Name: "admin"}}},
+			},
+		},
+		{
+			name:    "rbdmap problems",
+			file:    "rbdmap",
+			content: "rbd/data1 mons=192.168.0.1\nrbd/data2 mons=192.168.0.1,bogus=1\nrbd/data3 mons=192.168.0.1,name=rbd/data1\nrbd/data4 path=data4",
+			wantDiags: []want{
+				{offset: 2, key: "rbd/data2.bogus", err: ErrUnknownAttr},
+				{offset: 3, key: "rbd/data1", err: ErrInvalidValue},
+				{offset: 4, key: "rbd.rbd/data4.image.mons", err: ErrMissingField},
+				{offset: 4, key: "rbd.rbd/data4.path", err: ErrInvalidValue},
+			},
+		},
+		{
+			name:    "JSON",
+			file:    "rbdmap.json",
+			content: `{"data":{"image":{"mons":["192.168.0.1"],"pool":"rbd","image":"data"},"path":"/srv/data","fstype":"xfs"}}`,
+			want:    map[string]*Mount{"data": {Image: &krbd.Image{Monitors: []string{"192.168.0.1"}, Pool: "rbd", Image: "data"}, Path: "/srv/data", FsType: "xfs"}},
+		},
+		{
+			name:    "TOML",
+			file:    "rbdmap.toml",
+			content: "[data]\nimage = {mons = [\"192.168.0.1\"], pool = \"rbd\", image = \"data\"}\n",
+			want:    map[string]*Mount{"data": {Image: &krbd.Image{Monitors: []string{"192.168.0.1"}, Pool: "rbd", Image: "data"}}},
+		},
+		{
+			name:      "Invalid JSON",
+			file:      "rbdmap.json",
+			content:   `{"data":`,
+			wantDiags: []want{{offset: 0, key: "rbdmap.json", err: ErrInvalidJSON}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, tt.file)
+			if err := ioutil.WriteFile(name, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, diags, err := AnalyzeFile(name, nil)
+			if err != nil {
+				t.Fatalf("AnalyzeFile() error = %v", err)
+			}
+			if len(diags) != len(tt.wantDiags) {
+				t.Fatalf("AnalyzeFile() = %v, want %d diagnostics", diags, len(tt.wantDiags))
+			}
+			for i, w := range tt.wantDiags {
+				d := diags[i]
+				if d.Offset != w.offset || d.Key != w.key && d.Key != filepath.Join(dir, w.key) || !errors.Is(d.Err, w.err) {
+					t.Errorf("AnalyzeFile()[%d] = {%d %s %v}, want {%d %s %v}", i, d.Offset, d.Key, d.Err, w.offset, w.key, w.err)
+				}
+			}
+			if len(tt.wantDiags) == 0 && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AnalyzeFile() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := AnalyzeFile(filepath.Join(dir, "missing"), nil); !os.IsNotExist(err) {
+		t.Errorf("AnalyzeFile() error = %v, want not exist", err)
+	}
+}
@@ -0,0 +1,92 @@
+package cmdline
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bensallen/rbd/pkg/netconf"
+)
+
+// reserved are names of rbd.<name> arguments that configure something other
+// than a mount, see Net and ConfigURL. They are skipped by Parse.
+var reserved = map[string]bool{
+	"net":    true,
+	"config": true,
+}
+
+// Net returns the network configuration of the cmdline, from the kernel ip=
+// arguments and the rbd.net JSON block, see netconf.Config, or nil if there is
+// neither. Interfaces of rbd.net are configured after those of ip=, and its
+// hostname and nameservers take precedence.
+//
+// ip=192.168.0.10::192.168.0.1:255.255.255.0:node1:ens1f0:off
+// rbd.net={"interfaces":[{"device":"ens1f0","vlan":100,"dhcp":true}]}
+// rbd={"net":{"interfaces":[{"dhcp":true}]}, "root":{...}}
+func Net(cmdline string) (*netconf.Config, Diagnostics) {
+	var c *netconf.Config
+	var diags Diagnostics
+	add := func(f field, key string, value string, err error) {
+		diags = append(diags, &Diagnostic{Offset: f.offset, Key: key, Value: value, Err: err})
+	}
+	config := func() *netconf.Config {
+		if c == nil {
+			c = &netconf.Config{}
+		}
+		return c
+	}
+
+	for _, f := range fields(cmdline) {
+		switch {
+		case strings.HasPrefix(f.s, "ip="):
+			value := f.s[len("ip="):]
+			if err := netconf.ParseIP(value, config()); err != nil {
+				add(f, "ip", value, fmt.Errorf("%w: %v", ErrInvalidValue, err))
+			}
+		case strings.HasPrefix(f.s, prefix+".net="):
+			value := f.s[len(prefix+".net="):]
+			if err := mergeNet(config(), []byte(value)); err != nil {
+				add(f, prefix+".net", value, err)
+			}
+		case strings.HasPrefix(f.s, prefix+".net."):
+			key := f.s
+			if n := strings.IndexRune(key, '='); n > 0 {
+				key = key[:n]
+			}
+			add(f, key, "", fmt.Errorf("%w: rbd.net only takes a JSON value", ErrMalformedKey))
+		case strings.HasPrefix(f.s, prefix+"="):
+			raw := map[string]json.RawMessage{}
+			if err := json.Unmarshal([]byte(f.s[len(prefix)+1:]), &raw); err != nil {
+				// Reported by Parse
+				continue
+			}
+			if msg, ok := raw["net"]; ok {
+				if err := mergeNet(config(), msg); err != nil {
+					add(f, prefix, "", err)
+				}
+			}
+		}
+	}
+	if c != nil && len(diags) == 0 {
+		if err := c.Validate(); err != nil {
+			diags = append(diags, &Diagnostic{Offset: -1, Key: prefix + ".net", Err: fmt.Errorf("%w: %v", ErrInvalidValue, err)})
+		}
+	}
+	return c, diags
+}
+
+// mergeNet unmarshals the rbd.net JSON block b into c, adding its interfaces.
+func mergeNet(c *netconf.Config, b []byte) error {
+	var n netconf.Config
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+	}
+	c.Interfaces = append(c.Interfaces, n.Interfaces...)
+	if n.Hostname != "" {
+		c.Hostname = n.Hostname
+	}
+	if len(n.DNS) != 0 {
+		c.DNS = n.DNS
+	}
+	return nil
+}
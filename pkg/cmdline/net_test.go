@@ -0,0 +1,89 @@
+package cmdline
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bensallen/rbd/pkg/netconf"
+)
+
+func TestNet(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmdline string
+		want    *netconf.Config
+		wantErr error
+	}{
+		{
+			name:    "None",
+			cmdline: "console=ttyS0 rbd.root.path=/",
+		},
+		{
+			name:    "ip=dhcp",
+			cmdline: "console=ttyS0 ip=dhcp rbd.root.path=/",
+			want:    &netconf.Config{Interfaces: []netconf.Interface{{DHCP: true}}},
+		},
+		{
+			name:    "ip= and rbd.net",
+			cmdline: `ip=192.168.0.10::192.168.0.1:255.255.255.0:node1:eth0:off rbd.net={"interfaces":[{"device":"ens1f0","vlan":100,"dhcp":true}],"dns":["192.168.0.2"]}`,
+			want: &netconf.Config{
+				Interfaces: []netconf.Interface{
+					{Device: "eth0", Addresses: []string{"192.168.0.10/24"}, Gateway: "192.168.0.1"},
+					{Device: "ens1f0", VLAN: 100, DHCP: true},
+				},
+				Hostname: "node1",
+				DNS:      []string{"192.168.0.2"},
+			},
+		},
+		{
+			name:    "Bare rbd=",
+			cmdline: `rbd={"net":{"interfaces":[{"device":"eth0","dhcp":true}]}, "root":{"path":"/"}}`,
+			want:    &netconf.Config{Interfaces: []netconf.Interface{{Device: "eth0", DHCP: true}}},
+		},
+		{
+			name:    "Invalid ip=",
+			cmdline: "ip=sometimes",
+			wantErr: ErrInvalidValue,
+		},
+		{
+			name:    "Invalid JSON",
+			cmdline: `rbd.net={"interfaces":{}}`,
+			wantErr: ErrInvalidJSON,
+		},
+		{
+			name:    "Dotted key",
+			cmdline: `rbd.net.device=eth0`,
+			wantErr: ErrMalformedKey,
+		},
+		{
+			name:    "Incomplete",
+			cmdline: `rbd.net={"interfaces":[{"device":"eth0"}]}`,
+			wantErr: ErrInvalidValue,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, diags := Net(tt.cmdline)
+			if tt.wantErr == nil && len(diags) != 0 || tt.wantErr != nil && !errors.Is(diags, tt.wantErr) {
+				t.Fatalf("Net() diagnostics = %v, wantErr %v", diags, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Net() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_reserved(t *testing.T) {
+	mounts, err := Parse(`rbd.net={"interfaces":[{"dhcp":true}]} rbd.net.device=eth0 rbd={"net":{}, "root":{"path":"/"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(mounts, map[string]*Mount{"root": {Path: "/"}}) {
+		t.Errorf("Parse() = %v, want only root", mounts)
+	}
+}
@@ -0,0 +1,209 @@
+package cmdline
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUnknownMount is returned when after or requires references a mount that doesn't exist.
+	ErrUnknownMount = errors.New("unknown mount")
+	// ErrDependencyCycle is returned when mounts depend on each other.
+	ErrDependencyCycle = errors.New("dependency cycle")
+	// ErrDependencyFailed is returned by Plan.Run for mounts skipped because a
+	// mount they require failed.
+	ErrDependencyFailed = errors.New("required mount failed")
+)
+
+// dependency of a mount on another mount.
+type dependency struct {
+	name string
+	// required is set when the mount is skipped if the dependency fails.
+	required bool
+}
+
+// Plan orders mounts so that a mount comes after the mount of its parent path,
+// eg. /var/lib after /var after /, after the lower layers of its overlay, and
+// after the mounts listed in its After and Requires.
+type Plan struct {
+	mounts map[string]*Mount
+	deps   map[string][]dependency
+	order  []string
+}
+
+// NewPlan returns the Plan for mounts, or an error if a mount depends on an
+// unknown mount or the dependencies form a cycle.
+func NewPlan(mounts map[string]*Mount) (*Plan, error) {
+	p := &Plan{mounts: mounts, deps: map[string][]dependency{}}
+	for _, name := range sortedNames(mounts) {
+		deps, err := dependencies(name, mounts)
+		if err != nil {
+			return nil, err
+		}
+		p.deps[name] = deps
+	}
+
+	// Depth first topological sort, visiting names in sorted order so the
+	// order is stable.
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var visit func(name string, stack []string) error
+	visit = func(name string, stack []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(append(stack, name), " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, d := range p.deps[name] {
+			if err := visit(d.name, append(stack, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		p.order = append(p.order, name)
+		return nil
+	}
+	for _, name := range sortedNames(mounts) {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// dependencies returns the mounts the mount name depends on: the mount of its
+// nearest parent path and the lower layers of its overlay, which are required,
+// and those listed in After and Requires.
+func dependencies(name string, mounts map[string]*Mount) ([]dependency, error) {
+	m := mounts[name]
+	var deps []dependency
+	add := func(dep string, required bool) {
+		for i := range deps {
+			if deps[i].name == dep {
+				deps[i].required = deps[i].required || required
+				return
+			}
+		}
+		deps = append(deps, dependency{name: dep, required: required})
+	}
+
+	if parent := parentMount(name, mounts); parent != "" {
+		add(parent, true)
+	}
+	var lower []string
+	if m.Overlay.Enabled {
+		lower = m.Overlay.Lower
+	}
+	for _, list := range []struct {
+		names    []string
+		required bool
+	}{{lower, true}, {m.After, false}, {m.Requires, true}} {
+		for _, dep := range list.names {
+			if _, ok := mounts[dep]; !ok {
+				return nil, fmt.Errorf("%w %q referenced by %s", ErrUnknownMount, dep, name)
+			}
+			if dep == name {
+				return nil, fmt.Errorf("%w: %s depends on itself", ErrDependencyCycle, name)
+			}
+			add(dep, list.required)
+		}
+	}
+	return deps, nil
+}
+
+// parentMount returns the name of the mount with the longest path that
+// contains the path of the mount name, or empty if there is none or the mount
+// has no path.
+func parentMount(name string, mounts map[string]*Mount) string {
+	if mounts[name].Path == "" {
+		// Only mapped, see AnalyzeFile
+		return ""
+	}
+	p := path.Clean(mounts[name].Path)
+	parent, parentLen := "", -1
+	for other, m := range mounts {
+		if other == name || m.Path == "" {
+			continue
+		}
+		q := path.Clean(m.Path)
+		if q == p || !(q == "/" || strings.HasPrefix(p, q+"/")) {
+			continue
+		}
+		// Break ties between, invalid, duplicate paths by name to stay stable.
+		if len(q) > parentLen || (len(q) == parentLen && other < parent) {
+			parent, parentLen = other, len(q)
+		}
+	}
+	return parent
+}
+
+// Order returns the mount names in an order where every mount comes after
+// the mounts it depends on.
+func (p *Plan) Order() []string {
+	return append([]string(nil), p.order...)
+}
+
+// Dependencies returns the names of the mounts that name is mounted after.
+func (p *Plan) Dependencies(name string) []string {
+	names := make([]string, len(p.deps[name]))
+	for i, d := range p.deps[name] {
+		names[i] = d.name
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run calls fn for every mount once the mounts it depends on are done, with
+// mounts that don't depend on each other run concurrently. A mount whose
+// parent path mount, or one of its Requires, failed is skipped with an error
+// wrapping ErrDependencyFailed. The errors of the failed and skipped mounts are
+// returned by name.
+func (p *Plan) Run(fn func(name string, m *Mount) error) map[string]error {
+	var (
+		mu   sync.Mutex
+		errs = map[string]error{}
+		wg   sync.WaitGroup
+		done = make(map[string]chan struct{}, len(p.order))
+	)
+	for _, name := range p.order {
+		done[name] = make(chan struct{})
+	}
+
+	for _, name := range p.order {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			defer close(done[name])
+
+			var err error
+			for _, d := range p.deps[name] {
+				<-done[d.name]
+				mu.Lock()
+				failed := errs[d.name] != nil
+				mu.Unlock()
+				if failed && d.required && err == nil {
+					err = fmt.Errorf("%w: %s", ErrDependencyFailed, d.name)
+				}
+			}
+			if err == nil {
+				err = fn(name, p.mounts[name])
+			}
+			if err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+	return errs
+}
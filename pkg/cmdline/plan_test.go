@@ -0,0 +1,180 @@
+package cmdline
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewPlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		mounts  map[string]*Mount
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "Nested paths",
+			mounts: map[string]*Mount{
+				"a":    {Path: "/var/lib"},
+				"b":    {Path: "/var"},
+				"c":    {Path: "/var/lib/docker"},
+				"root": {Path: "/"},
+				"d":    {Path: "/variable"},
+			},
+			want: []string{"root", "b", "a", "c", "d"},
+		},
+		{
+			name: "After and requires",
+			mounts: map[string]*Mount{
+				"root": {Path: "/"},
+				"home": {Path: "/home", After: []string{"srv"}},
+				"srv":  {Path: "/srv", Requires: []string{"tmp"}},
+				"tmp":  {Path: "/tmp"},
+			},
+			want: []string{"root", "tmp", "srv", "home"},
+		},
+		{
+			name: "Without root",
+			mounts: map[string]*Mount{
+				"b": {Path: "/data/b"},
+				"a": {Path: "/data"},
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "Without path",
+			mounts: map[string]*Mount{
+				"root": {Path: "/"},
+				"data": {},
+				"srv":  {Path: "/srv", Requires: []string{"data"}},
+			},
+			want: []string{"data", "root", "srv"},
+		},
+		{
+			name: "Overlay layers",
+			mounts: map[string]*Mount{
+				"root": {Path: "/", Overlay: Overlay{Enabled: true, Lower: []string{"base"}}},
+				"apps": {Path: "/opt/apps", Overlay: Overlay{Enabled: true, Lower: []string{"libs"}}},
+				"libs": {},
+				"base": {},
+			},
+			want: []string{"base", "root", "libs", "apps"},
+		},
+		{
+			name: "Overlay disabled",
+			mounts: map[string]*Mount{
+				"root": {Path: "/", Overlay: Overlay{Lower: []string{"base"}}},
+				"base": {},
+			},
+			want: []string{"base", "root"},
+		},
+		{
+			name: "Cycle",
+			mounts: map[string]*Mount{
+				"root": {Path: "/"},
+				"a":    {Path: "/a", After: []string{"b"}},
+				"b":    {Path: "/b", Requires: []string{"a"}},
+			},
+			wantErr: ErrDependencyCycle,
+		},
+		{
+			name: "Cycle with parent",
+			mounts: map[string]*Mount{
+				"root": {Path: "/", After: []string{"var"}},
+				"var":  {Path: "/var"},
+			},
+			wantErr: ErrDependencyCycle,
+		},
+		{
+			name: "Self",
+			mounts: map[string]*Mount{
+				"a": {Path: "/a", After: []string{"a"}},
+			},
+			wantErr: ErrDependencyCycle,
+		},
+		{
+			name: "Unknown",
+			mounts: map[string]*Mount{
+				"a": {Path: "/a", Requires: []string{"b"}},
+			},
+			wantErr: ErrUnknownMount,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewPlan(tt.mounts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewPlan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(got.Order(), tt.want) {
+				t.Errorf("NewPlan().Order() = %v, want %v", got.Order(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPlan_Run(t *testing.T) {
+	mounts := map[string]*Mount{
+		"root":    {Path: "/"},
+		"var":     {Path: "/var"},
+		"varlib":  {Path: "/var/lib"},
+		"home":    {Path: "/home", After: []string{"var"}},
+		"srv":     {Path: "/srv", Requires: []string{"var"}},
+		"tmp":     {Path: "/tmp"},
+		"scratch": {Path: "/scratch"},
+	}
+	p, err := NewPlan(mounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tmp and scratch only depend on root, so they must be able to run at the
+	// same time.
+	var barrier sync.WaitGroup
+	barrier.Add(2)
+	concurrent := func() error {
+		barrier.Done()
+		ch := make(chan struct{})
+		go func() { barrier.Wait(); close(ch) }()
+		select {
+		case <-ch:
+			return nil
+		case <-time.After(time.Second):
+			return errors.New("not run concurrently")
+		}
+	}
+
+	var mu sync.Mutex
+	var ran []string
+	errVar := errors.New("map failed")
+	errs := p.Run(func(name string, m *Mount) error {
+		mu.Lock()
+		ran = append(ran, name)
+		mu.Unlock()
+		switch name {
+		case "var":
+			return errVar
+		case "tmp", "scratch":
+			return concurrent()
+		}
+		return nil
+	})
+
+	if len(errs) != 3 || !errors.Is(errs["var"], errVar) || !errors.Is(errs["varlib"], ErrDependencyFailed) || !errors.Is(errs["srv"], ErrDependencyFailed) {
+		t.Errorf("Plan.Run() = %v, want var failed, and varlib and srv skipped", errs)
+	}
+	for _, name := range ran {
+		if name == "varlib" || name == "srv" {
+			t.Errorf("Plan.Run() ran %s, want skipped", name)
+		}
+	}
+	if len(ran) != 5 || ran[0] != "root" {
+		t.Errorf("Plan.Run() ran %v, want root first and 5 mounts", ran)
+	}
+}
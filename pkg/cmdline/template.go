@@ -0,0 +1,81 @@
+package cmdline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bensallen/rbd/pkg/krbd"
+)
+
+// TemplateVars are the variables that image names may contain as ${var}, eg.
+// rbd.root.clone=node-${hostname}: hostname, mac, and serial, see Expand.
+var TemplateVars = []string{"hostname", "mac", "serial"}
+
+// Expand replaces the variables in the image names of mounts, including the
+// overlay images, eg. the per node image of a clone, with their values in vars.
+// It fails if a variable used isn't in vars, eg. because the node has no DMI
+// serial.
+func Expand(mounts map[string]*Mount, vars map[string]string) error {
+	for _, name := range sortedNames(mounts) {
+		m := mounts[name]
+		for _, img := range []*krbd.Image{m.Image, m.Overlay.Image} {
+			if img == nil {
+				continue
+			}
+			s, err := expand(img.Image, vars)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			img.Image = s
+		}
+	}
+	return nil
+}
+
+// expand returns s with each ${var} replaced by its value in vars.
+func expand(s string, vars map[string]string) (string, error) {
+	var b strings.Builder
+	rest := s
+	for {
+		start := strings.Index(rest, "${")
+		if start < 0 {
+			b.WriteString(rest)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", s)
+		}
+		name := rest[start+2 : start+end]
+		value, ok := vars[name]
+		switch {
+		case !isTemplateVar(name):
+			return "", fmt.Errorf("unknown variable ${%s} in %q, expected one of %s", name, s, strings.Join(TemplateVars, ", "))
+		case !ok || value == "":
+			return "", fmt.Errorf("variable ${%s} of %q isn't known on this node", name, s)
+		}
+		b.WriteString(rest[:start])
+		b.WriteString(value)
+		rest = rest[start+end+1:]
+	}
+}
+
+func isTemplateVar(name string) bool {
+	for _, v := range TemplateVars {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTemplate returns an error if s contains an unknown or unterminated
+// variable, regardless of the values on this node.
+func checkTemplate(s string) error {
+	vars := make(map[string]string, len(TemplateVars))
+	for _, v := range TemplateVars {
+		vars[v] = v
+	}
+	_, err := expand(s, vars)
+	return err
+}
@@ -0,0 +1,59 @@
+package cmdline
+
+import "testing"
+
+func Test_expand(t *testing.T) {
+	vars := map[string]string{"hostname": "node1", "mac": "52-54-00-12-34-56"}
+	tests := []struct {
+		name    string
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{name: "No variables", s: "node1-upper", want: "node1-upper"},
+		{name: "Hostname", s: "node-${hostname}", want: "node-node1"},
+		{name: "Several", s: "${hostname}-${mac}-upper", want: "node1-52-54-00-12-34-56-upper"},
+		{name: "Dollar", s: "node$1", want: "node$1"},
+		{name: "Unknown", s: "node-${uuid}", wantErr: true},
+		{name: "Not known on this node", s: "node-${serial}", wantErr: true},
+		{name: "Unterminated", s: "node-${hostname", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expand(tt.s, vars)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("expand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpand(t *testing.T) {
+	mounts, diags := Analyze(`rbd.root={"image":{"mons":["192.168.0.1"], "spec":"rbd/ns1/golden@v1"}, "path":"/", "clone":"node-${hostname}"}`, nil)
+	if len(diags) != 0 {
+		t.Fatalf("Analyze() = %v", diags)
+	}
+	if err := Expand(mounts, map[string]string{"hostname": "node1"}); err != nil {
+		t.Fatalf("Expand() error = %v", err)
+	}
+	root := mounts["root"]
+	if root.Image.Image != "golden" || root.Image.Snapshot != "v1" {
+		t.Errorf("Expand() image = %s, want rbd/ns1/golden@v1", root.Image.Spec())
+	}
+	upper := root.Overlay.Image
+	if !root.Overlay.Enabled || upper == nil {
+		t.Fatalf("Analyze() overlay = %+v, want the clone image", root.Overlay)
+	}
+	if got := upper.Spec(); got != "rbd/ns1/node-node1" {
+		t.Errorf("Expand() clone = %s, want rbd/ns1/node-node1", got)
+	}
+	if len(upper.Monitors) != 1 {
+		t.Errorf("Analyze() clone monitors = %v, want those of the image", upper.Monitors)
+	}
+	if err := Expand(mounts, map[string]string{}); err != nil {
+		t.Errorf("Expand() of expanded names error = %v", err)
+	}
+}
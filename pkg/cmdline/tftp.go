@@ -0,0 +1,131 @@
+package cmdline
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TFTP opcodes, see RFC 1350.
+const (
+	tftpRRQ   = 1
+	tftpDATA  = 3
+	tftpACK   = 4
+	tftpERROR = 5
+
+	tftpBlockSize = 512
+	tftpPort      = "69"
+)
+
+// tftpTimeout is how long to wait for a packet before retransmitting, and
+// tftpRetries how often to retransmit before giving up.
+var (
+	tftpTimeout = 2 * time.Second
+	tftpRetries = 5
+)
+
+// fetchTFTP reads the file of tftp://host[:port]/path in octet mode, without
+// any of the option extensions.
+func fetchTFTP(ctx context.Context, u *url.URL) ([]byte, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), tftpPort)
+	}
+	server, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	file := strings.TrimPrefix(u.Path, "/")
+	rrq := make([]byte, 2, 2+len(file)+len("octet")+2)
+	binary.BigEndian.PutUint16(rrq, tftpRRQ)
+	rrq = append(rrq, file...)
+	rrq = append(rrq, 0)
+	rrq = append(rrq, "octet"...)
+	rrq = append(rrq, 0)
+
+	var doc bytes.Buffer
+	// The server answers from a new port, its transfer ID, which all further
+	// packets are sent to.
+	var tid *net.UDPAddr
+	last, lastTo := rrq, server
+	block := uint16(1)
+	buf := make([]byte, 4+tftpBlockSize)
+	for retries := 0; ; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := conn.WriteToUDP(last, lastTo); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(tftpTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				if retries++; retries > tftpRetries {
+					return nil, fmt.Errorf("timeout waiting for block %d", block)
+				}
+				continue
+			}
+			return nil, err
+		}
+		if tid != nil && (!from.IP.Equal(tid.IP) || from.Port != tid.Port) {
+			// Not from the server of this transfer
+			continue
+		}
+		if n < 4 {
+			return nil, fmt.Errorf("short packet")
+		}
+
+		switch binary.BigEndian.Uint16(buf) {
+		case tftpERROR:
+			msg := buf[4:n]
+			if i := bytes.IndexByte(msg, 0); i >= 0 {
+				msg = msg[:i]
+			}
+			return nil, fmt.Errorf("tftp error %d: %s", binary.BigEndian.Uint16(buf[2:]), msg)
+		case tftpDATA:
+		default:
+			return nil, fmt.Errorf("unexpected tftp opcode %d", binary.BigEndian.Uint16(buf))
+		}
+		tid = from
+
+		ack := make([]byte, 4)
+		binary.BigEndian.PutUint16(ack, tftpACK)
+		copy(ack[2:], buf[2:4])
+		if binary.BigEndian.Uint16(buf[2:]) != block {
+			// A duplicate of the previous block, the server missed our ACK.
+			last, lastTo = ack, tid
+			continue
+		}
+		retries = 0
+		doc.Write(buf[4:n])
+		if doc.Len() > maxConfigSize {
+			return nil, fmt.Errorf("larger than %d bytes", maxConfigSize)
+		}
+		if n-4 < tftpBlockSize {
+			// The last block, the final ACK isn't retransmitted.
+			conn.WriteToUDP(ack, tid)
+			return doc.Bytes(), nil
+		}
+		last, lastTo = ack, tid
+		block++
+	}
+}
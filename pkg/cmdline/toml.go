@@ -0,0 +1,25 @@
+package cmdline
+
+import (
+	"github.com/BurntSushi/toml"
+)
+
+// parseTOML parses a TOML document, which maps to JSON like the equivalent
+// document of the rbd= JSON format would, eg.
+//
+//	[root]
+//	path = "/"
+//	[root.image]
+//	mons = ["192.168.0.1", "192.168.0.2"]
+//	pool = "rbd"
+//	image = "test-image1"
+//
+// The TOML v0.4.0 syntax is supported, so tables are written as [root.image]
+// or inline rather than with dotted keys.
+func parseTOML(doc string) (map[string]interface{}, error) {
+	var t map[string]interface{}
+	if _, err := toml.Decode(doc, &t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
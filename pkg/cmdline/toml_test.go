@@ -0,0 +1,136 @@
+package cmdline
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "Tables",
+			doc: `# The root filesystem
+[root]
+path = "/"   # mounted last
+fstype = 'ext4'
+overlay = true
+
+[root.image]
+mons = [
+  "192.168.0.1",
+  "192.168.0.2", # trailing comma
+]
+pool = "rbd"
+image = "test-image1"
+`,
+			want: map[string]interface{}{
+				"root": map[string]interface{}{
+					"path":    "/",
+					"fstype":  "ext4",
+					"overlay": true,
+					"image": map[string]interface{}{
+						"mons":  []interface{}{"192.168.0.1", "192.168.0.2"},
+						"pool":  "rbd",
+						"image": "test-image1",
+					},
+				},
+			},
+		},
+		{
+			name: "Inline tables",
+			doc:  `var = {path = "/var", image = {pool = "rbd", image = "var", opts = {queue_depth = 128, ro = false}}}`,
+			want: map[string]interface{}{
+				"var": map[string]interface{}{
+					"path": "/var",
+					"image": map[string]interface{}{
+						"pool":  "rbd",
+						"image": "var",
+						"opts":  map[string]interface{}{"queue_depth": int64(128), "ro": false},
+					},
+				},
+			},
+		},
+		{
+			name: "Arrays of tables",
+			doc:  "[[a.b]]\nc = 1\n[[a.b]]\nc = 2_000\n[a.b.d]\ne = 1.5",
+			want: map[string]interface{}{
+				"a": map[string]interface{}{
+					"b": []map[string]interface{}{
+						{"c": int64(1)},
+						{"c": int64(2000), "d": map[string]interface{}{"e": 1.5}},
+					},
+				},
+			},
+		},
+		{
+			name: "Strings",
+			doc:  `"quoted key" = "tab\there \u00e9 \"q\""` + "\n" + `lit = 'C:\path'`,
+			want: map[string]interface{}{"quoted key": "tab\there \u00e9 \"q\"", "lit": `C:\path`},
+		},
+		{
+			name: "Multi-line strings",
+			doc:  "a = \"\"\"\none \\\n  two\"\"\"\nb = '''\nC:\\path\n'''",
+			want: map[string]interface{}{"a": "one two", "b": "C:\\path\n"},
+		},
+		{
+			name: "Date",
+			doc:  "created = 1979-05-27T07:32:00Z",
+			want: map[string]interface{}{"created": time.Date(1979, 5, 27, 7, 32, 0, 0, time.UTC)},
+		},
+		{
+			name:    "Repeated table",
+			doc:     "[root]\npath = \"/\"\n[root]\nfstype = \"ext4\"",
+			wantErr: true,
+		},
+		{
+			name:    "Duplicate key",
+			doc:     "a = 1\na = 2",
+			wantErr: true,
+		},
+		{
+			name:    "Table over value",
+			doc:     "a = 1\n[a]",
+			wantErr: true,
+		},
+		{
+			name:    "Unterminated string",
+			doc:     `a = "b`,
+			wantErr: true,
+		},
+		{
+			name:    "Trailing garbage",
+			doc:     `a = "b" c`,
+			wantErr: true,
+		},
+		{
+			name:    "Unterminated array",
+			doc:     `a = [1, 2`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid escape",
+			doc:     `a = "\q"`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML(tt.doc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTOML() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTOML() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package cmdline
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bensallen/rbd/pkg/krbd"
+)
+
+// Validate checks that every mount has the attributes required to map and mount
+// it, that at most one of part, uuid, and label is set, that mount paths are
+// absolute, that the root mount is at /, that no two mounts share the same
+// path, and that the after and requires of mounts reference existing mounts
+// without forming a cycle. Diagnostics returned have an Offset of -1, see
+// Analyze to have them reference the cmdline.
+func Validate(mounts map[string]*Mount) Diagnostics {
+	return validate(mounts, nil, true)
+}
+
+// validate does the work of Validate, path is optional unless requirePath is
+// set.
+func validate(mounts map[string]*Mount, offsets map[string]int, requirePath bool) Diagnostics {
+	var diags Diagnostics
+
+	// Mounts that are lower layers of an overlay of another mount
+	layers := map[string]string{}
+	for _, name := range sortedNames(mounts) {
+		if o := mounts[name].Overlay; o.Enabled {
+			for _, l := range o.Lower {
+				if _, ok := layers[l]; !ok {
+					layers[l] = name
+				}
+			}
+		}
+	}
+
+	// Sort names so that diagnostics, in particular which of two mounts is
+	// reported as the duplicate, are stable.
+	paths := map[string]string{}
+	for _, name := range sortedNames(mounts) {
+		m := mounts[name]
+		offset, ok := offsets[name]
+		if !ok {
+			offset = -1
+		}
+		add := func(attr string, value string, err error) {
+			diags = append(diags, &Diagnostic{Offset: offset, Key: prefix + "." + name + "." + attr, Value: redact(attr, value), Err: err})
+		}
+
+		if m.Image == nil {
+			add("image", "", ErrMissingField)
+		} else {
+			if len(m.Image.Monitors) == 0 {
+				add("image.mons", "", ErrMissingField)
+			}
+			if m.Image.Pool == "" {
+				add("image.pool", "", ErrMissingField)
+			}
+			if m.Image.Image == "" {
+				add("image.image", "", ErrMissingField)
+			}
+		}
+		if n, err := strconv.Atoi(m.Part); err == nil && n < 1 {
+			add("part", m.Part, fmt.Errorf("%w: partition numbers start at 1", ErrInvalidValue))
+		}
+		for _, list := range []struct {
+			attr  string
+			names []string
+		}{{"after", m.After}, {"requires", m.Requires}} {
+			for _, dep := range list.names {
+				if _, ok := mounts[dep]; !ok {
+					add(list.attr, strings.Join(list.names, ","), fmt.Errorf("%w %q", ErrUnknownMount, dep))
+				} else if dep == name {
+					add(list.attr, strings.Join(list.names, ","), fmt.Errorf("%w: depends on itself", ErrDependencyCycle))
+				}
+			}
+		}
+		if m.Retries != nil && *m.Retries < 0 {
+			add("retries", strconv.Itoa(*m.Retries), fmt.Errorf("%w: must not be negative", ErrInvalidValue))
+		}
+		if m.Backoff < 0 {
+			add("backoff", time.Duration(m.Backoff).String(), fmt.Errorf("%w: must not be negative", ErrInvalidValue))
+		}
+		if m.Timeout < 0 {
+			add("timeout", time.Duration(m.Timeout).String(), fmt.Errorf("%w: must not be negative", ErrInvalidValue))
+		}
+		switch {
+		case m.UUID != "" && (m.Part != "" || m.Label != ""):
+			add("uuid", m.UUID, fmt.Errorf("%w: only one of part, uuid, and label may be set", ErrInvalidValue))
+		case m.Label != "" && m.Part != "":
+			add("label", m.Label, fmt.Errorf("%w: only one of part, uuid, and label may be set", ErrInvalidValue))
+		}
+
+		if m.Overlay.Enabled {
+			validateOverlay(name, m, mounts, add)
+		}
+		if m.Clone != "" {
+			switch {
+			case m.Image == nil:
+			case m.Image.Snapshot == "":
+				add("clone", m.Clone, fmt.Errorf("%w: clone requires a snapshot of the image, eg. rbd.%s.image.snap=golden", ErrInvalidValue, name))
+			case m.Overlay.Image != nil && m.Overlay.Image.Image != m.Clone:
+				add("clone", m.Clone, fmt.Errorf("%w: only one of clone and overlay image may be set", ErrInvalidValue))
+			}
+		}
+		for _, img := range []struct {
+			attr  string
+			image *krbd.Image
+		}{{"image.image", m.Image}, {"overlay.image.image", m.Overlay.Image}} {
+			if img.image == nil {
+				continue
+			}
+			if img.image == m.Overlay.Image && img.image.Image == m.Clone {
+				img.attr = "clone"
+			}
+			if err := checkTemplate(img.image.Image); err != nil {
+				add(img.attr, img.image.Image, fmt.Errorf("%w: %v", ErrInvalidValue, err))
+			}
+		}
+
+		switch {
+		case layers[name] != "" && m.Path != "":
+			add("path", m.Path, fmt.Errorf("%w: lower layer of the overlay of %s can't have a path", ErrInvalidValue, layers[name]))
+		case layers[name] != "":
+		case m.Path == "" && requirePath:
+			add("path", "", ErrMissingField)
+		case m.Path == "":
+		case !path.IsAbs(m.Path):
+			add("path", m.Path, fmt.Errorf("%w: path must be absolute", ErrInvalidValue))
+		case name == "root" && m.Path != "/":
+			add("path", m.Path, fmt.Errorf("%w: root path must be /", ErrInvalidValue))
+		default:
+			p := path.Clean(m.Path)
+			if other, ok := paths[p]; ok {
+				add("path", m.Path, fmt.Errorf("%w: also used by %s", ErrDuplicatePath, other))
+			} else {
+				paths[p] = name
+			}
+		}
+	}
+	return append(diags, validateCycles(mounts, offsets)...)
+}
+
+// tmpfsSize matches the size of a tmpfs, in bytes with an optional suffix, or
+// in percent of the memory.
+var tmpfsSize = regexp.MustCompile(`^[0-9]+[kKmMgG%]?$`)
+
+// validateOverlay checks the overlay of the mount name, that at most one upper
+// layer is configured, and that its lower layers are mounts without a path.
+func validateOverlay(name string, m *Mount, mounts map[string]*Mount, add func(attr string, value string, err error)) {
+	o := m.Overlay
+	switch {
+	case o.Image != nil && o.Label != "":
+		add("overlay.label", o.Label, fmt.Errorf("%w: only one of overlay image and label may be set", ErrInvalidValue))
+	case o.Size != "" && (o.Image != nil || o.Label != ""):
+		add("overlay.size", o.Size, fmt.Errorf("%w: size is of a tmpfs upper layer, not of image or label", ErrInvalidValue))
+	case o.Size != "" && !tmpfsSize.MatchString(o.Size):
+		add("overlay.size", o.Size, fmt.Errorf("%w: expected a size like 2G or 50%%", ErrInvalidValue))
+	}
+	if o.Image != nil {
+		if len(o.Image.Monitors) == 0 {
+			add("overlay.image.mons", "", ErrMissingField)
+		}
+		if o.Image.Pool == "" {
+			add("overlay.image.pool", "", ErrMissingField)
+		}
+		if o.Image.Image == "" {
+			add("overlay.image.image", "", ErrMissingField)
+		}
+	}
+	for _, l := range o.Lower {
+		lower, ok := mounts[l]
+		switch {
+		case !ok:
+			add("overlay.lower", strings.Join(o.Lower, ","), fmt.Errorf("%w %q", ErrUnknownMount, l))
+		case l == name:
+			add("overlay.lower", strings.Join(o.Lower, ","), fmt.Errorf("%w: %s is the mount itself", ErrInvalidValue, l))
+		case lower.Overlay.Enabled:
+			add("overlay.lower", strings.Join(o.Lower, ","), fmt.Errorf("%w: %s has an overlay itself", ErrInvalidValue, l))
+		}
+	}
+}
+
+// validateCycles returns a Diagnostic for every mount that, through the
+// dependencies of other mounts, depends on itself.
+func validateCycles(mounts map[string]*Mount, offsets map[string]int) Diagnostics {
+	deps := map[string][]dependency{}
+	for name := range mounts {
+		// Unknown mounts and self references are reported by validate.
+		d, _ := dependencies(name, mounts)
+		deps[name] = d
+	}
+
+	var diags Diagnostics
+	for _, name := range sortedNames(mounts) {
+		seen := map[string]bool{}
+		var reaches func(from string) bool
+		reaches = func(from string) bool {
+			for _, d := range deps[from] {
+				if d.name == name {
+					return true
+				}
+				if !seen[d.name] {
+					seen[d.name] = true
+					if reaches(d.name) {
+						return true
+					}
+				}
+			}
+			return false
+		}
+		if !reaches(name) {
+			continue
+		}
+		offset, ok := offsets[name]
+		if !ok {
+			offset = -1
+		}
+		diags = append(diags, &Diagnostic{Offset: offset, Key: prefix + "." + name, Err: ErrDependencyCycle})
+	}
+	return diags
+}
+
+// sortedNames returns the names of the mounts in sorted order.
+func sortedNames(mounts map[string]*Mount) []string {
+	names := make([]string, 0, len(mounts))
+	for name := range mounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,7 +1,9 @@
 package krbd
 
 import (
+	"encoding"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -20,13 +22,27 @@ type Device struct {
 	Namespace string `krbd:"pool_ns,optional"`
 	Image     string `krbd:"name"`
 	Snapshot  string `krbd:"current_snap,optional"`
+
+	// Attributes not available on all kernels, or only readable by root, are
+	// left as zero values when missing.
+	Size        uint64   `krbd:"size,optional"` // Bytes
+	Features    Features `krbd:"features,optional"`
+	Major       int      `krbd:"major,optional"`
+	Minor       int      `krbd:"minor,optional"`
+	ClientID    string   `krbd:"client_id,optional"` // eg. client4125
+	ClientAddr  string   `krbd:"client_addr,optional"`
+	ClusterFsid string   `krbd:"cluster_fsid,optional"`
+	ImageID     string   `krbd:"image_id,optional"`
+	SnapID      uint64   `krbd:"snap_id,optional"` // NoSnapID when not mapping a snapshot
+	PoolID      uint64   `krbd:"pool_id,optional"`
+	Parents     Parents  `krbd:"parent,optional"`
+	ConfigInfo  string   `krbd:"config_info,optional"` // The string written to rbd/add, including any secret
 }
 
 // Devices iterates over /sys/bus/rbd/device/ to find all mapped RBD devices populating
 // attributes.
 func Devices() ([]Device, error) {
-	path := sysBusPath + "/devices"
-	return devices(path)
+	return DefaultClient.Devices()
 }
 
 func devices(path string) ([]Device, error) {
@@ -78,65 +94,101 @@ func (d *Device) readDeviceAttrs(path string) error {
 	for i := 0; i < t.NumField(); i++ {
 		tag := getDeviceTag(t.Field(i))
 		if tag.name != "" {
-			r, err := os.Open(path + "/" + tag.name)
+			value, err := ioutil.ReadFile(path + "/" + tag.name)
 			if err != nil {
-				if tag.optional && errors.Is(err, os.ErrNotExist) {
+				if tag.optional && (errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission)) {
 					continue
 				}
 				return err
 			}
-			defer r.Close()
-			value, err := ioutil.ReadAll(r)
-			if err != nil {
-				return err
-			}
-			if len(value) != 0 {
-				v.Field(i).SetString(strings.TrimSpace(string(value)))
+			if err := setDeviceAttr(v.Field(i), strings.TrimSpace(string(value))); err != nil {
+				return fmt.Errorf("%s/%s: %v", path, tag.name, err)
 			}
 		}
 	}
 	return nil
 }
 
+// setDeviceAttr parses value into f based on its type. Types implementing
+// encoding.TextUnmarshaler, eg. Features, parse their own format.
+func setDeviceAttr(f reflect.Value, value string) error {
+	if value == "" {
+		return nil
+	}
+	if u, ok := f.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	default:
+		return fmt.Errorf("unsupported type %s", f.Kind())
+	}
+	return nil
+}
+
 // Find looks for an existing mapped device based on the set attributes
 // of (d *Device), doesn't match on ID. If a match is found from sysfs
 // (d *Device) remaining attributes are updated from sysfs.
 func (d *Device) Find() error {
-	devices, err := Devices()
-	if err != nil {
-		return err
-	}
-	return d.find(devices)
+	return DefaultClient.Find(d)
 }
 
 func (d *Device) find(devices []Device) error {
-	empty := Device{}
-	if *d == empty {
+	if d.Pool == "" && d.Namespace == "" && d.Image == "" && d.Snapshot == "" {
 		return errors.New("Device has no attributes set")
 	}
 	for _, device := range devices {
-		if d.Image != "" && device.Image != d.Image {
-			continue
-		}
-		if d.Namespace != "" && device.Namespace != d.Namespace {
-			continue
-		}
-		if d.Snapshot != "" && device.Snapshot != d.Snapshot {
-			continue
+		if d.matches(device) {
+			*d = device
+			return nil
 		}
-		if d.Pool != "" && device.Pool != d.Pool {
-			continue
-		}
-		*d = device
-		return nil
 	}
-	return errors.New("No match found")
+	return ErrNoMatch
+}
+
+// matches reports whether device has the same value for every attribute, other
+// than ID, that is set in (d *Device).
+func (d *Device) matches(device Device) bool {
+	if d.Image != "" && device.Image != d.Image {
+		return false
+	}
+	if d.Namespace != "" && device.Namespace != d.Namespace {
+		return false
+	}
+	if d.Snapshot != "" && device.Snapshot != d.Snapshot {
+		return false
+	}
+	if d.Pool != "" && device.Pool != d.Pool {
+		return false
+	}
+	return true
+}
+
+// mapsImage reports whether device maps the image or snapshot of (d *Device),
+// with the pool, namespace, image, and snapshot all equal. An empty Namespace
+// is the default namespace rather than any namespace, unlike for matches.
+func (d *Device) mapsImage(device Device) bool {
+	return device.Pool == d.Pool && device.Namespace == d.Namespace && device.Image == d.Image && device.Snapshot == d.Snapshot
 }
 
-// DevPath returns the string form of the Device expected device path, eg. /dev/rbd0
+// DevPath returns the string form of the Device expected device path, eg. /dev/rbd0,
+// under the DevRoot of DefaultClient, see Client.DevPath.
 // Does not validate that the device actually exists.
 func (d *Device) DevPath() string {
-	return "/dev/rbd" + strconv.FormatInt(d.ID, 10)
+	return DefaultClient.DevPath(*d)
 }
 
 // tag parsing
@@ -5,6 +5,29 @@ import (
 	"testing"
 )
 
+// testDev0 and testDev1 are the devices in test/sys.
+var (
+	testDev0 = Device{
+		ID: 0, Pool: "rbd", Namespace: "ns1", Image: "image1", Snapshot: "snapshot1",
+		Size:        10737418240,
+		Features:    FeatureLayering | FeatureExclusiveLock | FeatureObjectMap | FeatureFastDiff | FeatureDeepFlatten,
+		Major:       252,
+		Minor:       0,
+		ClientID:    "client4125",
+		ClientAddr:  "192.168.0.10:0/2731446352",
+		ClusterFsid: "2b7d5a5c-0a4b-4c5e-9f2e-6b1e3c7f0d11",
+		ImageID:     "10226b8b4567",
+		SnapID:      4,
+		PoolID:      2,
+		Parents: Parents{
+			{PoolID: 2, Pool: "rbd", Namespace: "ns1", ImageID: "1018e2ae8944a", Image: "golden", SnapID: 7, Snapshot: "v2", Overlap: 10737418240},
+			{PoolID: 3, Pool: "images", ImageID: "10126b8b4567", Image: "base", SnapID: 2, Snapshot: "v1", Overlap: 5368709120},
+		},
+		ConfigInfo: "192.168.0.1:6789 name=admin,key=client.admin,_pool_ns=ns1 rbd image1 snapshot1",
+	}
+	testDev1 = Device{ID: 1, Pool: "rbd", Image: "image2", Snapshot: "-", Size: 1073741824, Features: FeatureLayering, Major: 252, Minor: 16, SnapID: NoSnapID}
+)
+
 func Test_devices(t *testing.T) {
 	type args struct {
 		path string
@@ -16,9 +39,9 @@ func Test_devices(t *testing.T) {
 		wantErr bool
 	}{
 		{
-			name: "Mock Dev 0",
+			name: "Mock Devs",
 			args: args{path: "test/sys/bus/rbd/devices"},
-			want: []Device{{ID: 0, Pool: "rbd", Namespace: "ns1", Image: "image1", Snapshot: "snapshot1"}},
+			want: []Device{testDev0, testDev1},
 		},
 	}
 	for _, tt := range tests {
@@ -56,9 +79,21 @@ func TestDevice_readDeviceAttrs(t *testing.T) {
 		{
 			name:   "Mock Dev 0",
 			fields: fields{ID: 0},
-			want:   Device{ID: 0, Pool: "rbd", Namespace: "ns1", Image: "image1", Snapshot: "snapshot1"},
+			want:   testDev0,
 			args:   args{path: "test/sys/bus/rbd/devices/0"},
 		},
+		{
+			name:   "Mock Dev 1 without optional attributes",
+			fields: fields{ID: 1},
+			want:   testDev1,
+			args:   args{path: "test/sys/bus/rbd/devices/1"},
+		},
+		{
+			name:    "Missing required attributes",
+			fields:  fields{ID: 2},
+			args:    args{path: "test/sys/bus/rbd/devices/2"},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,84 @@
+package krbd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Features is the bitmask of RBD image features, as shown in
+// /sys/bus/rbd/devices/<id>/features.
+type Features uint64
+
+// RBD image feature flags.
+// Reference: https://github.com/ceph/ceph/blob/master/src/include/rbd/features.h
+const (
+	FeatureLayering Features = 1 << iota
+	FeatureStriping
+	FeatureExclusiveLock
+	FeatureObjectMap
+	FeatureFastDiff
+	FeatureDeepFlatten
+	FeatureJournaling
+	FeatureDataPool
+	FeatureOperations
+	FeatureMigrating
+	FeatureNonPrimary
+)
+
+var featureNames = []struct {
+	f    Features
+	name string
+}{
+	{FeatureLayering, "layering"},
+	{FeatureStriping, "striping"},
+	{FeatureExclusiveLock, "exclusive-lock"},
+	{FeatureObjectMap, "object-map"},
+	{FeatureFastDiff, "fast-diff"},
+	{FeatureDeepFlatten, "deep-flatten"},
+	{FeatureJournaling, "journaling"},
+	{FeatureDataPool, "data-pool"},
+	{FeatureOperations, "operations"},
+	{FeatureMigrating, "migrating"},
+	{FeatureNonPrimary, "non-primary"},
+}
+
+// Has reports whether all of the features in x are set.
+func (f Features) Has(x Features) bool {
+	return f&x == x
+}
+
+// Names returns the names of the set features, eg. "layering", with any
+// unknown bits as a hex value.
+func (f Features) Names() []string {
+	names := []string{}
+	for _, n := range featureNames {
+		if f.Has(n.f) {
+			names = append(names, n.name)
+			f &^= n.f
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint64(f)))
+	}
+	return names
+}
+
+// String returns the comma seperated names of the set features.
+func (f Features) String() string {
+	return strings.Join(f.Names(), ",")
+}
+
+// UnmarshalText parses the hex bitmask format used by sysfs, eg. 0x3d.
+func (f *Features) UnmarshalText(text []byte) error {
+	s := string(text)
+	if !strings.HasPrefix(s, "0x") {
+		return fmt.Errorf("invalid features %q, expected a hex value", s)
+	}
+	n, err := strconv.ParseUint(s[2:], 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid features %q: %v", s, err)
+	}
+	*f = Features(n)
+	return nil
+}
@@ -0,0 +1,32 @@
+package krbd
+
+import (
+	"testing"
+)
+
+func TestFeatures_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{name: "Default features", text: "0x3d", want: "layering,exclusive-lock,object-map,fast-diff,deep-flatten"},
+		{name: "None", text: "0x0", want: ""},
+		{name: "Unknown bit", text: "0x1001", want: "layering,0x1000"},
+		{name: "Decimal", text: "61", wantErr: true},
+		{name: "Invalid hex", text: "0xzz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f Features
+			err := f.UnmarshalText([]byte(tt.text))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Features.UnmarshalText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && f.String() != tt.want {
+				t.Errorf("Features.String() = %q, want %q", f.String(), tt.want)
+			}
+		})
+	}
+}
@@ -13,6 +13,11 @@ type Image struct {
 	Image    string
 	Snapshot string   `json:"snap"`
 	Options  *Options `json:"opts"`
+
+	// Keyring and Keyfile are read to set Options.Secret at map time when
+	// it isn't already set. Keyring takes precedence over Keyfile.
+	Keyring string `json:"keyring"`
+	Keyfile string `json:"keyfile"`
 }
 
 // String mashalls the Image attributes into the string format expected by the krbd add interface, eg:
@@ -23,3 +28,34 @@ func (i Image) String() string {
 	}
 	return fmt.Sprintf("%s %s %s %s %s", strings.Join(i.Monitors, ","), i.Options, i.Pool, i.Image, i.Snapshot)
 }
+
+// withSecret returns a copy of the Image with Options.Secret resolved from Keyring
+// or Keyfile, if the secret isn't set already. The receiver is left untouched so
+// that the secret only lives for the duration of the mapping.
+func (i Image) withSecret() (Image, error) {
+	if i.Keyring == "" && i.Keyfile == "" {
+		return i, nil
+	}
+	opts := Options{}
+	if i.Options != nil {
+		opts = *i.Options
+	}
+	if opts.Secret != "" || opts.Key != "" {
+		return i, nil
+	}
+	if opts.Name == "" {
+		opts.Name = DefaultUser
+	}
+
+	var err error
+	if i.Keyring != "" {
+		opts.Secret, err = ReadKeyring(i.Keyring, opts.Name)
+	} else {
+		opts.Secret, err = ReadSecretFile(i.Keyfile)
+	}
+	if err != nil {
+		return i, err
+	}
+	i.Options = &opts
+	return i, nil
+}
@@ -0,0 +1,77 @@
+package krbd
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// KernelKeyring is a Linux kernel keyring that keys can be added to, see add_key(2).
+type KernelKeyring interface {
+	AddKey(keyType string, description string, payload []byte) (int, error)
+}
+
+// KeyringID is one of the special kernel keyring IDs, eg. KEY_SPEC_SESSION_KEYRING.
+type KeyringID int
+
+const (
+	// UserKeyring is the keyring of the real user ID of the process.
+	UserKeyring KeyringID = -4
+	// SessionKeyring is the keyring of the session of the process.
+	SessionKeyring KeyringID = -3
+)
+
+// ParseKeyringID returns the KeyringID from its name, session or user.
+func ParseKeyringID(name string) (KeyringID, error) {
+	switch name {
+	case "session":
+		return SessionKeyring, nil
+	case "user":
+		return UserKeyring, nil
+	}
+	return 0, fmt.Errorf("unknown kernel keyring %q, expected session or user", name)
+}
+
+// AddSecret adds the base64 encoded cephx secret of user, without the "client."
+// prefix, to the kernel keyring kr as a "ceph" type key. The returned description
+// can be passed via Options.Key instead of passing the secret via Options.Secret.
+func AddSecret(kr KernelKeyring, user string, secret string) (string, error) {
+	payload, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("could not decode secret: %v", err)
+	}
+	desc := "client." + user
+	if _, err := kr.AddKey("ceph", desc, payload); err != nil {
+		return "", fmt.Errorf("could not add key %s to kernel keyring: %v", desc, err)
+	}
+	return desc, nil
+}
+
+// LoadKey adds the secret of the Image, from Options.Secret, Keyring, or Keyfile,
+// to the kernel keyring kr. Options.Key is then set to reference the key and the
+// secret is cleared, so that it is neither retained nor written to sysfs by Map.
+func (i *Image) LoadKey(kr KernelKeyring) error {
+	img, err := i.withSecret()
+	if err != nil {
+		return err
+	}
+	if img.Options == nil || img.Options.Secret == "" {
+		return errors.New("No secret defined")
+	}
+
+	opts := *img.Options
+	if opts.Name == "" {
+		opts.Name = DefaultUser
+	}
+	desc, err := AddSecret(kr, opts.Name, opts.Secret)
+	if err != nil {
+		return err
+	}
+	opts.Secret = ""
+	opts.Key = desc
+
+	i.Options = &opts
+	i.Keyring = ""
+	i.Keyfile = ""
+	return nil
+}
@@ -0,0 +1,8 @@
+package krbd
+
+import "golang.org/x/sys/unix"
+
+// AddKey adds a key to the keyring via add_key(2).
+func (k KeyringID) AddKey(keyType string, description string, payload []byte) (int, error) {
+	return unix.AddKey(keyType, description, payload, int(k))
+}
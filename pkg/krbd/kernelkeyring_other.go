@@ -0,0 +1,10 @@
+// +build !linux
+
+package krbd
+
+import "errors"
+
+// AddKey is only supported on Linux.
+func (k KeyringID) AddKey(keyType string, description string, payload []byte) (int, error) {
+	return 0, errors.New("kernel keyrings are not supported on this platform")
+}
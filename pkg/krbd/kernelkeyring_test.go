@@ -0,0 +1,107 @@
+package krbd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+type fakeKey struct {
+	keyType string
+	desc    string
+	payload []byte
+}
+
+type fakeKeyring struct {
+	keys []fakeKey
+	err  error
+}
+
+func (f *fakeKeyring) AddKey(keyType string, description string, payload []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.keys = append(f.keys, fakeKey{keyType: keyType, desc: description, payload: payload})
+	return len(f.keys), nil
+}
+
+func TestImage_LoadKey(t *testing.T) {
+	const secret = "AQCvCbtToC6MDhAATtuT70Sl+DymPCfDSsyV4w=="
+	payload, _ := base64.StdEncoding.DecodeString(secret)
+
+	tests := []struct {
+		name     string
+		image    Image
+		keyring  *fakeKeyring
+		wantKeys []fakeKey
+		wantW    string
+		wantErr  bool
+	}{
+		{
+			name:     "Secret",
+			image:    Image{Options: &Options{Name: "rbd", Secret: secret}},
+			keyring:  &fakeKeyring{},
+			wantKeys: []fakeKey{{keyType: "ceph", desc: "client.rbd", payload: payload}},
+			wantW:    "10.0.0.1 name=rbd,key=client.rbd rbd test-image -",
+		},
+		{
+			name:     "Default user",
+			image:    Image{Options: &Options{Secret: secret}},
+			keyring:  &fakeKeyring{},
+			wantKeys: []fakeKey{{keyType: "ceph", desc: "client.admin", payload: payload}},
+			wantW:    "10.0.0.1 name=admin,key=client.admin rbd test-image -",
+		},
+		{
+			name:    "No secret",
+			image:   Image{},
+			keyring: &fakeKeyring{},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid secret",
+			image:   Image{Options: &Options{Secret: "not base64!"}},
+			keyring: &fakeKeyring{},
+			wantErr: true,
+		},
+		{
+			name:    "add_key failure",
+			image:   Image{Options: &Options{Secret: secret}},
+			keyring: &fakeKeyring{err: errors.New("EPERM")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := tt.image
+			i.Monitors = []string{"10.0.0.1"}
+			i.Pool = "rbd"
+			i.Image = "test-image"
+
+			if err := i.LoadKey(tt.keyring); (err != nil) != tt.wantErr {
+				t.Errorf("Image.LoadKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(tt.keyring.keys) != len(tt.wantKeys) {
+				t.Fatalf("Image.LoadKey() added %v, want %v", tt.keyring.keys, tt.wantKeys)
+			}
+			for n, k := range tt.keyring.keys {
+				w := tt.wantKeys[n]
+				if k.keyType != w.keyType || k.desc != w.desc || !bytes.Equal(k.payload, w.payload) {
+					t.Errorf("Image.LoadKey() added %v, want %v", k, w)
+				}
+			}
+
+			w := &bytes.Buffer{}
+			if err := i.Map(w); err != nil {
+				t.Fatalf("Image.Map() error = %v", err)
+			}
+			if gotW := w.String(); gotW != tt.wantW {
+				t.Errorf("Image.Map() = %v, want %v", gotW, tt.wantW)
+			}
+		})
+	}
+}
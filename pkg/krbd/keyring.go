@@ -0,0 +1,106 @@
+package krbd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DefaultUser is the cephx user used to look up a key in a keyring when
+// Options.Name isn't set, matching the upstream rbd CLI.
+const DefaultUser = "admin"
+
+// ParseKeyring parses a Ceph keyring in INI format and returns the key of each
+// entity, eg:
+//
+//	[client.admin]
+//		key = AQCvCbtToC6MDhAATtuT70Sl+DymPCfDSsyV4w==
+//		caps mon = "allow *"
+//
+// Other attributes like caps are ignored.
+func ParseKeyring(r io.Reader) (map[string]string, error) {
+	keys := map[string]string{}
+	entity := ""
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[':
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("keyring line %d: unterminated section", n)
+			}
+			entity = strings.TrimSpace(line[1 : len(line)-1])
+		default:
+			eq := strings.IndexRune(line, '=')
+			if eq < 0 {
+				return nil, fmt.Errorf("keyring line %d: expected key = value", n)
+			}
+			if entity == "" {
+				return nil, fmt.Errorf("keyring line %d: attribute outside of a section", n)
+			}
+			if strings.TrimSpace(line[:eq]) == "key" {
+				keys[entity] = unquote(strings.TrimSpace(line[eq+1:]))
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ReadKeyring returns the key for the cephx user, without the "client." prefix,
+// from the keyring file at path.
+func ReadKeyring(path string, user string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	keys, err := ParseKeyring(f)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	key, ok := keys["client."+user]
+	if !ok || key == "" {
+		return "", fmt.Errorf("%s: no key found for client.%s", path, user)
+	}
+	return key, nil
+}
+
+// ReadSecretFile returns the bare secret contained in the file at path, as
+// used by the upstream secretfile option.
+func ReadSecretFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// Only the first line is used, a trailing newline is common.
+	s := bufio.NewScanner(f)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("%s: empty secret file", path)
+	}
+	secret := strings.TrimSpace(s.Text())
+	if secret == "" {
+		return "", fmt.Errorf("%s: empty secret file", path)
+	}
+	return secret, nil
+}
+
+// unquote removes a single pair of matching surrounding quotes.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,153 @@
+package krbd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyring string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "Typical keyring",
+			keyring: `# comment
+[client.admin]
+	key = AQCvCbtToC6MDhAATtuT70Sl+DymPCfDSsyV4w==
+	caps mds = "allow *"
+	caps mon = "allow *"
+
+; another comment
+[client.rbd]
+	key = "AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q=="
+`,
+			want: map[string]string{
+				"client.admin": "AQCvCbtToC6MDhAATtuT70Sl+DymPCfDSsyV4w==",
+				"client.rbd":   "AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q==",
+			},
+		},
+		{
+			name:    "Empty",
+			keyring: "",
+			want:    map[string]string{},
+		},
+		{
+			name:    "Unterminated section",
+			keyring: "[client.admin\n\tkey = x\n",
+			wantErr: true,
+		},
+		{
+			name:    "Attribute outside of section",
+			keyring: "key = x\n",
+			wantErr: true,
+		},
+		{
+			name:    "Missing equals",
+			keyring: "[client.admin]\n\tkey\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKeyring(strings.NewReader(tt.keyring))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseKeyring() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseKeyring() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImage_Map_secretFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "krbd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyring := filepath.Join(dir, "ceph.client.admin.keyring")
+	if err := ioutil.WriteFile(keyring, []byte("[client.admin]\n\tkey = AQCvCbtToC6MDhAATtuT70Sl+DymPCfDSsyV4w==\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyfile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(keyfile, []byte("AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q==\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := ioutil.WriteFile(empty, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		image   Image
+		wantW   string
+		wantErr bool
+	}{
+		{
+			name:  "Keyring with default user",
+			image: Image{Keyring: keyring},
+			wantW: "10.0.0.1 name=admin,secret=AQCvCbtToC6MDhAATtuT70Sl+DymPCfDSsyV4w== rbd test-image -",
+		},
+		{
+			name:    "Keyring without user",
+			image:   Image{Keyring: keyring, Options: &Options{Name: "rbd"}},
+			wantErr: true,
+		},
+		{
+			name:  "Keyfile",
+			image: Image{Keyfile: keyfile, Options: &Options{Name: "rbd", ReadOnly: true}},
+			wantW: "10.0.0.1 ro,name=rbd,secret=AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q== rbd test-image -",
+		},
+		{
+			name:    "Empty keyfile",
+			image:   Image{Keyfile: empty},
+			wantErr: true,
+		},
+		{
+			name:  "Secret takes precedence",
+			image: Image{Keyfile: empty, Options: &Options{Name: "admin", Secret: "AQCvCbtToC6MDhAATtuT70Sl+DymPCfDSsyV4w=="}},
+			wantW: "10.0.0.1 name=admin,secret=AQCvCbtToC6MDhAATtuT70Sl+DymPCfDSsyV4w== rbd test-image -",
+		},
+		{
+			name:    "Missing keyring",
+			image:   Image{Keyring: filepath.Join(dir, "missing")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := tt.image
+			i.Monitors = []string{"10.0.0.1"}
+			i.Pool = "rbd"
+			i.Image = "test-image"
+			var opts Options
+			if i.Options != nil {
+				opts = *i.Options
+			}
+
+			w := &strings.Builder{}
+			if err := i.Map(w); (err != nil) != tt.wantErr {
+				t.Errorf("Image.Map() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if gotW := w.String(); gotW != tt.wantW {
+				t.Errorf("Image.Map() = %v, want %v", gotW, tt.wantW)
+			}
+			// The resolved secret must not be retained by the Image.
+			if i.Options != nil && !reflect.DeepEqual(*i.Options, opts) {
+				t.Errorf("Image.Map() modified Options to %v", *i.Options)
+			}
+		})
+	}
+}
@@ -1,38 +1,176 @@
 package krbd
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // Reference: https://www.kernel.org/doc/Documentation/ABI/testing/sysfs-bus-rbd
 
-const sysBusPath = "/sys/bus/rbd"
+const (
+	// DefaultSysRoot is where sysfs is normally mounted.
+	DefaultSysRoot = "/sys"
+	// DefaultDevRoot is where devtmpfs is normally mounted.
+	DefaultDevRoot = "/dev"
+)
 
-// RBDBusAddWriter returns an io.Writer with the appropriate sysfs rbd/add opened.
-func RBDBusAddWriter() (io.WriteCloser, error) {
-	rbdBusAddSingleMajor := sysBusPath + "/add_single_major"
-	rbdBusAdd := sysBusPath + "/add"
+// Client interacts with krbd via sysfs mounted at SysRoot and device nodes
+// under DevRoot. These differ from /sys and /dev when working with a chroot,
+// or from inside a container where the host's /sys is mounted elsewhere.
+type Client struct {
+	SysRoot string
+	DevRoot string
+}
+
+// DefaultClient is used by the package level functions and methods that don't
+// take a Client.
+var DefaultClient = NewClient(DefaultSysRoot, DefaultDevRoot)
+
+// NewClient returns a Client using sysfs mounted at sysRoot and device nodes
+// under devRoot.
+func NewClient(sysRoot string, devRoot string) *Client {
+	return &Client{SysRoot: sysRoot, DevRoot: devRoot}
+}
 
-	if _, err := os.Stat(rbdBusAddSingleMajor); err == nil {
-		return os.OpenFile(rbdBusAddSingleMajor, os.O_WRONLY, 0644)
-	} else if _, err := os.Stat(rbdBusAdd); err == nil {
-		return os.OpenFile(rbdBusAdd, os.O_WRONLY, 0644)
+// busPath returns the path of the rbd bus in sysfs, eg. /sys/bus/rbd.
+func (c *Client) busPath() string {
+	return filepath.Join(c.SysRoot, "bus/rbd")
+}
+
+// openBus opens the first of the rbd bus files that exists for writing.
+func (c *Client) openBus(names ...string) (io.WriteCloser, error) {
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(c.busPath(), name)
+		if _, err := os.Stat(paths[i]); err == nil {
+			return os.OpenFile(paths[i], os.O_WRONLY, 0644)
+		}
 	}
-	return nil, fmt.Errorf("could not find %s or %s", rbdBusAddSingleMajor, rbdBusAdd)
+	return nil, fmt.Errorf("could not find %s", strings.Join(paths, " or "))
 }
 
-// RBDBusRemoveWriter returns an io.Writer with the appropriate sysfs rbd/remove opened.
-func RBDBusRemoveWriter() (io.WriteCloser, error) {
-	rbdBusAddSingleMajor := sysBusPath + "/remove_single_major"
-	rbdBusAdd := sysBusPath + "/remove"
+// AddWriter returns an io.WriteCloser with the appropriate sysfs rbd/add opened.
+func (c *Client) AddWriter() (io.WriteCloser, error) {
+	return c.openBus("add_single_major", "add")
+}
 
-	if _, err := os.Stat(rbdBusAddSingleMajor); err == nil {
-		return os.OpenFile(rbdBusAddSingleMajor, os.O_WRONLY, 0644)
+// RemoveWriter returns an io.WriteCloser with the appropriate sysfs rbd/remove opened.
+func (c *Client) RemoveWriter() (io.WriteCloser, error) {
+	return c.openBus("remove_single_major", "remove")
+}
 
-	} else if _, err := os.Stat(rbdBusAdd); err == nil {
-		return os.OpenFile(rbdBusAdd, os.O_WRONLY, 0644)
+// Devices returns all mapped RBD devices, see Devices.
+func (c *Client) Devices() ([]Device, error) {
+	return devices(filepath.Join(c.busPath(), "devices"))
+}
+
+// Find looks for an existing mapped device, see Device.Find.
+func (c *Client) Find(d *Device) error {
+	devices, err := c.Devices()
+	if err != nil {
+		return err
+	}
+	return d.find(devices)
+}
+
+// FindDevices returns the mapped devices matching target, see FindDevices.
+// Device node paths and udev style symlinks in target are relative to DevRoot.
+func (c *Client) FindDevices(target string) ([]Device, error) {
+	devices, err := c.Devices()
+	if err != nil {
+		return nil, err
+	}
+	return findDevices(target, devices, c.evalSymlinks)
+}
+
+// ResolveDevice is like FindDevices but expects a single match, see ResolveDevice.
+func (c *Client) ResolveDevice(target string) (Device, error) {
+	found, err := c.FindDevices(target)
+	if err != nil {
+		return Device{}, err
+	}
+	if len(found) > 1 {
+		return Device{}, &MultipleMatchesError{Target: target, Devices: found, client: c}
 	}
-	return nil, fmt.Errorf("could not find %s or %s", rbdBusAddSingleMajor, rbdBusAdd)
+	return found[0], nil
+}
+
+// DevPath returns the path of the device node of d under DevRoot, eg. /dev/rbd0.
+// Does not validate that the device actually exists.
+func (c *Client) DevPath(d Device) string {
+	return filepath.Join(c.DevRoot, "rbd"+strconv.FormatInt(d.ID, 10))
+}
+
+// Map the RBD image via the sysfs add file, see Image.Map.
+func (c *Client) Map(i *Image) error {
+	wc, err := c.AddWriter()
+	if err != nil {
+		return err
+	}
+	defer wc.Close()
+	return i.Map(wc)
+}
+
+// MapAndWait maps the RBD image via the sysfs add file and waits for its device,
+// see Image.MapAndWait.
+func (c *Client) MapAndWait(ctx context.Context, i *Image) (Device, error) {
+	wc, err := c.AddWriter()
+	if err != nil {
+		return Device{}, err
+	}
+	defer wc.Close()
+	return c.mapAndWait(ctx, i, wc, Retry{})
+}
+
+// MapAndWaitRetry is MapAndWait, retrying the map according to r, see
+// Image.MapAndWaitRetry.
+func (c *Client) MapAndWaitRetry(ctx context.Context, i *Image, r Retry) (Device, error) {
+	wc, err := c.AddWriter()
+	if err != nil {
+		return Device{}, err
+	}
+	defer wc.Close()
+	return c.mapAndWait(ctx, i, wc, r)
+}
+
+// Unmap the RBD device of the image via the sysfs remove file, see Image.Unmap.
+func (c *Client) Unmap(i *Image) error {
+	wc, err := c.RemoveWriter()
+	if err != nil {
+		return err
+	}
+	defer wc.Close()
+	return i.Unmap(wc)
+}
+
+// evalSymlinks is filepath.EvalSymlinks for a path under /dev, evaluated under
+// DevRoot, returning the result as a path under /dev.
+func (c *Client) evalSymlinks(path string) (string, error) {
+	rel, err := filepath.Rel(DefaultDevRoot, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.EvalSymlinks(path)
+	}
+	real, err := filepath.EvalSymlinks(filepath.Join(c.DevRoot, rel))
+	if err != nil {
+		return "", err
+	}
+	if rel, err = filepath.Rel(c.DevRoot, real); err != nil {
+		return "", err
+	}
+	return filepath.Join(DefaultDevRoot, rel), nil
+}
+
+// RBDBusAddWriter returns an io.Writer with the appropriate sysfs rbd/add opened.
+func RBDBusAddWriter() (io.WriteCloser, error) {
+	return DefaultClient.AddWriter()
+}
+
+// RBDBusRemoveWriter returns an io.Writer with the appropriate sysfs rbd/remove opened.
+func RBDBusRemoveWriter() (io.WriteCloser, error) {
+	return DefaultClient.RemoveWriter()
 }
@@ -1 +1,140 @@
 package krbd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClient_AddWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "Single major",
+			files: []string{"add", "add_single_major"},
+			want:  "add_single_major",
+		},
+		{
+			name:  "Add only",
+			files: []string{"add"},
+			want:  "add",
+		},
+		{
+			name:    "Missing",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "krbd")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			bus := filepath.Join(root, "bus/rbd")
+			if err := os.MkdirAll(bus, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range tt.files {
+				if err := ioutil.WriteFile(filepath.Join(bus, f), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := NewClient(root, "/dev")
+			w, err := c.AddWriter()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Client.AddWriter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if _, err := w.Write([]byte("test")); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			if b, _ := ioutil.ReadFile(filepath.Join(bus, tt.want)); string(b) != "test" {
+				t.Errorf("Client.AddWriter() didn't open %s", tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_Devices(t *testing.T) {
+	c := NewClient("test/sys", "test/dev")
+	got, err := c.Devices()
+	if err != nil {
+		t.Fatalf("Client.Devices() error = %v", err)
+	}
+	want := []Device{testDev0, testDev1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Client.Devices() = %v, want %v", got, want)
+	}
+	if got := c.DevPath(got[0]); got != "test/dev/rbd0" {
+		t.Errorf("Client.DevPath() = %v, want test/dev/rbd0", got)
+	}
+}
+
+func TestClient_FindDevices(t *testing.T) {
+	root, err := ioutil.TempDir("", "krbd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	fakeSysfs(t, root, Device{ID: 0, Pool: "rbd", Image: "image1", Snapshot: "-"}, true)
+	fakeSysfs(t, root, Device{ID: 1, Pool: "rbd", Image: "image1", Snapshot: "snap1"}, true)
+	fakeSysfs(t, root, Device{ID: 2, Pool: "rbd", Namespace: "ns1", Image: "image1", Snapshot: "-"}, true)
+	fakeSysfs(t, root, Device{ID: 3, Pool: "other", Image: "image2", Snapshot: "-"}, true)
+	fakeSysfs(t, root, Device{ID: 4, Pool: "other", Image: "image3", Snapshot: "-"}, true)
+	fakeSysfs(t, root, Device{ID: 5, Pool: "other", Image: "image3", Snapshot: "-"}, true)
+	if err := os.MkdirAll(filepath.Join(root, "dev/rbd/rbd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// udev creates relative symlinks
+	if err := os.Symlink("../../rbd1", filepath.Join(root, "dev/rbd/rbd/link")); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClient(filepath.Join(root, "sys"), filepath.Join(root, "dev"))
+	tests := []struct {
+		target  string
+		want    int64
+		wantErr bool
+	}{
+		{target: "/dev/rbd0", want: 0},
+		{target: "rbd/image1@snap1", want: 1},
+		{target: "/dev/rbd/rbd/link", want: 1},
+		{target: "/dev/rbd6", wantErr: true},
+		{target: "rbd/image1", want: 0},
+		{target: "rbd/ns1/image1", want: 2},
+		{target: "rbd/ns2/image1", wantErr: true},
+		{target: "image1", want: 0},
+		{target: "image2", wantErr: true},
+		{target: "other/image2", want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			got, err := c.ResolveDevice(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Client.ResolveDevice() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.ID != tt.want {
+				t.Errorf("Client.ResolveDevice() = %v, want ID %d", got, tt.want)
+			}
+		})
+	}
+
+	_, err = c.ResolveDevice("other/image3")
+	want := filepath.Join(root, "dev", "rbd4") + ", " + filepath.Join(root, "dev", "rbd5")
+	if !errors.Is(err, ErrMultipleMatches) || !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("Client.ResolveDevice() error = %v, want the device nodes %s", err, want)
+	}
+}
@@ -0,0 +1,38 @@
+package krbd
+
+import (
+	"io"
+	"log"
+	"regexp"
+)
+
+var secretOpt = regexp.MustCompile(`(^|[ ,])(secret=)[^, ]*`)
+
+type writeLogger struct {
+	prefix string
+	w      io.Writer
+}
+
+func (l *writeLogger) Write(p []byte) (int, error) {
+	n, err := l.w.Write(p)
+	s := RedactSecret(string(p[:n]))
+	if err != nil {
+		log.Printf("%s %s: %v", l.prefix, s, err)
+	} else {
+		log.Printf("%s %s", l.prefix, s)
+	}
+	return n, err
+}
+
+// RedactSecret replaces the value of the secret option in s, eg. a string
+// written to rbd/add or read from config_info, with <redacted>.
+func RedactSecret(s string) string {
+	return secretOpt.ReplaceAllString(s, "${1}${2}<redacted>")
+}
+
+// NewWriteLogger returns an io.Writer that writes to w and logs each write as a
+// string, rather than hex like testing/iotest.NewWriteLogger, with the value of
+// the secret option redacted.
+func NewWriteLogger(prefix string, w io.Writer) io.Writer {
+	return &writeLogger{prefix: prefix, w: w}
+}
@@ -0,0 +1,28 @@
+package krbd
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNewWriteLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	defer log.SetOutput(os.Stderr)
+
+	w := &bytes.Buffer{}
+	in := "10.0.0.1 name=admin,secret=AQCvCbtToC6MDhAATtuT70Sl+DymPCfDSsyV4w==,ro rbd test-image -"
+	if _, err := NewWriteLogger("map", w).Write([]byte(in)); err != nil {
+		t.Fatal(err)
+	}
+	if w.String() != in {
+		t.Errorf("NewWriteLogger() wrote %q, want %q", w.String(), in)
+	}
+	want := "map 10.0.0.1 name=admin,secret=<redacted>,ro rbd test-image -\n"
+	if !strings.HasSuffix(out.String(), want) {
+		t.Errorf("NewWriteLogger() logged %q, want suffix %q", out.String(), want)
+	}
+}
@@ -7,7 +7,8 @@ import (
 )
 
 // Map the RBD image via the krbd interface. An open io.Writer is required
-// typically to /sys/bus/rbd/add or /sys/bus/rbd/add_single_major
+// typically to /sys/bus/rbd/add or /sys/bus/rbd/add_single_major. If set,
+// Keyring or Keyfile are read to provide the secret.
 func (i *Image) Map(w io.Writer) error {
 	if len(i.Monitors) == 0 {
 		return errors.New("No monitors defined")
@@ -19,11 +20,19 @@ func (i *Image) Map(w io.Writer) error {
 		return errors.New("No image defined")
 	}
 
-	out := i.String()
-	n, err := w.Write([]byte(out))
+	img, err := i.withSecret()
+	if err != nil {
+		return err
+	}
 
+	out := img.String()
+	n, err := w.Write([]byte(out))
+	if err != nil {
+		// Keep the errno, eg. ETIMEDOUT, so it can be checked with Retryable
+		return err
+	}
 	if n != len(out) {
 		return fmt.Errorf("Incomplete write, wrote %d, expected to write %d", n, len(out))
 	}
-	return err
+	return nil
 }
@@ -1,8 +1,10 @@
 package krbd
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
@@ -26,6 +28,9 @@ type Options struct {
 	MountTimeout             int    `krbd:"mount_timeout"`
 	OSDKeepAlive             int    `krbd:"osdkeepalive"`
 	OSDIdleTTL               int    `krbd:"osd_idle_ttl"`
+	// MsMode selects the v2 messenger protocol, eg. prefer-crc, which the
+	// monitor addresses have to be v2 addresses for. Empty for v1.
+	MsMode string `krbd:"ms_mode"`
 
 	// RBD Block Options
 	Force       bool   `krbd:"force"` // Unmap only
@@ -40,6 +45,7 @@ type Options struct {
 	AllocSize   int    `krbd:"alloc_size"`
 	Name        string `krbd:"name"`
 	Secret      string `krbd:"secret"`
+	Key         string `krbd:"key"` // Description of a ceph key in the kernel keyring, alternative to Secret
 	Namespace   string `krbd:"_pool_ns"`
 }
 
@@ -67,3 +73,134 @@ func (o Options) String() string {
 	}
 	return strings.Join(output, ",")
 }
+
+// conflicts are pairs of options that can't both be set.
+var conflicts = [][2]string{
+	{"share", "noshare"},
+	{"crc", "nocrc"},
+	{"cephx_require_signatures", "nocephx_require_signatures"},
+	{"tcp_nodelay", "notcp_nodelay"},
+	{"cephx_sign_messages", "nocephx_sign_messages"},
+	{"rw", "ro"},
+	{"secret", "key"},
+}
+
+// ParseOptions unmarshalls comma seperated krbd options, eg. "ro,queue_depth=128",
+// into a new Options. This is the inverse of Options.String.
+func ParseOptions(s string) (*Options, error) {
+	o := &Options{}
+	if err := o.Parse(s); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Parse applies comma seperated krbd options onto (o *Options), matching each
+// option against the krbd struct tags. Options already set on o and not present
+// in s are left untouched. Unknown options, and options that conflict with each
+// other, including those already set on o, result in an error.
+func (o *Options) Parse(s string) error {
+	t := reflect.TypeOf(*o)
+	v := reflect.ValueOf(o).Elem()
+
+	for _, opt := range strings.Split(s, ",") {
+		if opt == "" {
+			continue
+		}
+		key, value := opt, ""
+		hasValue := false
+		if n := strings.IndexRune(opt, '='); n >= 0 {
+			key, value, hasValue = opt[:n], opt[n+1:], true
+		}
+
+		i := optionIndex(t, key)
+		if i < 0 {
+			return fmt.Errorf("unknown option %q, valid options are: %s", key, strings.Join(validOptions(t), ", "))
+		}
+		if err := setOption(v.Field(i), key, value, hasValue); err != nil {
+			return err
+		}
+	}
+	return o.Validate()
+}
+
+// Validate checks that no conflicting options, eg. ro and rw, are both set.
+func (o Options) Validate() error {
+	t := reflect.TypeOf(o)
+	v := reflect.ValueOf(o)
+	for _, c := range conflicts {
+		a, b := v.Field(optionIndex(t, c[0])), v.Field(optionIndex(t, c[1]))
+		if !a.IsZero() && !b.IsZero() {
+			return fmt.Errorf("options %q and %q are mutually exclusive", c[0], c[1])
+		}
+	}
+	return nil
+}
+
+// UnmarshalJSON accepts either a JSON object of Options fields or a string of
+// comma seperated krbd options, eg. "ro,queue_depth=128".
+func (o *Options) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return o.Parse(s)
+	}
+	type options Options
+	if err := json.Unmarshal(data, (*options)(o)); err != nil {
+		return err
+	}
+	return o.Validate()
+}
+
+// validOptions returns the krbd tags of all Options fields.
+func validOptions(t reflect.Type) []string {
+	tags := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		tags = append(tags, t.Field(i).Tag.Get("krbd"))
+	}
+	return tags
+}
+
+// optionIndex returns the index of the Options field with the provided krbd tag,
+// or -1 if there is no such field.
+func optionIndex(t reflect.Type, tag string) int {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("krbd") == tag {
+			return i
+		}
+	}
+	return -1
+}
+
+func setOption(f reflect.Value, key string, value string, hasValue bool) error {
+	// Bool types are set by the presence of the tag alone.
+	if f.Kind() == reflect.Bool {
+		if hasValue {
+			return fmt.Errorf("option %q does not take a value", key)
+		}
+		f.SetBool(true)
+		return nil
+	}
+	if !hasValue || value == "" {
+		return fmt.Errorf("option %q requires a value", key)
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Int:
+		n, err := strconv.ParseInt(value, 10, 0)
+		if err != nil {
+			return fmt.Errorf("option %q: %v", key, err)
+		}
+		f.SetInt(n)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("option %q: %v", key, err)
+		}
+		f.SetUint(n)
+	default:
+		return fmt.Errorf("option %q has unsupported type %s", key, f.Kind())
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package krbd
 
 import (
+	"encoding/json"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -110,3 +113,143 @@ func TestOptions_String(t *testing.T) {
 		})
 	}
 }
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    *Options
+		wantErr bool
+	}{
+		{
+			name: "Empty",
+			s:    "",
+			want: &Options{},
+		},
+		{
+			name: "Bools, ints, uint64s, and strings",
+			s:    "ro,queue_depth=128,lock_on_read,lock_timeout=500,_pool_ns=ns1",
+			want: &Options{ReadOnly: true, QueueDepth: 128, LockOnRead: true, LockTimeout: 500, Namespace: "ns1"},
+		},
+		{
+			name:    "Unknown option",
+			s:       "ro,bogus",
+			wantErr: true,
+		},
+		{
+			name:    "Bool with value",
+			s:       "ro=true",
+			wantErr: true,
+		},
+		{
+			name:    "Int without value",
+			s:       "queue_depth",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid int",
+			s:       "queue_depth=deep",
+			wantErr: true,
+		},
+		{
+			name:    "Negative uint64",
+			s:       "lock_timeout=-1",
+			wantErr: true,
+		},
+		{
+			name:    "ro and rw",
+			s:       "ro,rw",
+			wantErr: true,
+		},
+		{
+			name:    "crc and nocrc",
+			s:       "nocrc,crc",
+			wantErr: true,
+		},
+		{
+			name:    "share and noshare",
+			s:       "share,noshare",
+			wantErr: true,
+		},
+		{
+			name:    "secret and key",
+			s:       "secret=AQCvCbtToC6MDhAATtuT70Sl+DymPCfDSsyV4w==,key=client.admin",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOptions(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseOptions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseOptions() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOptions_unknownListsValid(t *testing.T) {
+	_, err := ParseOptions("bogus")
+	if err == nil || !strings.Contains(err.Error(), "queue_depth") || !strings.Contains(err.Error(), "_pool_ns") {
+		t.Errorf("ParseOptions() error = %v, want list of valid options", err)
+	}
+}
+
+func TestOptions_Parse_conflictWithExisting(t *testing.T) {
+	o := &Options{ReadOnly: true}
+	if err := o.Parse("rw"); err == nil {
+		t.Errorf("Options.Parse() error = nil, want conflict with existing ro")
+	}
+}
+
+func TestParseOptions_roundTrip(t *testing.T) {
+	for _, o := range []Options{
+		{},
+		{Name: "admin", Secret: "AQCvCbtToC6MDhAATtuT70Sl+DymPCfDSsyV4w=="},
+		{Fsid: "2b7d5a5c-0a4b-4c5e-9f2e-6b1e3c7f0d11", IP: "10.0.0.10", Noshare: true, NoCRC: true, CephxRequireSignatures: true, NoTCPNoDelay: true, CephxSignMessages: true, MountTimeout: 60, OSDKeepAlive: 5, OSDIdleTTL: 60},
+		{Force: true, ReadOnly: true, QueueDepth: 128, LockOnRead: true, Exclusive: true, LockTimeout: 500, NoTrim: true, AbortOnFull: true, AllocSize: 65536, Name: "admin", Key: "client.admin", Namespace: "ns1"},
+	} {
+		s := o.String()
+		got, err := ParseOptions(s)
+		if err != nil {
+			t.Errorf("ParseOptions(%q) error = %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, o) {
+			t.Errorf("ParseOptions(%q) = %#v, want %#v", s, *got, o)
+		}
+		if got.String() != s {
+			t.Errorf("ParseOptions(%q).String() = %q", s, got.String())
+		}
+	}
+}
+
+func TestOptions_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Options
+		wantErr bool
+	}{
+		{name: "Object", json: `{"name": "admin", "readonly": true}`, want: Options{Name: "admin", ReadOnly: true}},
+		{name: "String", json: `"name=admin,ro,queue_depth=128"`, want: Options{Name: "admin", ReadOnly: true, QueueDepth: 128}},
+		{name: "Invalid string", json: `"ro,bogus"`, wantErr: true},
+		{name: "Conflicting object", json: `{"readonly": true, "readwrite": true}`, wantErr: true},
+		{name: "Wrong type", json: `42`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var o Options
+			err := json.Unmarshal([]byte(tt.json), &o)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Options.UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(o, tt.want) {
+				t.Errorf("Options.UnmarshalJSON() = %#v, want %#v", o, tt.want)
+			}
+		})
+	}
+}
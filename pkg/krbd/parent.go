@@ -0,0 +1,92 @@
+package krbd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// noParent is the content of /sys/bus/rbd/devices/<id>/parent for an image
+// that isn't a clone.
+const noParent = "(no parent image)"
+
+// NoSnapID is the snap_id of a device mapping the image rather than a snapshot.
+const NoSnapID = ^uint64(0) - 1
+
+// Parent is the snapshot a cloned image was created from.
+type Parent struct {
+	PoolID    uint64
+	Pool      string
+	Namespace string
+	ImageID   string
+	Image     string
+	SnapID    uint64
+	Snapshot  string
+	// Overlap is the number of bytes of the clone still backed by the parent.
+	Overlap uint64
+}
+
+// Parents is the chain of parents of a cloned image, starting with the
+// immediate parent.
+type Parents []Parent
+
+// UnmarshalText parses the sysfs parent format, a blank line seperated list of
+// "<key> <value>" lines per parent, eg.
+//
+//	pool_id 2
+//	pool_name rbd
+//	pool_ns
+//	image_id 10226b8b4567
+//	image_name golden
+//	snap_id 4
+//	snap_name base
+//	overlap 10737418240
+func (p *Parents) UnmarshalText(text []byte) error {
+	*p = nil
+	if strings.TrimSpace(string(text)) == noParent {
+		return nil
+	}
+
+	var cur *Parent
+	s := bufio.NewScanner(bytes.NewReader(text))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			cur = nil
+			continue
+		}
+		if cur == nil {
+			*p = append(*p, Parent{})
+			cur = &(*p)[len(*p)-1]
+		}
+		key, value := line, ""
+		if n := strings.IndexByte(line, ' '); n >= 0 {
+			key, value = line[:n], strings.TrimSpace(line[n+1:])
+		}
+		var err error
+		switch key {
+		case "pool_id":
+			cur.PoolID, err = strconv.ParseUint(value, 10, 64)
+		case "pool_name":
+			cur.Pool = value
+		case "pool_ns":
+			cur.Namespace = value
+		case "image_id":
+			cur.ImageID = value
+		case "image_name":
+			cur.Image = value
+		case "snap_id":
+			cur.SnapID, err = strconv.ParseUint(value, 10, 64)
+		case "snap_name":
+			cur.Snapshot = value
+		case "overlap":
+			cur.Overlap, err = strconv.ParseUint(value, 10, 64)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid parent %s: %v", key, err)
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,47 @@
+package krbd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParents_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    Parents
+		wantErr bool
+	}{
+		{
+			name: "No parent",
+			text: "(no parent image)\n",
+		},
+		{
+			name: "Single parent with empty namespace",
+			text: "pool_id 2\npool_name rbd\npool_ns \nimage_id 1018e2ae8944a\nimage_name golden\nsnap_id 7\nsnap_name v2\noverlap 1024\n",
+			want: Parents{{PoolID: 2, Pool: "rbd", ImageID: "1018e2ae8944a", Image: "golden", SnapID: 7, Snapshot: "v2", Overlap: 1024}},
+		},
+		{
+			name: "Chain",
+			text: "pool_id 2\nimage_name golden\n\npool_id 3\nimage_name base\n",
+			want: Parents{{PoolID: 2, Image: "golden"}, {PoolID: 3, Image: "base"}},
+		},
+		{
+			name:    "Invalid overlap",
+			text:    "pool_id 2\noverlap lots\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Parents
+			err := p.UnmarshalText([]byte(tt.text))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parents.UnmarshalText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(p, tt.want) {
+				t.Errorf("Parents.UnmarshalText() = %#v, want %#v", p, tt.want)
+			}
+		})
+	}
+}
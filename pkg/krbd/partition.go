@@ -0,0 +1,41 @@
+package krbd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// partName returns the kernel name of partition n of d, eg. rbd0p1.
+func partName(d Device, n int) string {
+	return "rbd" + strconv.FormatInt(d.ID, 10) + "p" + strconv.Itoa(n)
+}
+
+// PartPath returns the path of the device node of partition n of d under
+// DevRoot, eg. /dev/rbd0p1. Does not validate that the partition actually exists.
+func (c *Client) PartPath(d Device, n int) string {
+	return filepath.Join(c.DevRoot, partName(d, n))
+}
+
+// HasPartition reports whether the kernel has created partition n of d, ie.
+// the partition table was scanned when the device was mapped.
+func (c *Client) HasPartition(d Device, n int) bool {
+	_, err := os.Stat(filepath.Join(c.SysRoot, "block", "rbd"+strconv.FormatInt(d.ID, 10), partName(d, n)))
+	return err == nil
+}
+
+// WaitPartition waits for the device node of partition n of d to appear,
+// returning its path. Waiting stops with an error when ctx is done.
+func (c *Client) WaitPartition(ctx context.Context, d Device, n int) (string, error) {
+	node := c.PartPath(d, n)
+	err := poll(ctx, func() (bool, error) {
+		_, err := os.Stat(node)
+		return err == nil, err
+	})
+	if err != nil {
+		return "", fmt.Errorf("waiting for %s to appear: %w", node, err)
+	}
+	return node, nil
+}
@@ -0,0 +1,47 @@
+package krbd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClient_WaitPartition(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = 10 * time.Millisecond
+
+	root, err := ioutil.TempDir("", "krbd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, dir := range []string{"sys/block/rbd1/rbd1p2", "dev"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "dev/rbd1p2"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClient(filepath.Join(root, "sys"), filepath.Join(root, "dev"))
+	d := Device{ID: 1}
+	if !c.HasPartition(d, 2) {
+		t.Errorf("Client.HasPartition(2) = false, want true")
+	}
+	if c.HasPartition(d, 1) {
+		t.Errorf("Client.HasPartition(1) = true, want false")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if got, err := c.WaitPartition(ctx, d, 2); err != nil || got != filepath.Join(root, "dev/rbd1p2") {
+		t.Errorf("Client.WaitPartition(2) = %v, %v", got, err)
+	}
+	if _, err := c.WaitPartition(ctx, d, 1); err == nil {
+		t.Errorf("Client.WaitPartition(1) error = nil, want timeout")
+	}
+}
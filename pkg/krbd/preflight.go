@@ -0,0 +1,222 @@
+package krbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LegacyMonPort is the default monitor port of the v1 (legacy) messenger protocol.
+	LegacyMonPort = 6789
+	// Msgr2MonPort is the default monitor port of the v2 messenger protocol.
+	Msgr2MonPort = 3300
+
+	// DefaultProbeTimeout is the time a monitor has to accept the connection
+	// and send its banner, see CheckMonitors.
+	DefaultProbeTimeout = 3 * time.Second
+)
+
+// Banners sent by a monitor right after accepting a connection.
+const (
+	legacyBanner = "ceph v027"
+	msgr2Banner  = "ceph v2\n"
+)
+
+// ErrMonitorsUnreachable is returned by MonitorReport.Err when none of the
+// monitors could be reached.
+var ErrMonitorsUnreachable = errors.New("No monitor reachable")
+
+// ErrNoMonitors is returned by MonitorReport.Err when no monitors were checked.
+// Unlike ErrMonitorsUnreachable it isn't Retryable.
+var ErrNoMonitors = errors.New("No monitors defined")
+
+// MonitorCheck is the result of probing a single address of a monitor.
+type MonitorCheck struct {
+	// Monitor is the monitor as given, eg. mon1.example.com.
+	Monitor string
+	// Addr is the resolved address probed, eg. 192.168.0.1:6789. Empty if the
+	// monitor couldn't be resolved.
+	Addr string
+	// Protocol is the messenger protocol of the banner, v1 or v2, empty if the
+	// probe failed.
+	Protocol string
+	Latency  time.Duration
+	Err      error
+}
+
+func (c MonitorCheck) String() string {
+	addr := c.Addr
+	if addr == "" {
+		addr = "-"
+	}
+	if c.Err != nil {
+		return fmt.Sprintf("%s %s: %v", c.Monitor, addr, c.Err)
+	}
+	return fmt.Sprintf("%s %s: %s in %s", c.Monitor, addr, c.Protocol, c.Latency.Round(time.Millisecond))
+}
+
+// MonitorReport is the result of CheckMonitors, one MonitorCheck per address
+// probed, in the order of the monitors.
+type MonitorReport []MonitorCheck
+
+// Reachable returns true if at least one monitor answered, which is all krbd
+// needs to map an image.
+func (r MonitorReport) Reachable() bool {
+	for _, c := range r {
+		if c.Err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns nil if a monitor is reachable, ErrNoMonitors if there were none,
+// otherwise an error wrapping ErrMonitorsUnreachable listing why each address
+// failed.
+func (r MonitorReport) Err() error {
+	if r.Reachable() {
+		return nil
+	}
+	if len(r) == 0 {
+		return ErrNoMonitors
+	}
+	checks := make([]string, len(r))
+	for i, c := range r {
+		checks[i] = c.String()
+	}
+	return fmt.Errorf("%w: %s", ErrMonitorsUnreachable, strings.Join(checks, "; "))
+}
+
+// Write writes each check to w on its own line.
+func (r MonitorReport) Write(w io.Writer) error {
+	for _, c := range r {
+		if _, err := fmt.Fprintln(w, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckMonitors checks that the monitors of the image are reachable, see
+// CheckMonitors.
+func (i *Image) CheckMonitors(ctx context.Context, timeout time.Duration) MonitorReport {
+	return CheckMonitors(ctx, i.Monitors, timeout)
+}
+
+// CheckMonitors resolves each monitor, host[:port], and probes every resolved
+// address concurrently by connecting and reading the monitor's banner. Without
+// a port both LegacyMonPort and Msgr2MonPort are probed. Each probe is given
+// timeout, or until ctx is done. Whether a banner is seen is reported rather
+// than just whether the connection was accepted, to catch a wrong address that
+// happens to have something listening.
+func CheckMonitors(ctx context.Context, monitors []string, timeout time.Duration) MonitorReport {
+	var probes [][]MonitorCheck
+	for _, mon := range monitors {
+		probes = append(probes, monitorAddrs(ctx, mon))
+	}
+
+	var wg sync.WaitGroup
+	for _, checks := range probes {
+		for i := range checks {
+			if checks[i].Err != nil {
+				continue
+			}
+			wg.Add(1)
+			go func(c *MonitorCheck) {
+				defer wg.Done()
+				start := time.Now()
+				c.Protocol, c.Err = probeMonitor(ctx, c.Addr, timeout)
+				c.Latency = time.Since(start)
+			}(&checks[i])
+		}
+	}
+	wg.Wait()
+
+	var r MonitorReport
+	for _, checks := range probes {
+		r = append(r, checks...)
+	}
+	return r
+}
+
+// monitorAddrs resolves the monitor mon and returns a check for each address to
+// probe, or a single failed check if mon can't be resolved.
+func monitorAddrs(ctx context.Context, mon string) []MonitorCheck {
+	host, ports, err := splitMonitor(mon)
+	if err != nil {
+		return []MonitorCheck{{Monitor: mon, Err: err}}
+	}
+	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return []MonitorCheck{{Monitor: mon, Err: err}}
+	}
+	var checks []MonitorCheck
+	for _, ip := range ips {
+		for _, port := range ports {
+			checks = append(checks, MonitorCheck{Monitor: mon, Addr: net.JoinHostPort(ip, strconv.Itoa(port))})
+		}
+	}
+	return checks
+}
+
+// splitMonitor splits a host[:port] monitor address, where an IPv6 host is
+// enclosed in brackets when a port is present, returning the default ports
+// when there isn't one.
+func splitMonitor(mon string) (string, []int, error) {
+	if strings.Count(mon, ":") > 1 && !strings.HasPrefix(mon, "[") {
+		// Bare IPv6 address without a port
+		return mon, []int{LegacyMonPort, Msgr2MonPort}, nil
+	}
+	if !strings.Contains(mon, ":") {
+		return mon, []int{LegacyMonPort, Msgr2MonPort}, nil
+	}
+	host, port, err := net.SplitHostPort(mon)
+	if err != nil {
+		return "", nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 {
+		return "", nil, fmt.Errorf("invalid port in monitor address %q", mon)
+	}
+	return host, []int{int(p)}, nil
+}
+
+// probeMonitor connects to addr and reads the banner, returning the messenger
+// protocol the monitor speaks.
+func probeMonitor(ctx context.Context, addr string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	banner := make([]byte, len(legacyBanner))
+	// The v2 banner is one byte shorter, and followed by binary data.
+	if _, err := io.ReadFull(conn, banner[:len(msgr2Banner)]); err != nil {
+		return "", fmt.Errorf("reading banner: %w", err)
+	}
+	if string(banner[:len(msgr2Banner)]) == msgr2Banner {
+		return "v2", nil
+	}
+	if _, err := io.ReadFull(conn, banner[len(msgr2Banner):]); err != nil {
+		return "", fmt.Errorf("reading banner: %w", err)
+	}
+	if string(banner) == legacyBanner {
+		return "v1", nil
+	}
+	return "", fmt.Errorf("not a ceph monitor, unexpected banner %q", banner)
+}
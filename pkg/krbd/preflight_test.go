@@ -0,0 +1,137 @@
+package krbd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMonitor listens on a local port and writes banner to every connection.
+// A nil banner accepts connections without writing anything.
+func fakeMonitor(t *testing.T, banner []byte) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if banner != nil {
+				conn.Write(banner)
+			}
+			// Leave the connection open until the listener is closed, a
+			// monitor waits for the client's banner.
+			go func() {
+				buf := make([]byte, 1)
+				conn.Read(buf)
+				conn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+// closedAddr returns a local address nothing is listening on.
+func closedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestCheckMonitors(t *testing.T) {
+	v1, stop := fakeMonitor(t, []byte(legacyBanner+"\x00\x00\x00\x00"))
+	defer stop()
+	v2, stop := fakeMonitor(t, []byte(msgr2Banner+"\x10\x00"))
+	defer stop()
+	http, stop := fakeMonitor(t, []byte("HTTP/1.1 400 Bad Request\r\n"))
+	defer stop()
+	silent, stop := fakeMonitor(t, nil)
+	defer stop()
+	closed := closedAddr(t)
+
+	tests := []struct {
+		name      string
+		monitors  []string
+		want      []string
+		reachable bool
+		wantErr   error
+	}{
+		{name: "v1", monitors: []string{v1}, want: []string{"v1"}, reachable: true},
+		{name: "v2", monitors: []string{v2}, want: []string{"v2"}, reachable: true},
+		{name: "One of several", monitors: []string{closed, v1, http}, want: []string{"", "v1", ""}, reachable: true},
+		{name: "Not a monitor", monitors: []string{http}, want: []string{""}},
+		{name: "No banner", monitors: []string{silent}, want: []string{""}},
+		{name: "Closed", monitors: []string{closed}, want: []string{""}},
+		{name: "Invalid port", monitors: []string{"127.0.0.1:http"}, want: []string{""}},
+		{name: "None", wantErr: ErrNoMonitors},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := CheckMonitors(context.Background(), tt.monitors, 200*time.Millisecond)
+			var got []string
+			for _, c := range r {
+				got = append(got, c.Protocol)
+				if (c.Protocol == "") != (c.Err != nil) {
+					t.Errorf("CheckMonitors() check %v, want an error exactly when no protocol", c)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CheckMonitors() protocols = %q, want %q", got, tt.want)
+			}
+			if r.Reachable() != tt.reachable {
+				t.Errorf("MonitorReport.Reachable() = %v, want %v", r.Reachable(), tt.reachable)
+			}
+			wantErr := tt.wantErr
+			if wantErr == nil && !tt.reachable {
+				wantErr = ErrMonitorsUnreachable
+			}
+			err := r.Err()
+			if (err == nil) != tt.reachable || (err != nil && !errors.Is(err, wantErr)) {
+				t.Errorf("MonitorReport.Err() = %v, want %v", err, wantErr)
+			}
+			if Retryable(err) != (wantErr == ErrMonitorsUnreachable) {
+				t.Errorf("Retryable(%v) = %v, want %v", err, Retryable(err), !Retryable(err))
+			}
+		})
+	}
+}
+
+func TestCheckMonitors_defaultPorts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	r := CheckMonitors(ctx, []string{"127.0.0.1", "::1", "[::1]:6789", "127.0.0.1:3300"}, 100*time.Millisecond)
+
+	got := map[string]bool{}
+	for _, c := range r {
+		got[c.Monitor+" "+c.Addr] = true
+	}
+	for _, want := range []string{
+		"127.0.0.1 127.0.0.1:6789",
+		"127.0.0.1 127.0.0.1:3300",
+		"::1 [::1]:6789",
+		"::1 [::1]:3300",
+		"[::1]:6789 [::1]:6789",
+		"127.0.0.1:3300 127.0.0.1:3300",
+	} {
+		if !got[want] {
+			t.Errorf("CheckMonitors() = %v, want %s probed", r, want)
+		}
+	}
+	if got["[::1]:6789 [::1]:3300"] {
+		t.Errorf("CheckMonitors() probed the default port of a monitor with a port")
+	}
+	if err := r.Err(); err != nil && !strings.Contains(err.Error(), "127.0.0.1 127.0.0.1:6789") {
+		t.Errorf("MonitorReport.Err() = %v, want each address listed", err)
+	}
+}
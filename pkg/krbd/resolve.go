@@ -0,0 +1,116 @@
+package krbd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNoMatch is returned when no mapped device matches.
+	ErrNoMatch = errors.New("No match found")
+	// ErrMultipleMatches is returned when a single device is expected but more than one matches.
+	ErrMultipleMatches = errors.New("Multiple matches found")
+)
+
+const (
+	devPrefix     = "/dev/rbd"
+	devLinkPrefix = "/dev/rbd/"
+	// headSnapshot is the current_snap of a device mapping the image rather than a snapshot.
+	headSnapshot = "-"
+	// DefaultPool is the pool of an image spec without one, like for upstream rbd.
+	DefaultPool = "rbd"
+)
+
+// FindDevices returns the mapped devices matching target, which is one of:
+//
+//	/dev/rbdN                                  the device node
+//	/dev/rbd/<pool>/[<ns>/]<image>[@<snap>]    a udev style symlink
+//	[<pool>/[<ns>/]]<image>[@<snap>]           an image spec, see Image.ParseSpec
+//
+// A spec without a snapshot only matches mappings of the image itself, a spec
+// without a namespace only the default namespace, and a spec without a pool
+// the rbd pool, like upstream rbd. ErrNoMatch is returned if nothing matches.
+func FindDevices(target string) ([]Device, error) {
+	return DefaultClient.FindDevices(target)
+}
+
+// ResolveDevice is like FindDevices but expects a single match, otherwise
+// ErrMultipleMatches is returned along with the matching devices.
+func ResolveDevice(target string) (Device, error) {
+	return DefaultClient.ResolveDevice(target)
+}
+
+// MultipleMatchesError lists the devices matched when a single one is expected.
+type MultipleMatchesError struct {
+	Target  string
+	Devices []Device
+	// client the devices were found by, for the paths of their device nodes,
+	// DefaultClient if nil.
+	client *Client
+}
+
+func (e *MultipleMatchesError) Error() string {
+	c := e.client
+	if c == nil {
+		c = DefaultClient
+	}
+	paths := make([]string, len(e.Devices))
+	for i, d := range e.Devices {
+		paths[i] = c.DevPath(d)
+	}
+	return fmt.Sprintf("%v for %s: %s", ErrMultipleMatches, e.Target, strings.Join(paths, ", "))
+}
+
+// Unwrap returns ErrMultipleMatches.
+func (e *MultipleMatchesError) Unwrap() error {
+	return ErrMultipleMatches
+}
+
+func findDevices(target string, devices []Device, evalSymlinks func(string) (string, error)) ([]Device, error) {
+	if strings.HasPrefix(target, devLinkPrefix) {
+		// Prefer following the symlink, which is exact, and fall back to treating the
+		// path as a spec for when udev isn't running, eg. in an initramfs.
+		if real, err := evalSymlinks(target); err == nil && real != target {
+			return findDevices(real, devices, evalSymlinks)
+		}
+		target = strings.TrimPrefix(target, devLinkPrefix)
+	} else if strings.HasPrefix(target, devPrefix) {
+		id, err := strconv.ParseInt(strings.TrimPrefix(target, devPrefix), 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RBD device path %s", target)
+		}
+		for _, d := range devices {
+			if d.ID == id {
+				return []Device{d}, nil
+			}
+		}
+		return nil, fmt.Errorf("%w for %s", ErrNoMatch, target)
+	}
+
+	query, err := parseDeviceSpec(target)
+	if err != nil {
+		return nil, err
+	}
+	var found []Device
+	for _, d := range devices {
+		if query.mapsImage(d) {
+			found = append(found, d)
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("%w for %s", ErrNoMatch, target)
+	}
+	return found, nil
+}
+
+// parseDeviceSpec parses an image spec, see Image.ParseSpec, into a Device used
+// to match mapped devices.
+func parseDeviceSpec(spec string) (Device, error) {
+	i, err := ParseImageSpec(spec)
+	if err != nil {
+		return Device{}, err
+	}
+	return i.device(), nil
+}
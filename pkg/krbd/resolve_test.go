@@ -0,0 +1,70 @@
+package krbd
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_findDevices(t *testing.T) {
+	devices := []Device{
+		{ID: 0, Pool: "rbd", Image: "image1", Snapshot: "-"},
+		{ID: 1, Pool: "rbd", Image: "image1", Snapshot: "snap1"},
+		{ID: 2, Pool: "rbd", Namespace: "ns1", Image: "image1", Snapshot: "-"},
+		{ID: 3, Pool: "rbd", Image: "image2", Snapshot: "-"},
+		{ID: 4, Pool: "rbd", Image: "image2", Snapshot: "-"},
+		{ID: 5, Pool: "other", Image: "image1", Snapshot: "-"},
+	}
+	links := map[string]string{
+		"/dev/rbd/rbd/image2": "/dev/rbd3",
+	}
+	evalSymlinks := func(path string) (string, error) {
+		if real, ok := links[path]; ok {
+			return real, nil
+		}
+		return "", os.ErrNotExist
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		want    []Device
+		wantErr error
+	}{
+		{name: "Device path", target: "/dev/rbd1", want: devices[1:2]},
+		{name: "Missing device path", target: "/dev/rbd9", wantErr: ErrNoMatch},
+		{name: "Invalid device path", target: "/dev/rbdx", wantErr: errors.New("")},
+		{name: "Symlink", target: "/dev/rbd/rbd/image2", want: devices[3:4]},
+		{name: "Dangling symlink path as spec", target: "/dev/rbd/rbd/ns1/image1", want: devices[2:3]},
+		{name: "Spec matches head in default namespace", target: "rbd/image1", want: devices[0:1]},
+		{name: "Spec with namespace", target: "rbd/ns1/image1", want: devices[2:3]},
+		{name: "Spec with snapshot", target: "rbd/image1@snap1", want: devices[1:2]},
+		{name: "Spec mapped twice", target: "rbd/image2", want: devices[3:5]},
+		{name: "Spec without match", target: "rbd/image3", wantErr: ErrNoMatch},
+		{name: "Spec without pool", target: "image2", want: devices[3:5]},
+		{name: "Spec without pool is in rbd pool", target: "image1", want: devices[0:1]},
+		{name: "Spec in other pool", target: "other/image1", want: devices[5:6]},
+		{name: "Spec in other namespace", target: "rbd/ns2/image1", wantErr: ErrNoMatch},
+		{name: "Spec with escaped slash", target: `rbd/ns1/x\/image1`, wantErr: errors.New("")},
+		{name: "Spec with too many components", target: "rbd/ns1/x/image1", wantErr: errors.New("")},
+		{name: "Spec with empty snapshot", target: "rbd/image1@", wantErr: errors.New("")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findDevices(tt.target, devices, evalSymlinks)
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("findDevices() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if tt.wantErr.Error() != "" && !errors.Is(err, tt.wantErr) {
+					t.Errorf("findDevices() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findDevices() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
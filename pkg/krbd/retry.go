@@ -0,0 +1,95 @@
+package krbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+)
+
+// Retry is the policy for retrying a map that failed with an error that may go
+// away on its own, eg. the network not being up yet during early boot. See
+// Retryable for which errors are retried.
+type Retry struct {
+	// Retries is the number of times to retry after the first attempt failed.
+	Retries int
+	// Backoff is the wait before the first retry, doubled for every following
+	// retry up to MaxBackoff. Waits shorter than minBackoff, including a zero
+	// Backoff, are raised to minBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetry retries a map five times, over about 15 seconds.
+var DefaultRetry = Retry{Retries: 5, Backoff: 500 * time.Millisecond, MaxBackoff: 8 * time.Second}
+
+// minBackoff is the shortest wait between retries, so that a zero Backoff
+// doesn't retry in a tight loop.
+const minBackoff = 10 * time.Millisecond
+
+// retryableErrnos are errors of writing to the sysfs add file that may go away
+// on their own. ENOENT when the rbd module is still being loaded, or the pool or
+// image isn't in the osdmap received yet. ETIMEDOUT when the monitors couldn't
+// be reached within mount_timeout. The others when the network isn't ready.
+// Others are fatal, notably EINVAL for an invalid image spec or option, and
+// EEXIST for an image that is already mapped exclusively.
+var retryableErrnos = []syscall.Errno{
+	syscall.ENOENT,
+	syscall.ETIMEDOUT,
+	syscall.EAGAIN,
+	syscall.ENETUNREACH,
+	syscall.EHOSTUNREACH,
+	syscall.ECONNREFUSED,
+}
+
+// Retryable returns true if mapping an image that failed with err, or
+// checking its monitors that failed with ErrMonitorsUnreachable, may succeed
+// when tried again.
+func Retryable(err error) bool {
+	if errors.Is(err, ErrMonitorsUnreachable) {
+		return true
+	}
+	for _, errno := range retryableErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// Do calls fn until it succeeds, returns an error that isn't Retryable, the
+// retries are used up, or ctx is done. The last error of fn is returned, wrapped
+// with ctx.Err() if ctx is done.
+func (r Retry) Do(ctx context.Context, fn func() error) error {
+	backoff := r.Backoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !Retryable(err) || attempt >= r.Retries {
+			return err
+		}
+
+		wait := backoff
+		if wait < minBackoff {
+			wait = minBackoff
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%w: %v", ctx.Err(), err)
+		case <-t.C:
+		}
+		if backoff *= 2; r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+			backoff = r.MaxBackoff
+		}
+	}
+}
+
+// MapAndWaitRetry is MapAndWait, retrying the map according to r. The deadline
+// of ctx is the overall deadline of mapping, including retries, and waiting for
+// the device.
+func (i *Image) MapAndWaitRetry(ctx context.Context, w io.Writer, r Retry) (Device, error) {
+	return DefaultClient.mapAndWait(ctx, i, w, r)
+}
@@ -0,0 +1,124 @@
+package krbd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// failWriter fails the first failures writes with err, like a write to
+// /sys/bus/rbd/add before the network is up, then writes to w.
+type failWriter struct {
+	w        io.Writer
+	failures int
+	err      error
+	writes   int
+}
+
+func (f *failWriter) Write(p []byte) (int, error) {
+	f.writes++
+	if f.writes <= f.failures {
+		return 0, &os.PathError{Op: "write", Path: "/sys/bus/rbd/add", Err: f.err}
+	}
+	return f.w.Write(p)
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "ENOENT", err: &os.PathError{Op: "write", Path: "/sys/bus/rbd/add", Err: syscall.ENOENT}, want: true},
+		{name: "ETIMEDOUT", err: &os.PathError{Op: "write", Path: "/sys/bus/rbd/add", Err: syscall.ETIMEDOUT}, want: true},
+		{name: "EEXIST", err: &os.PathError{Op: "write", Path: "/sys/bus/rbd/add", Err: syscall.EEXIST}, want: false},
+		{name: "EINVAL", err: &os.PathError{Op: "write", Path: "/sys/bus/rbd/add", Err: syscall.EINVAL}, want: false},
+		{name: "Other", err: errors.New("No monitors defined"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Retryable(tt.err); got != tt.want {
+				t.Errorf("Retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetry_Do_zeroBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	err := Retry{Retries: 1000}.Do(ctx, func() error {
+		calls++
+		return syscall.ETIMEDOUT
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Retry.Do() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// One call up front, then at most one per minBackoff until the deadline
+	if max := int(50*time.Millisecond/minBackoff) + 1; calls > max {
+		t.Errorf("Retry.Do() called fn %d times, want at most %d", calls, max)
+	}
+}
+
+func TestClient_mapAndWait_retry(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = 10 * time.Millisecond
+
+	image := Image{Monitors: []string{"192.168.0.1"}, Pool: "rbd", Image: "image1"}
+	retry := Retry{Retries: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	tests := []struct {
+		name       string
+		failures   int
+		err        error
+		retry      Retry
+		timeout    time.Duration
+		wantWrites int
+		wantErr    error
+	}{
+		{name: "No failures", retry: retry, timeout: time.Second, wantWrites: 1},
+		{name: "Retried", failures: 3, err: syscall.ETIMEDOUT, retry: retry, timeout: time.Second, wantWrites: 4},
+		{name: "Retries used up", failures: 4, err: syscall.ENOENT, retry: retry, timeout: time.Second, wantWrites: 4, wantErr: syscall.ENOENT},
+		{name: "Fatal", failures: 1, err: syscall.EINVAL, retry: retry, timeout: time.Second, wantWrites: 1, wantErr: syscall.EINVAL},
+		{name: "No retries", failures: 1, err: syscall.ETIMEDOUT, timeout: time.Second, wantWrites: 1, wantErr: syscall.ETIMEDOUT},
+		{
+			name:       "Deadline",
+			failures:   10,
+			err:        syscall.ETIMEDOUT,
+			retry:      Retry{Retries: 10, Backoff: 40 * time.Millisecond},
+			timeout:    60 * time.Millisecond,
+			wantWrites: 2,
+			wantErr:    context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "krbd")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+
+			w := &failWriter{w: &fakeAddWriter{t: t, root: root}, failures: tt.failures, err: tt.err}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			c := NewClient(filepath.Join(root, "sys"), filepath.Join(root, "dev"))
+			_, err = c.mapAndWait(ctx, &image, w, tt.retry)
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("Client.mapAndWait() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if w.writes != tt.wantWrites {
+				t.Errorf("Client.mapAndWait() wrote %d times, want %d", w.writes, tt.wantWrites)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package krbd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ParseSpec sets the pool, namespace, image, and snapshot of (i *Image) from an
+// image spec of the form [<pool>/[<namespace>/]]<image>[@<snap>], as used by the
+// upstream rbd CLI. Components missing from the spec are left untouched, eg. the
+// pool when only an image is given.
+//
+// Like upstream, components can't contain / or @. They also can't be empty or
+// contain whitespace or control characters, as those can't be passed via the
+// krbd add interface, and a snapshot can't be named "-".
+func (i *Image) ParseSpec(spec string) error {
+	head, snap := spec, ""
+	hasSnap := false
+	if n := strings.IndexByte(spec, '@'); n >= 0 {
+		head, snap, hasSnap = spec[:n], spec[n+1:], true
+	}
+	parts := strings.Split(head, "/")
+
+	components := parts
+	if hasSnap {
+		components = append(components, snap)
+	}
+	for _, p := range components {
+		if p == "" {
+			return fmt.Errorf("invalid image spec %q: empty component", spec)
+		}
+		for _, c := range p {
+			if c == '/' || c == '@' || unicode.IsSpace(c) || unicode.IsControl(c) {
+				return fmt.Errorf("invalid image spec %q: %q is not allowed", spec, c)
+			}
+		}
+	}
+	if hasSnap && snap == headSnapshot {
+		return fmt.Errorf("invalid image spec %q: snapshot can't be named %q", spec, headSnapshot)
+	}
+
+	switch len(parts) {
+	case 1:
+		i.Image = parts[0]
+	case 2:
+		i.Pool, i.Image = parts[0], parts[1]
+	case 3:
+		i.Pool, i.Image = parts[0], parts[2]
+		if i.Options == nil {
+			i.Options = &Options{}
+		}
+		i.Options.Namespace = parts[1]
+	default:
+		return fmt.Errorf("invalid image spec %q: expected [<pool>/[<namespace>/]]<image>[@<snap>]", spec)
+	}
+	if hasSnap {
+		i.Snapshot = snap
+	}
+	return nil
+}
+
+// ParseImageSpec returns a new Image from an image spec, see Image.ParseSpec.
+func ParseImageSpec(spec string) (*Image, error) {
+	i := &Image{}
+	if err := i.ParseSpec(spec); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Spec returns the image spec of the Image, <pool>/[<namespace>/]<image>[@<snap>].
+func (i Image) Spec() string {
+	s := i.Pool + "/"
+	if i.Options != nil && i.Options.Namespace != "" {
+		s += i.Options.Namespace + "/"
+	}
+	s += i.Image
+	if i.Snapshot != "" {
+		s += "@" + i.Snapshot
+	}
+	return s
+}
+
+// UnmarshalJSON allows an image spec to be provided via "spec" in addition
+// to the individual attributes, which take precedence over the spec.
+func (i *Image) UnmarshalJSON(data []byte) error {
+	var spec struct {
+		Spec string `json:"spec"`
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return err
+	}
+	if spec.Spec != "" {
+		if err := i.ParseSpec(spec.Spec); err != nil {
+			return err
+		}
+	}
+	type image Image
+	return json.Unmarshal(data, (*image)(i))
+}
@@ -0,0 +1,78 @@
+package krbd
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestImage_ParseSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   Image
+		spec    string
+		want    Image
+		wantErr bool
+	}{
+		{name: "Image only keeps pool", image: Image{Pool: "rbd"}, spec: "image1", want: Image{Pool: "rbd", Image: "image1"}},
+		{name: "Pool and image", spec: "rbd/image1", want: Image{Pool: "rbd", Image: "image1"}},
+		{name: "Pool, namespace, image, and snapshot", spec: "rbd/ns1/image1@snap1", want: Image{Pool: "rbd", Image: "image1", Snapshot: "snap1", Options: &Options{Namespace: "ns1"}}},
+		{name: "Namespace keeps options", image: Image{Options: &Options{ReadOnly: true}}, spec: "rbd/ns1/image1", want: Image{Pool: "rbd", Image: "image1", Options: &Options{ReadOnly: true, Namespace: "ns1"}}},
+		{name: "Empty", spec: "", wantErr: true},
+		{name: "Empty pool", spec: "/image1", wantErr: true},
+		{name: "Empty snapshot", spec: "rbd/image1@", wantErr: true},
+		{name: "Too many components", spec: "a/b/c/d", wantErr: true},
+		{name: "Second @", spec: "rbd/image1@snap1@snap2", wantErr: true},
+		{name: "Slash in snapshot", spec: "rbd/image1@snap/1", wantErr: true},
+		{name: "Whitespace", spec: "rbd/image 1", wantErr: true},
+		{name: "Control character", spec: "rbd/image\t1", wantErr: true},
+		{name: "No escaped slash", spec: `rbd/ns1/image\/1`, wantErr: true},
+		{name: "No escaped @", spec: `rbd/image1@snap\@1`, wantErr: true},
+		{name: "Head snapshot name", spec: "rbd/image1@-", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := tt.image
+			err := i.ParseSpec(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Image.ParseSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(i, tt.want) {
+				t.Errorf("Image.ParseSpec() = %#v, want %#v", i, tt.want)
+			}
+			// Spec is the inverse of ParseSpec when a pool is present.
+			if got := i.Spec(); got != tt.spec && tt.image.Pool == "" {
+				t.Errorf("Image.Spec() = %q, want %q", got, tt.spec)
+			}
+		})
+	}
+}
+
+func TestImage_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Image
+		wantErr bool
+	}{
+		{name: "Spec", json: `{"spec": "rbd/ns1/image1@snap1"}`, want: Image{Pool: "rbd", Image: "image1", Snapshot: "snap1", Options: &Options{Namespace: "ns1"}}},
+		{name: "Attributes override spec", json: `{"spec": "rbd/ns1/image1", "pool": "rbd2", "opts": {"readonly": true}}`, want: Image{Pool: "rbd2", Image: "image1", Options: &Options{Namespace: "ns1", ReadOnly: true}}},
+		{name: "No spec", json: `{"pool": "rbd", "image": "image1", "mons": ["10.0.0.1"]}`, want: Image{Pool: "rbd", Image: "image1", Monitors: []string{"10.0.0.1"}}},
+		{name: "Invalid spec", json: `{"spec": "rbd/image 1"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var i Image
+			err := json.Unmarshal([]byte(tt.json), &i)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Image.UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(i, tt.want) {
+				t.Errorf("Image.UnmarshalJSON() = %#v, want %#v", i, tt.want)
+			}
+		})
+	}
+}
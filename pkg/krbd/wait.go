@@ -0,0 +1,113 @@
+package krbd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// pollInterval is how often sysfs and /dev are checked while waiting for a
+// mapped device to appear.
+var pollInterval = 100 * time.Millisecond
+
+// MapAndWait maps the RBD image like Map, then waits until the device created
+// by the mapping appears in sysfs and its /dev/rbdN node exists. The existing
+// device IDs are recorded before mapping so that a second mapping of an image
+// isn't mistaken for the first. Waiting stops with an error when ctx is done.
+// If the device appeared in sysfs but its node didn't, the device is returned
+// along with the error so that the caller can unmap it, otherwise the returned
+// Device is the zero value on error.
+func (i *Image) MapAndWait(ctx context.Context, w io.Writer) (Device, error) {
+	return DefaultClient.mapAndWait(ctx, i, w, Retry{})
+}
+
+func (c *Client) mapAndWait(ctx context.Context, i *Image, w io.Writer, r Retry) (Device, error) {
+	existing, err := c.Devices()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Device{}, err
+	}
+	ids := make(map[int64]bool, len(existing))
+	for _, d := range existing {
+		ids[d.ID] = true
+	}
+
+	if err := r.Do(ctx, func() error { return i.Map(w) }); err != nil {
+		return Device{}, err
+	}
+
+	want := i.device()
+	var dev Device
+	err = poll(ctx, func() (bool, error) {
+		devices, err := c.Devices()
+		if err != nil {
+			// Attributes of a device that is still being added may not be readable yet.
+			return false, err
+		}
+		var found []Device
+		for _, d := range devices {
+			if !ids[d.ID] && want.mapsImage(d) {
+				found = append(found, d)
+			}
+		}
+		switch len(found) {
+		case 0:
+			return false, ErrNoMatch
+		case 1:
+			dev = found[0]
+			return true, nil
+		}
+		return false, &MultipleMatchesError{Target: i.Spec(), Devices: found, client: c}
+	})
+	if err != nil {
+		return Device{}, fmt.Errorf("waiting for %s to appear in %s: %w", i.Spec(), c.busPath(), err)
+	}
+
+	node := c.DevPath(dev)
+	err = poll(ctx, func() (bool, error) {
+		_, err := os.Stat(node)
+		return err == nil, err
+	})
+	if err != nil {
+		return dev, fmt.Errorf("waiting for %s to appear: %w", node, err)
+	}
+	return dev, nil
+}
+
+// device returns a Device with the attributes a mapping of (i *Image) shows in sysfs.
+func (i *Image) device() Device {
+	d := Device{Pool: i.Pool, Image: i.Image, Snapshot: i.Snapshot}
+	if d.Pool == "" {
+		d.Pool = DefaultPool
+	}
+	if i.Options != nil {
+		d.Namespace = i.Options.Namespace
+	}
+	if d.Snapshot == "" {
+		d.Snapshot = headSnapshot
+	}
+	return d
+}
+
+// poll calls done every pollInterval until it returns true or ctx is done, in
+// which case the last error returned by done, if any, is wrapped with ctx.Err().
+func poll(ctx context.Context, done func() (bool, error)) error {
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+	for {
+		ok, err := done()
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return fmt.Errorf("%w: %v", ctx.Err(), err)
+			}
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
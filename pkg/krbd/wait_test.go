@@ -0,0 +1,147 @@
+package krbd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSysfs creates the sysfs files of a mapped device under root, like the
+// tree in test/sys, and optionally its device node under root/dev.
+func fakeSysfs(t *testing.T, root string, d Device, node bool) {
+	t.Helper()
+	id := strconv.FormatInt(d.ID, 10)
+	dir := filepath.Join(root, "sys/devices/rbd", id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{"pool": d.Pool, "pool_ns": d.Namespace, "name": d.Image, "current_snap": d.Snapshot} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bus := filepath.Join(root, "sys/bus/rbd/devices")
+	if err := os.MkdirAll(bus, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../../devices/rbd/"+id, filepath.Join(bus, id)); err != nil {
+		t.Fatal(err)
+	}
+	if node {
+		fakeDevNode(t, root, d.ID)
+	}
+}
+
+func fakeDevNode(t *testing.T, root string, id int64) {
+	if err := os.MkdirAll(filepath.Join(root, "dev"), 0755); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "dev", "rbd"+strconv.FormatInt(id, 10)), nil, 0644); err != nil {
+		t.Error(err)
+	}
+}
+
+// fakeAddWriter creates the sysfs files for each mapped image as the next
+// device ID, and after nodeDelay the device node. A negative nodeDelay never
+// creates the device node.
+type fakeAddWriter struct {
+	t         *testing.T
+	root      string
+	next      int64
+	nodeDelay time.Duration
+	err       error
+}
+
+func (f *fakeAddWriter) Write(p []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	// ${mons} ${opts} ${pool} ${image} ${snap}
+	fields := strings.Fields(string(p))
+	d := Device{ID: f.next, Pool: fields[2], Image: fields[3], Snapshot: fields[4]}
+	if o, err := ParseOptions(fields[1]); err == nil {
+		d.Namespace = o.Namespace
+	}
+	f.next++
+	fakeSysfs(f.t, f.root, d, false)
+	if f.nodeDelay >= 0 {
+		time.AfterFunc(f.nodeDelay, func() { fakeDevNode(f.t, f.root, d.ID) })
+	}
+	return len(p), nil
+}
+
+func TestClient_mapAndWait(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = 10 * time.Millisecond
+
+	image := Image{Monitors: []string{"192.168.0.1"}, Pool: "rbd", Image: "image1", Options: &Options{Name: "admin", Secret: "AQAvjX9eabfZAhAAj/g5nXSe/uaemYGCu1w53Q==", Namespace: "ns1"}}
+	tests := []struct {
+		name      string
+		existing  []Device
+		nodeDelay time.Duration
+		writeErr  error
+		want      Device
+		wantErr   bool
+	}{
+		{
+			name: "No existing devices",
+			want: Device{ID: 0, Pool: "rbd", Namespace: "ns1", Image: "image1", Snapshot: "-"},
+		},
+		{
+			name:      "Image already mapped",
+			existing:  []Device{{ID: 0, Pool: "rbd", Namespace: "ns1", Image: "image1", Snapshot: "-"}},
+			nodeDelay: 50 * time.Millisecond,
+			want:      Device{ID: 1, Pool: "rbd", Namespace: "ns1", Image: "image1", Snapshot: "-"},
+		},
+		{
+			name:     "Image mapped in another namespace",
+			existing: []Device{{ID: 0, Pool: "rbd", Image: "image1", Snapshot: "-"}},
+			want:     Device{ID: 1, Pool: "rbd", Namespace: "ns1", Image: "image1", Snapshot: "-"},
+		},
+		{
+			name:      "Device node never appears",
+			nodeDelay: -1,
+			want:      Device{ID: 0, Pool: "rbd", Namespace: "ns1", Image: "image1", Snapshot: "-"},
+			wantErr:   true,
+		},
+		{
+			name:     "Map fails",
+			writeErr: errors.New("write failed"),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "krbd")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+
+			for _, d := range tt.existing {
+				fakeSysfs(t, root, d, true)
+			}
+			w := &fakeAddWriter{t: t, root: root, next: int64(len(tt.existing)), nodeDelay: tt.nodeDelay, err: tt.writeErr}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			c := NewClient(filepath.Join(root, "sys"), filepath.Join(root, "dev"))
+			got, err := c.mapAndWait(ctx, &image, w, Retry{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Client.mapAndWait() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Client.mapAndWait() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bensallen/rbd/pkg/partition"
+)
+
+// ErrNotFound is returned by FindFilesystem when nothing matches.
+var ErrNotFound = errors.New("no matching filesystem or partition found")
+
+// Match is a filesystem or partition found by FindFilesystem.
+type Match struct {
+	// Filesystem is empty if the match is a partition without a recognized filesystem.
+	Filesystem Filesystem
+	// Partition is nil if the filesystem is on the whole device.
+	Partition *partition.Partition
+}
+
+// FindFilesystem looks for a filesystem, or a partition, with the UUID or label
+// on the block device or image file r, without relying on udev. The filesystem
+// on the whole device is checked first, then each partition's table entry and
+// filesystem. UUIDs are compared case insensitively.
+func FindFilesystem(r io.ReaderAt, uuid string, label string) (Match, error) {
+	matches := func(id string, l string) bool {
+		if uuid != "" {
+			return strings.EqualFold(id, uuid)
+		}
+		return label != "" && l == label
+	}
+
+	if fs, err := Probe(r); err == nil {
+		if matches(fs.UUID, fs.Label) {
+			return Match{Filesystem: fs}, nil
+		}
+	} else if !errors.Is(err, ErrUnknownFilesystem) {
+		return Match{}, err
+	}
+
+	table, err := partition.Read(r)
+	if err != nil {
+		if errors.Is(err, partition.ErrNoTable) {
+			return Match{}, ErrNotFound
+		}
+		return Match{}, err
+	}
+	for i := range table.Partitions {
+		p := &table.Partitions[i]
+		fs, err := Probe(io.NewSectionReader(r, p.Start, p.Size))
+		if err != nil && !errors.Is(err, ErrUnknownFilesystem) {
+			return Match{}, fmt.Errorf("partition %d: %w", p.Number, err)
+		}
+		if matches(p.UUID, p.Label) || matches(fs.UUID, fs.Label) {
+			return Match{Filesystem: fs, Partition: p}, nil
+		}
+	}
+	return Match{}, ErrNotFound
+}
+
+// FindFilesystemFile is FindFilesystem of the block device or image file at path.
+func FindFilesystemFile(path string, uuid string, label string) (Match, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Match{}, err
+	}
+	defer f.Close()
+	m, err := FindFilesystem(f, uuid, label)
+	if err != nil {
+		return Match{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// FindBlockDevice is FindFilesystem of the local disks, listed in sysfs at
+// sysRoot, eg. /sys, with device nodes under devRoot, eg. /dev. It returns the
+// path of the whole disk or the partition holding the match, and its
+// filesystem. Loop, RAM, and RBD devices aren't local disks and are skipped, as
+// are disks that can't be read, eg. an empty CD drive.
+func FindBlockDevice(sysRoot string, devRoot string, uuid string, label string) (string, Filesystem, error) {
+	disks, err := ioutil.ReadDir(filepath.Join(sysRoot, "block"))
+	if err != nil {
+		return "", Filesystem{}, err
+	}
+	for _, d := range disks {
+		name := d.Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "rbd") {
+			continue
+		}
+		m, err := FindFilesystemFile(filepath.Join(devRoot, name), uuid, label)
+		if err != nil {
+			continue
+		}
+		if m.Partition == nil {
+			return filepath.Join(devRoot, name), m.Filesystem, nil
+		}
+		part, err := partitionName(filepath.Join(sysRoot, "block", name), m.Partition.Number)
+		if err != nil {
+			return "", Filesystem{}, err
+		}
+		return filepath.Join(devRoot, part), m.Filesystem, nil
+	}
+	return "", Filesystem{}, ErrNotFound
+}
+
+// partitionName returns the name of the kernel's device of partition number n
+// of the disk at the sysfs path disk, eg. sda2 or nvme0n1p2.
+func partitionName(disk string, n int) (string, error) {
+	entries, err := ioutil.ReadDir(disk)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		b, err := ioutil.ReadFile(filepath.Join(disk, e.Name(), "partition"))
+		if err == nil && strings.TrimSpace(string(b)) == strconv.Itoa(n) {
+			return e.Name(), nil
+		}
+	}
+	return "", fmt.Errorf("%s: no device for partition %d", filepath.Base(disk), n)
+}
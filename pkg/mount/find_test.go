@@ -0,0 +1,131 @@
+package mount
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// partitionedImage returns an image with a MBR of an ext4 partition labeled
+// boot, and an xfs partition labeled root.
+func partitionedImage() []byte {
+	return image(1<<20, func(b []byte) {
+		binary.LittleEndian.PutUint32(b[440:], 0x0c3a8d6f)
+		for i, start := range []uint32{64, 1024} {
+			e := b[446+16*i:]
+			e[4] = 0x83
+			binary.LittleEndian.PutUint32(e[8:], start)
+			binary.LittleEndian.PutUint32(e[12:], 512)
+		}
+		b[510], b[511] = 0x55, 0xaa
+
+		setExt(b[64*512:], extCompatHasJournal, 0x2c2, 0x46b, "boot")
+		xfs := b[1024*512:]
+		copy(xfs, "XFSB")
+		copy(xfs[32:], []byte{0xde, 0xad, 0xbe, 0xef, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1})
+		copy(xfs[108:], "root")
+	})
+}
+
+func TestFindFilesystem(t *testing.T) {
+	whole := image(8192, func(b []byte) { setExt(b, extCompatHasJournal, 0x2c2, 0x46b, "rootfs") })
+	tests := []struct {
+		name    string
+		image   []byte
+		uuid    string
+		label   string
+		want    string
+		part    int
+		wantErr error
+	}{
+		{name: "Whole device by label", image: whole, label: "rootfs", want: "ext4"},
+		{name: "Whole device by UUID", image: whole, uuid: "1B4E28BA-2FA1-11D2-883F-0016D3CC427E", want: "ext4"},
+		{name: "Whole device no match", image: whole, label: "other", wantErr: ErrNotFound},
+		{name: "Filesystem label in partition", image: partitionedImage(), label: "root", want: "xfs", part: 2},
+		{name: "Filesystem UUID in partition", image: partitionedImage(), uuid: testUUIDString, want: "ext4", part: 1},
+		{name: "Partition UUID", image: partitionedImage(), uuid: "0c3a8d6f-02", want: "xfs", part: 2},
+		{name: "No match in partitions", image: partitionedImage(), label: "home", wantErr: ErrNotFound},
+		{name: "Neither filesystem nor table", image: image(8192, func([]byte) {}), label: "root", wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FindFilesystem(bytes.NewReader(tt.image), tt.uuid, tt.label)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FindFilesystem() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if got.Filesystem.Type != tt.want {
+				t.Errorf("FindFilesystem() type = %q, want %q", got.Filesystem.Type, tt.want)
+			}
+			switch {
+			case tt.part == 0 && got.Partition != nil:
+				t.Errorf("FindFilesystem() partition = %d, want whole device", got.Partition.Number)
+			case tt.part != 0 && (got.Partition == nil || got.Partition.Number != tt.part):
+				t.Errorf("FindFilesystem() partition = %+v, want %d", got.Partition, tt.part)
+			}
+		})
+	}
+}
+
+func TestFindBlockDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbd-find")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sys, dev := filepath.Join(dir, "sys"), filepath.Join(dir, "dev")
+	files := map[string][]byte{
+		"sys/block/loop0/size":         nil,
+		"sys/block/rbd0/size":          nil,
+		"sys/block/sda/size":           nil,
+		"sys/block/sda/sda1/partition": []byte("1\n"),
+		"sys/block/sda/sda2/partition": []byte("2\n"),
+		"sys/block/sdb/size":           nil,
+		"sys/block/sr0/size":           nil,
+		"dev/loop0":                    image(8192, func(b []byte) { setExt(b, extCompatHasJournal, 0x2c2, 0x46b, "data") }),
+		"dev/rbd0":                     image(8192, func(b []byte) { setExt(b, extCompatHasJournal, 0x2c2, 0x46b, "upper") }),
+		"dev/sda":                      partitionedImage(),
+		"dev/sdb":                      image(8192, func(b []byte) { setExt(b, extCompatHasJournal, 0x2c2, 0x46b, "data") }),
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		label   string
+		want    string
+		wantFs  string
+		wantErr error
+	}{
+		{name: "Partition", label: "root", want: "sda2", wantFs: "xfs"},
+		{name: "Whole disk", label: "data", want: "sdb", wantFs: "ext4"},
+		{name: "RBD skipped", label: "upper", wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fs, err := FindBlockDevice(sys, dev, "", tt.label)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FindBlockDevice() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if got != filepath.Join(dev, tt.want) || fs.Type != tt.wantFs {
+				t.Errorf("FindBlockDevice() = %s, %s, want %s, %s", got, fs.Type, filepath.Join(dev, tt.want), tt.wantFs)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package mount
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"github.com/u-root/u-root/pkg/mount/loop"
+	"golang.org/x/sys/unix"
+)
+
+// LoopSetupRange attaches a free loop device to size bytes of filename starting
+// at offset, eg. a partition of a disk whose partitions the kernel hasn't
+// scanned, and returns its /dev/loopN path.
+func LoopSetupRange(filename string, offset int64, size int64, readOnly bool) (string, error) {
+	loopDevice, err := loop.FindDevice()
+	if err != nil {
+		return "", err
+	}
+
+	mode := os.O_RDWR
+	if readOnly {
+		mode = os.O_RDONLY
+	}
+	file, err := os.OpenFile(filename, mode, 0)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	dev, err := os.OpenFile(loopDevice, mode, 0)
+	if err != nil {
+		return "", err
+	}
+	defer dev.Close()
+
+	if err := loop.SetFD(int(dev.Fd()), int(file.Fd())); err != nil {
+		return "", fmt.Errorf("attaching %s to %s: %v", filename, loopDevice, err)
+	}
+
+	info := unix.LoopInfo64{Offset: uint64(offset), Sizelimit: uint64(size)}
+	if readOnly {
+		info.Flags |= unix.LO_FLAGS_READ_ONLY
+	}
+	copy(info.File_name[:], filename)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, dev.Fd(), unix.LOOP_SET_STATUS64, uintptr(unsafe.Pointer(&info))); errno != 0 {
+		loop.ClearFD(int(dev.Fd()))
+		return "", fmt.Errorf("setting offset of %s: %v", loopDevice, errno)
+	}
+	return loopDevice, nil
+}
+
+// LoopDetach detaches the file from the loop device, eg. one attached by
+// LoopSetupRange.
+func LoopDetach(loopDevice string) error {
+	return loop.ClearFile(loopDevice)
+}
+
+// LoopDevices returns the /dev/loopN paths of the loop devices attached to
+// filename, from their backing_file in sysfs.
+func LoopDevices(filename string) ([]string, error) {
+	files, err := filepath.Glob("/sys/block/loop*/loop/backing_file")
+	if err != nil {
+		return nil, err
+	}
+	var devices []string
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			// Detached meanwhile
+			continue
+		}
+		if strings.TrimSpace(string(b)) == filename {
+			devices = append(devices, "/dev/"+filepath.Base(filepath.Dir(filepath.Dir(f))))
+		}
+	}
+	return devices, nil
+}
@@ -27,16 +27,12 @@ func loopSetup(filename string) (loopDevice string, err error) {
 }
 
 // Mount extends u-root/pkg/mount to setup loop devices and parse input options
-// into data and flags
+// into data and flags. If fsType is blank it is probed from the device, see Probe.
 func Mount(dev string, path string, fsType string, options []string) error {
 	var err error
 	var flags uintptr
 	var data []string
 
-	if fsType == "" {
-		return fmt.Errorf("no file system type provided")
-	}
-
 	for _, option := range options {
 		switch option {
 		case "loop":
@@ -53,6 +49,14 @@ func Mount(dev string, path string, fsType string, options []string) error {
 		}
 	}
 
+	if fsType == "" {
+		fs, err := ProbeFile(dev)
+		if err != nil {
+			return fmt.Errorf("no file system type provided, and probing failed: %w", err)
+		}
+		fsType = fs.Type
+	}
+
 	_, err = mount.Mount(dev, path, fsType, strings.Join(data, ","), flags)
 	return err
 }
@@ -0,0 +1,49 @@
+package mount
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mountinfoUnescape undoes the octal escaping of paths in mountinfo.
+var mountinfoUnescape = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// Source returns the source of the top most mount on path, eg. /dev/rbd0, from
+// /proc/self/mountinfo, or "" if path isn't a mount point.
+func Source(path string) (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return source(f, path)
+}
+
+// source is Source reading the mountinfo from r.
+func source(r io.Reader, path string) (string, error) {
+	path = filepath.Clean(path)
+	var src string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// 36 35 98:0 /mnt1 /mnt/parent rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(s.Text())
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || sep+2 >= len(fields) {
+			continue
+		}
+		// Later mounts are on top of earlier ones
+		if mountinfoUnescape.Replace(fields[4]) == path {
+			src = mountinfoUnescape.Replace(fields[sep+2])
+		}
+	}
+	return src, s.Err()
+}
@@ -0,0 +1,38 @@
+package mount
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_source(t *testing.T) {
+	const mountinfo = `22 1 0:21 / / rw,relatime - rootfs rootfs rw
+25 22 0:23 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+40 22 252:0 / /newroot ro,relatime - ext4 /dev/rbd0 ro
+41 40 7:3 / /newroot/srv\040data rw,relatime shared:20 master:1 - xfs /dev/loop3 rw
+42 22 252:16 / /mnt rw,relatime - ext4 /dev/rbd1p1 rw
+43 42 0:30 / /mnt rw,relatime - tmpfs tmpfs rw
+`
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "Mounted", path: "/newroot", want: "/dev/rbd0"},
+		{name: "Trailing slash", path: "/newroot/", want: "/dev/rbd0"},
+		{name: "Escaped space and optional fields", path: "/newroot/srv data", want: "/dev/loop3"},
+		{name: "Top most mount", path: "/mnt", want: "tmpfs"},
+		{name: "Not mounted", path: "/srv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := source(strings.NewReader(mountinfo), tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("source() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
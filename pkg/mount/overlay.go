@@ -1,18 +1,44 @@
 package mount
 
 import (
+	"errors"
 	"os"
+	"strings"
 )
 
-// Overlay prepares and mounts a R/W overlay on the dest path. Expects lower to already be
-// mounted. Create's dest, upper, and work directories. Upper and work is required to be paths
-// within the same filesystem.
-func Overlay(lower string, upper string, work string, dest string) error {
+// Overlay prepares and mounts a R/W overlay on the dest path of the lower
+// directories, the first being the top most layer. Expects lower to already be
+// mounted. Create's dest, upper, and work directories. Upper and work is required
+// to be paths within the same filesystem.
+func Overlay(lower []string, upper string, work string, dest string) error {
+	if len(lower) == 0 {
+		return errors.New("overlay without a lower directory")
+	}
 	for _, dir := range []string{upper, work, dest} {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return err
 		}
 	}
 
-	return Mount("overlay", dest, "overlay", []string{"lowerdir=" + lower, "upperdir=" + upper, "workdir=" + work})
+	return Mount("overlay", dest, "overlay", overlayOptions(lower, upper, work))
+}
+
+// overlayOptions returns the mount options of an overlay. Colons, which
+// separate the lower directories, are escaped within the paths.
+func overlayOptions(lower []string, upper string, work string) []string {
+	escape := strings.NewReplacer(`\`, `\\`, ":", `\:`).Replace
+	escaped := make([]string, len(lower))
+	for i, l := range lower {
+		escaped[i] = escape(l)
+	}
+	return []string{"lowerdir=" + strings.Join(escaped, ":"), "upperdir=" + escape(upper), "workdir=" + escape(work)}
+}
+
+// Tmpfs mounts a tmpfs on path, limited to size, eg. 2G or 50%, unless empty.
+func Tmpfs(path string, size string) error {
+	opts := []string{"mode=0755"}
+	if size != "" {
+		opts = append(opts, "size="+size)
+	}
+	return Mount("tmpfs", path, "tmpfs", opts)
 }
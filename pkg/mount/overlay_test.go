@@ -0,0 +1,37 @@
+package mount
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_overlayOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		lower []string
+		want  []string
+	}{
+		{
+			name:  "Single lower",
+			lower: []string{"/run/overlayfs/lower"},
+			want:  []string{"lowerdir=/run/overlayfs/lower", "upperdir=/run/overlayfs/rw/upper", "workdir=/run/overlayfs/rw/work"},
+		},
+		{
+			name:  "Stacked lowers",
+			lower: []string{"/run/overlayfs/lower", "/run/overlayfs/layers/apps", "/run/overlayfs/layers/base"},
+			want:  []string{"lowerdir=/run/overlayfs/lower:/run/overlayfs/layers/apps:/run/overlayfs/layers/base", "upperdir=/run/overlayfs/rw/upper", "workdir=/run/overlayfs/rw/work"},
+		},
+		{
+			name:  "Escaped colon",
+			lower: []string{"/run/a:b"},
+			want:  []string{`lowerdir=/run/a\:b`, "upperdir=/run/overlayfs/rw/upper", "workdir=/run/overlayfs/rw/work"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := overlayOptions(tt.lower, "/run/overlayfs/rw/upper", "/run/overlayfs/rw/work"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("overlayOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
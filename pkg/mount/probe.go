@@ -0,0 +1,194 @@
+package mount
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrUnknownFilesystem is returned by Probe when no supported filesystem is found.
+var ErrUnknownFilesystem = errors.New("unknown filesystem")
+
+// Filesystem is what was found by probing the superblock of a block device or
+// image file.
+type Filesystem struct {
+	// Type is the name of the filesystem as used by mount(2), eg. ext4.
+	Type string
+	// UUID in the format shown by blkid, eg. 4 bytes as ABCD-1234 for vfat.
+	// Empty if the filesystem doesn't have one.
+	UUID  string
+	Label string
+}
+
+// prober checks for a filesystem, returning false if sb doesn't hold its superblock.
+type prober struct {
+	name string
+	// offset and size of the superblock read for probe.
+	offset int64
+	size   int
+	probe  func(sb []byte, fs *Filesystem) bool
+}
+
+// probers are tried in order, the first match wins.
+var probers = []prober{
+	{name: "ext", offset: 1024, size: 1024, probe: probeExt},
+	{name: "xfs", offset: 0, size: 512, probe: probeXFS},
+	{name: "btrfs", offset: 0x10000, size: 4096, probe: probeBtrfs},
+	{name: "squashfs", offset: 0, size: 96, probe: probeSquashfs},
+	{name: "erofs", offset: 1024, size: 128, probe: probeErofs},
+	{name: "vfat", offset: 0, size: 512, probe: probeVfat},
+}
+
+// Probe identifies the filesystem of the block device or image file r by its
+// superblock. Supported are ext2, ext3, ext4, xfs, btrfs, squashfs, erofs, and vfat.
+func Probe(r io.ReaderAt) (Filesystem, error) {
+	for _, p := range probers {
+		sb := make([]byte, p.size)
+		if _, err := r.ReadAt(sb, p.offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				// Too small to hold this filesystem.
+				continue
+			}
+			return Filesystem{}, fmt.Errorf("reading %s superblock: %w", p.name, err)
+		}
+		var fs Filesystem
+		if p.probe(sb, &fs) {
+			return fs, nil
+		}
+	}
+	return Filesystem{}, ErrUnknownFilesystem
+}
+
+// ProbeFile is Probe of the block device or image file at path.
+func ProbeFile(path string) (Filesystem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Filesystem{}, err
+	}
+	defer f.Close()
+	fs, err := Probe(f)
+	if err != nil {
+		return Filesystem{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return fs, nil
+}
+
+// ext2/3/4 superblock, see https://www.kernel.org/doc/html/latest/filesystems/ext4/globals.html
+const (
+	extMagic = 0xef53
+
+	extCompatHasJournal = 0x4
+
+	// Features an ext3 filesystem may have, any others require ext4.
+	ext3IncompatSupported = 0x2 | 0x4 | 0x10 // filetype, recover, meta_bg
+	ext3ROCompatSupported = 0x1 | 0x2 | 0x4  // sparse_super, large_file, btree_dir
+)
+
+func probeExt(sb []byte, fs *Filesystem) bool {
+	if binary.LittleEndian.Uint16(sb[0x38:]) != extMagic {
+		return false
+	}
+	compat := binary.LittleEndian.Uint32(sb[0x5c:])
+	incompat := binary.LittleEndian.Uint32(sb[0x60:])
+	roCompat := binary.LittleEndian.Uint32(sb[0x64:])
+
+	switch {
+	case incompat&^ext3IncompatSupported != 0 || roCompat&^ext3ROCompatSupported != 0:
+		fs.Type = "ext4"
+	case compat&extCompatHasJournal != 0:
+		fs.Type = "ext3"
+	default:
+		fs.Type = "ext2"
+	}
+	fs.UUID = uuid(sb[0x68:0x78])
+	fs.Label = cString(sb[0x78:0x88])
+	return true
+}
+
+func probeXFS(sb []byte, fs *Filesystem) bool {
+	if !bytes.Equal(sb[0:4], []byte("XFSB")) {
+		return false
+	}
+	fs.Type = "xfs"
+	fs.UUID = uuid(sb[32:48])
+	fs.Label = cString(sb[108:120])
+	return true
+}
+
+func probeBtrfs(sb []byte, fs *Filesystem) bool {
+	if !bytes.Equal(sb[0x40:0x48], []byte("_BHRfS_M")) {
+		return false
+	}
+	fs.Type = "btrfs"
+	fs.UUID = uuid(sb[0x20:0x30])
+	fs.Label = cString(sb[0x12b:0x22b])
+	return true
+}
+
+func probeSquashfs(sb []byte, fs *Filesystem) bool {
+	if !bytes.Equal(sb[0:4], []byte("hsqs")) {
+		return false
+	}
+	fs.Type = "squashfs"
+	return true
+}
+
+const erofsMagic = 0xe0f5e1e2
+
+func probeErofs(sb []byte, fs *Filesystem) bool {
+	if binary.LittleEndian.Uint32(sb[0:]) != erofsMagic {
+		return false
+	}
+	fs.Type = "erofs"
+	fs.UUID = uuid(sb[48:64])
+	fs.Label = cString(sb[64:80])
+	return true
+}
+
+// probeVfat checks the boot sector for the FAT12/16 or FAT32 filesystem type
+// string, which unlike a MBR partition table it also has.
+func probeVfat(sb []byte, fs *Filesystem) bool {
+	if sb[510] != 0x55 || sb[511] != 0xaa {
+		return false
+	}
+	// The volume ID and label follow the extended boot signature, 0x29, whose
+	// offset differs between FAT12/16 and FAT32.
+	var ebs int
+	switch {
+	case bytes.HasPrefix(sb[0x36:], []byte("FAT1")):
+		ebs = 0x26
+	case bytes.HasPrefix(sb[0x52:], []byte("FAT32")):
+		ebs = 0x42
+	default:
+		return false
+	}
+	fs.Type = "vfat"
+	if sb[ebs] == 0x29 {
+		id := binary.LittleEndian.Uint32(sb[ebs+1:])
+		fs.UUID = fmt.Sprintf("%04X-%04X", id>>16, id&0xffff)
+		if label := strings.TrimRight(string(sb[ebs+5:ebs+16]), " "); label != "NO NAME" {
+			fs.Label = label
+		}
+	}
+	return true
+}
+
+// uuid formats a 16 byte UUID, or returns empty if b is all zeros.
+func uuid(b []byte) string {
+	if bytes.Equal(b, make([]byte, 16)) {
+		return ""
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// cString returns b up to the first NUL.
+func cString(b []byte) string {
+	if n := bytes.IndexByte(b, 0); n >= 0 {
+		b = b[:n]
+	}
+	return string(b)
+}
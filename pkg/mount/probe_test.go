@@ -0,0 +1,156 @@
+package mount
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// image returns a zeroed image of size bytes after applying set to it.
+func image(size int, set func(b []byte)) []byte {
+	b := make([]byte, size)
+	set(b)
+	return b
+}
+
+var testUUID = []byte{0x1b, 0x4e, 0x28, 0xba, 0x2f, 0xa1, 0x11, 0xd2, 0x88, 0x3f, 0x00, 0x16, 0xd3, 0xcc, 0x42, 0x7e}
+
+const testUUIDString = "1b4e28ba-2fa1-11d2-883f-0016d3cc427e"
+
+func extImage(compat, incompat, roCompat uint32) []byte {
+	return image(8192, func(b []byte) {
+		setExt(b, compat, incompat, roCompat, "")
+	})
+}
+
+// setExt writes an ext superblock with testUUID and label to the image b.
+func setExt(b []byte, compat, incompat, roCompat uint32, label string) {
+	sb := b[1024:]
+	binary.LittleEndian.PutUint16(sb[0x38:], extMagic)
+	binary.LittleEndian.PutUint32(sb[0x5c:], compat)
+	binary.LittleEndian.PutUint32(sb[0x60:], incompat)
+	binary.LittleEndian.PutUint32(sb[0x64:], roCompat)
+	copy(sb[0x68:], testUUID)
+	copy(sb[0x78:], label)
+}
+
+func TestProbeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		image   []byte
+		want    string
+		uuid    string
+		label   string
+		wantErr error
+	}{
+		{name: "ext2", image: extImage(0, 0x2, 0x1), want: "ext2", uuid: testUUIDString},
+		{name: "ext3", image: extImage(extCompatHasJournal, 0x2, 0x3), want: "ext3", uuid: testUUIDString},
+		// extents, 64bit, flex_bg and metadata_csum
+		{name: "ext4", image: extImage(extCompatHasJournal, 0x2c2, 0x46b), want: "ext4", uuid: testUUIDString},
+		{
+			name:  "ext4 with label",
+			image: image(8192, func(b []byte) { setExt(b, extCompatHasJournal, 0x2c2, 0x46b, "rootfs") }),
+			want:  "ext4",
+			uuid:  testUUIDString,
+			label: "rootfs",
+		},
+		{
+			name: "xfs",
+			image: image(4096, func(b []byte) {
+				copy(b, "XFSB")
+				copy(b[32:], testUUID)
+				copy(b[108:], "xfs-label123")
+			}),
+			want:  "xfs",
+			uuid:  testUUIDString,
+			label: "xfs-label123",
+		},
+		{
+			name: "btrfs",
+			image: image(0x11000, func(b []byte) {
+				copy(b[0x10040:], "_BHRfS_M")
+				copy(b[0x10020:], testUUID)
+				copy(b[0x1012b:], "data")
+			}),
+			want:  "btrfs",
+			uuid:  testUUIDString,
+			label: "data",
+		},
+		{name: "squashfs", image: image(4096, func(b []byte) { copy(b, "hsqs") }), want: "squashfs"},
+		{
+			name: "erofs",
+			image: image(4096, func(b []byte) {
+				binary.LittleEndian.PutUint32(b[1024:], erofsMagic)
+				copy(b[1024+48:], testUUID)
+				copy(b[1024+64:], "ro")
+			}),
+			want:  "erofs",
+			uuid:  testUUIDString,
+			label: "ro",
+		},
+		{
+			name: "vfat FAT16",
+			image: image(4096, func(b []byte) {
+				copy(b[0x36:], "FAT16   ")
+				b[0x26] = 0x29
+				binary.LittleEndian.PutUint32(b[0x27:], 0x1234abcd)
+				copy(b[0x2b:], "NO NAME    ")
+				b[510], b[511] = 0x55, 0xaa
+			}),
+			want: "vfat",
+			uuid: "1234-ABCD",
+		},
+		{
+			name: "vfat FAT32",
+			image: image(4096, func(b []byte) {
+				copy(b[0x52:], "FAT32   ")
+				b[0x42] = 0x29
+				binary.LittleEndian.PutUint32(b[0x43:], 0x0000ffff)
+				copy(b[0x47:], "EFI        ")
+				b[510], b[511] = 0x55, 0xaa
+			}),
+			want:  "vfat",
+			uuid:  "0000-FFFF",
+			label: "EFI",
+		},
+		{
+			name:    "MBR partition table",
+			image:   image(4096, func(b []byte) { b[510], b[511] = 0x55, 0xaa }),
+			wantErr: ErrUnknownFilesystem,
+		},
+		{
+			name:    "Empty",
+			image:   image(65536, func(b []byte) {}),
+			wantErr: ErrUnknownFilesystem,
+		},
+		{
+			name:    "Tiny",
+			image:   image(16, func(b []byte) {}),
+			wantErr: ErrUnknownFilesystem,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".img")
+			if err := ioutil.WriteFile(path, tt.image, 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ProbeFile(path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ProbeFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if want := (Filesystem{Type: tt.want, UUID: tt.uuid, Label: tt.label}); got != want {
+				t.Errorf("ProbeFile() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package netconf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// pollInterval is how often carrier is checked while waiting for it.
+var pollInterval = 100 * time.Millisecond
+
+// Configure brings up the interfaces of c via netlink, waits for their carrier,
+// and configures their addresses and routes or runs the DHCP client. The
+// hostname and nameservers are set last. Waiting for carrier and DHCP stop with
+// an error when ctx is done.
+func Configure(ctx context.Context, c *Config, opts Options) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	opts.defaults()
+
+	r, err := dialRtnl()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, i := range c.Interfaces {
+		if err := configure(ctx, r, i, opts); err != nil {
+			return err
+		}
+	}
+
+	if c.Hostname != "" {
+		opts.Logf("setting hostname %s", c.Hostname)
+		if err := unix.Sethostname([]byte(c.Hostname)); err != nil {
+			return fmt.Errorf("setting hostname: %w", err)
+		}
+	}
+	if len(c.DNS) != 0 {
+		var b bytes.Buffer
+		for _, ns := range c.DNS {
+			fmt.Fprintf(&b, "nameserver %s\n", ns)
+		}
+		if err := os.MkdirAll(filepath.Dir(opts.ResolvConf), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(opts.ResolvConf, b.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func configure(ctx context.Context, r *rtnl, i Interface, opts Options) error {
+	if i.Device == "" {
+		dev, err := firstCarrier(ctx, r, opts)
+		if err != nil {
+			return err
+		}
+		i.Device = dev
+	}
+
+	link, err := net.InterfaceByName(i.Device)
+	if err != nil {
+		return err
+	}
+	if i.VLAN != 0 {
+		// The VLAN interface only comes up once its parent is.
+		if err := r.setLinkUp(link.Index); err != nil {
+			return fmt.Errorf("%s: link up: %w", i.Device, err)
+		}
+		opts.Logf("creating vlan %s", i.Name())
+		if err := r.addVLAN(i.Name(), link.Index, i.VLAN); err != nil && err != unix.EEXIST {
+			return fmt.Errorf("%s: creating vlan: %w", i.Name(), err)
+		}
+		if link, err = net.InterfaceByName(i.Name()); err != nil {
+			return err
+		}
+	}
+
+	name := i.Name()
+	if i.MTU != 0 {
+		if err := r.setMTU(link.Index, i.MTU); err != nil {
+			return fmt.Errorf("%s: setting mtu: %w", name, err)
+		}
+	}
+	if err := r.setLinkUp(link.Index); err != nil {
+		return fmt.Errorf("%s: link up: %w", name, err)
+	}
+	opts.Logf("waiting for carrier on %s", name)
+	if err := waitCarrier(ctx, opts.SysRoot, name); err != nil {
+		return fmt.Errorf("%s: waiting for carrier: %w", name, err)
+	}
+
+	if i.DHCP {
+		opts.Logf("running %s on %s", opts.DHClient, name)
+		cmd := exec.CommandContext(ctx, opts.DHClient, "-ipv6=false", name)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %s: %w", name, opts.DHClient, err)
+		}
+		return nil
+	}
+
+	for _, a := range i.Addresses {
+		ip, ipnet, _ := net.ParseCIDR(a)
+		ipnet.IP = ip
+		opts.Logf("adding address %s to %s", a, name)
+		if err := r.addAddr(link.Index, ipnet); err != nil {
+			return fmt.Errorf("%s: adding address %s: %w", name, a, err)
+		}
+	}
+	for _, rt := range i.Routes {
+		_, dst, _ := net.ParseCIDR(rt.Destination)
+		opts.Logf("adding route %s via %s on %s", rt.Destination, rt.Gateway, name)
+		if err := r.addRoute(link.Index, dst, net.ParseIP(rt.Gateway)); err != nil {
+			return fmt.Errorf("%s: adding route %s: %w", name, rt.Destination, err)
+		}
+	}
+	if i.Gateway != "" {
+		opts.Logf("adding default route via %s on %s", i.Gateway, name)
+		if err := r.addRoute(link.Index, nil, net.ParseIP(i.Gateway)); err != nil {
+			return fmt.Errorf("%s: adding default route: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// firstCarrier brings up all interfaces other than loopback and returns the
+// first to have carrier, in the order of their index.
+func firstCarrier(ctx context.Context, r *rtnl, opts Options) (string, error) {
+	links, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, l := range links {
+		if l.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if err := r.setLinkUp(l.Index); err != nil {
+			return "", fmt.Errorf("%s: link up: %w", l.Name, err)
+		}
+		names = append(names, l.Name)
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no network interfaces found")
+	}
+	opts.Logf("waiting for carrier on any of %s", strings.Join(names, ", "))
+
+	var found string
+	err = poll(ctx, func() bool {
+		for _, name := range names {
+			if hasCarrier(opts.SysRoot, name) {
+				found = name
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return "", fmt.Errorf("waiting for carrier on any of %s: %w", strings.Join(names, ", "), err)
+	}
+	return found, nil
+}
+
+func waitCarrier(ctx context.Context, sysRoot string, name string) error {
+	return poll(ctx, func() bool { return hasCarrier(sysRoot, name) })
+}
+
+// hasCarrier reads /sys/class/net/<name>/carrier, which can only be read while
+// the interface is up.
+func hasCarrier(sysRoot string, name string) bool {
+	b, err := ioutil.ReadFile(filepath.Join(sysRoot, "class", "net", name, "carrier"))
+	return err == nil && strings.TrimSpace(string(b)) == "1"
+}
+
+// poll calls done every pollInterval until it returns true or ctx is done.
+func poll(ctx context.Context, done func() bool) error {
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+	for !done() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
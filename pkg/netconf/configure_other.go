@@ -0,0 +1,13 @@
+// +build !linux
+
+package netconf
+
+import (
+	"context"
+	"errors"
+)
+
+// Configure is only supported on Linux.
+func Configure(ctx context.Context, c *Config, opts Options) error {
+	return errors.New("network configuration is not supported on this platform")
+}
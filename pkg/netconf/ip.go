@@ -0,0 +1,115 @@
+package netconf
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseIP adds the configuration of the kernel ip= parameter value to c, see
+// https://www.kernel.org/doc/html/latest/admin-guide/nfs/nfsroot.html. The
+// supported forms are:
+//
+//	off, none                         no configuration
+//	on, any, dhcp, bootp, both        DHCP on the first interface with carrier
+//	<device>:<autoconf>               eg. eth0:dhcp, as supported by dracut
+//	<client-ip>:<server-ip>:<gw-ip>:<netmask>:<hostname>:<device>:<autoconf>:<dns0-ip>:<dns1-ip>:<ntp0-ip>
+//
+// Trailing fields of the last form may be left out. Without netmask the
+// classful netmask of client-ip is used, as the kernel does. server-ip and
+// ntp0-ip are ignored, RARP is treated as DHCP.
+func ParseIP(value string, c *Config) error {
+	fields := strings.Split(value, ":")
+	if len(fields) == 1 {
+		dhcp, err := autoconf(fields[0])
+		if err != nil {
+			return err
+		}
+		if dhcp {
+			c.Interfaces = append(c.Interfaces, Interface{DHCP: true})
+		}
+		return nil
+	}
+	if len(fields) == 2 {
+		// <device>:<autoconf>
+		dhcp, err := autoconf(fields[1])
+		if err != nil {
+			return err
+		}
+		if !dhcp {
+			return fmt.Errorf("%w: ip=%s: static configuration requires client-ip", ErrInvalid, value)
+		}
+		c.Interfaces = append(c.Interfaces, Interface{Device: fields[0], DHCP: true})
+		return nil
+	}
+	if len(fields) > 10 {
+		return fmt.Errorf("%w: ip=%s: too many fields", ErrInvalid, value)
+	}
+	fields = append(fields, make([]string, 10-len(fields))...)
+	clientIP, gatewayIP, netmask, hostname, device, auto := fields[0], fields[2], fields[3], fields[4], fields[5], fields[6]
+
+	i := Interface{Device: device}
+	dhcp, err := autoconf(auto)
+	if err != nil {
+		return err
+	}
+	switch {
+	case clientIP == "" && auto == "":
+		// The kernel defaults to autoconfiguration without a client-ip.
+		i.DHCP = true
+	case clientIP == "" && !dhcp:
+		return fmt.Errorf("%w: ip=%s: static configuration requires client-ip", ErrInvalid, value)
+	case clientIP != "":
+		ip := net.ParseIP(clientIP).To4()
+		if ip == nil {
+			return fmt.Errorf("%w: ip=%s: invalid client-ip %q", ErrInvalid, value, clientIP)
+		}
+		mask := ip.DefaultMask()
+		if netmask != "" {
+			m := net.ParseIP(netmask).To4()
+			if m == nil {
+				return fmt.Errorf("%w: ip=%s: invalid netmask %q", ErrInvalid, value, netmask)
+			}
+			mask = net.IPMask(m)
+		}
+		ones, bits := mask.Size()
+		if bits == 0 {
+			return fmt.Errorf("%w: ip=%s: non-contiguous netmask %q", ErrInvalid, value, netmask)
+		}
+		i.Addresses = []string{fmt.Sprintf("%s/%d", ip, ones)}
+		if gatewayIP != "" {
+			if net.ParseIP(gatewayIP) == nil {
+				return fmt.Errorf("%w: ip=%s: invalid gw-ip %q", ErrInvalid, value, gatewayIP)
+			}
+			i.Gateway = gatewayIP
+		}
+	default:
+		i.DHCP = true
+	}
+	c.Interfaces = append(c.Interfaces, i)
+
+	if hostname != "" {
+		c.Hostname = hostname
+	}
+	for _, dns := range fields[7:9] {
+		if dns == "" {
+			continue
+		}
+		if net.ParseIP(dns) == nil {
+			return fmt.Errorf("%w: ip=%s: invalid dns-ip %q", ErrInvalid, value, dns)
+		}
+		c.DNS = append(c.DNS, dns)
+	}
+	return nil
+}
+
+// autoconf returns whether the autoconf value of ip= selects DHCP.
+func autoconf(s string) (bool, error) {
+	switch s {
+	case "off", "none", "":
+		return false, nil
+	case "on", "any", "dhcp", "bootp", "rarp", "both":
+		return true, nil
+	}
+	return false, fmt.Errorf("%w: unknown autoconf %q", ErrInvalid, s)
+}
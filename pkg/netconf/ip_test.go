@@ -0,0 +1,68 @@
+package netconf
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Config
+		wantErr error
+	}{
+		{name: "dhcp", value: "dhcp", want: Config{Interfaces: []Interface{{DHCP: true}}}},
+		{name: "on", value: "on", want: Config{Interfaces: []Interface{{DHCP: true}}}},
+		{name: "off", value: "off"},
+		{name: "Device and autoconf", value: "ens1f0:dhcp", want: Config{Interfaces: []Interface{{Device: "ens1f0", DHCP: true}}}},
+		{
+			name:  "Static",
+			value: "192.168.0.10::192.168.0.1:255.255.255.0:node1:ens1f0:off:192.168.0.2:192.168.0.3",
+			want: Config{
+				Interfaces: []Interface{{Device: "ens1f0", Addresses: []string{"192.168.0.10/24"}, Gateway: "192.168.0.1"}},
+				Hostname:   "node1",
+				DNS:        []string{"192.168.0.2", "192.168.0.3"},
+			},
+		},
+		{
+			name:  "Static classful netmask",
+			value: "10.1.2.3:::::eth0",
+			want:  Config{Interfaces: []Interface{{Device: "eth0", Addresses: []string{"10.1.2.3/8"}}}},
+		},
+		{
+			name:  "DHCP on device with hostname",
+			value: ":::::eth1:dhcp",
+			want:  Config{Interfaces: []Interface{{Device: "eth1", DHCP: true}}},
+		},
+		{
+			name:  "No client-ip or autoconf",
+			value: "::::node1:eth1",
+			want:  Config{Interfaces: []Interface{{Device: "eth1", DHCP: true}}, Hostname: "node1"},
+		},
+		{name: "Unknown autoconf", value: "maybe", wantErr: ErrInvalid},
+		{name: "Static device without client-ip", value: "eth0:off", wantErr: ErrInvalid},
+		{name: "Invalid client-ip", value: "192.168.0.300:::::eth0:off", wantErr: ErrInvalid},
+		{name: "Invalid netmask", value: "192.168.0.10:::255.0.255.0::eth0:off", wantErr: ErrInvalid},
+		{name: "Too many fields", value: "::::::::::", wantErr: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Config
+			err := ParseIP(tt.value, &got)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseIP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseIP() = %+v, want %+v", got, tt.want)
+			}
+			if err := got.Validate(); err != nil {
+				t.Errorf("ParseIP() = %+v, Validate() error = %v", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Package netconf brings up the network in the initramfs, before images can be
+// mapped, from the kernel ip= parameter or a JSON configuration.
+package netconf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrInvalid is returned when a configuration can't be parsed or is incomplete.
+var ErrInvalid = errors.New("invalid network configuration")
+
+// DefaultDHClient is the DHCP client run for interfaces configured via DHCP,
+// see Options.DHClient.
+const DefaultDHClient = "/bbin/dhclient"
+
+// Config is the network configuration, eg. from the rbd.net JSON block:
+//
+//	rbd.net={"interfaces":[{"device":"ens1f0","vlan":100,"addresses":["192.168.0.10/24"],"gateway":"192.168.0.1"}],"dns":["192.168.0.1"]}
+type Config struct {
+	Interfaces []Interface `json:"interfaces"`
+	// Hostname is set if not empty.
+	Hostname string `json:"hostname"`
+	// DNS are the nameservers written to /etc/resolv.conf, if any.
+	DNS []string `json:"dns"`
+}
+
+// Interface is the configuration of a single network interface.
+type Interface struct {
+	// Device is the name of the interface, eg. eth0. When empty the first
+	// interface to have carrier is used.
+	Device string `json:"device"`
+	// VLAN, when not 0, is the ID of a VLAN interface, named <device>.<vlan>,
+	// that is created on Device and configured instead of it.
+	VLAN int `json:"vlan"`
+	MTU  int `json:"mtu"`
+	// DHCP runs the DHCP client on the interface instead of configuring
+	// Addresses, Gateway, and Routes.
+	DHCP bool `json:"dhcp"`
+	// Addresses in CIDR notation, eg. 192.168.0.10/24.
+	Addresses []string `json:"addresses"`
+	// Gateway is the default route, if not empty.
+	Gateway string  `json:"gateway"`
+	Routes  []Route `json:"routes"`
+}
+
+// Route to a network via a gateway, or directly via the interface if Gateway is empty.
+type Route struct {
+	// Destination in CIDR notation, eg. 10.0.0.0/8.
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway"`
+}
+
+// Name returns the name of the interface to configure, the VLAN interface if
+// VLAN is set.
+func (i Interface) Name() string {
+	if i.VLAN != 0 {
+		return fmt.Sprintf("%s.%d", i.Device, i.VLAN)
+	}
+	return i.Device
+}
+
+// Validate checks that the addresses of the configuration parse and that every
+// interface is either configured via DHCP or has an address.
+func (c *Config) Validate() error {
+	for n, i := range c.Interfaces {
+		name := i.Device
+		if name == "" {
+			name = fmt.Sprintf("interfaces[%d]", n)
+		}
+		if err := i.validate(); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalid, name, err)
+		}
+	}
+	for _, s := range c.DNS {
+		if net.ParseIP(s) == nil {
+			return fmt.Errorf("%w: dns: invalid address %q", ErrInvalid, s)
+		}
+	}
+	return nil
+}
+
+func (i Interface) validate() error {
+	if i.VLAN < 0 || i.VLAN > 4094 {
+		return fmt.Errorf("vlan %d not within 1-4094", i.VLAN)
+	}
+	if i.VLAN != 0 && i.Device == "" {
+		return errors.New("vlan requires device")
+	}
+	if i.MTU < 0 {
+		return fmt.Errorf("invalid mtu %d", i.MTU)
+	}
+	if i.DHCP {
+		if len(i.Addresses) != 0 || i.Gateway != "" || len(i.Routes) != 0 {
+			return errors.New("addresses, gateway, and routes are set by DHCP")
+		}
+		return nil
+	}
+	if len(i.Addresses) == 0 {
+		return errors.New("either dhcp or addresses is required")
+	}
+	for _, a := range i.Addresses {
+		if _, _, err := net.ParseCIDR(a); err != nil {
+			return err
+		}
+	}
+	if i.Gateway != "" && net.ParseIP(i.Gateway) == nil {
+		return fmt.Errorf("invalid gateway %q", i.Gateway)
+	}
+	for _, r := range i.Routes {
+		if _, _, err := net.ParseCIDR(r.Destination); err != nil {
+			return err
+		}
+		if r.Gateway != "" && net.ParseIP(r.Gateway) == nil {
+			return fmt.Errorf("invalid gateway %q", r.Gateway)
+		}
+	}
+	return nil
+}
+
+// Options of Configure.
+type Options struct {
+	// DHClient is the DHCP client run as DHClient -ipv6=false <interface>,
+	// DefaultDHClient if empty.
+	DHClient string
+	// SysRoot is where sysfs is mounted, /sys if empty.
+	SysRoot string
+	// ResolvConf is the file the nameservers are written to, /etc/resolv.conf
+	// if empty.
+	ResolvConf string
+	// Logf, if not nil, is called with each step taken.
+	Logf func(format string, v ...interface{})
+}
+
+func (o *Options) defaults() {
+	if o.DHClient == "" {
+		o.DHClient = DefaultDHClient
+	}
+	if o.SysRoot == "" {
+		o.SysRoot = "/sys"
+	}
+	if o.ResolvConf == "" {
+		o.ResolvConf = "/etc/resolv.conf"
+	}
+	if o.Logf == nil {
+		o.Logf = func(string, ...interface{}) {}
+	}
+}
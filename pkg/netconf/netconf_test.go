@@ -0,0 +1,51 @@
+package netconf
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr bool
+	}{
+		{name: "Static with vlan", json: `{"interfaces":[{"device":"ens1f0","vlan":100,"mtu":9000,"addresses":["192.168.0.10/24","fd00::10/64"],"gateway":"192.168.0.1","routes":[{"destination":"10.0.0.0/8","gateway":"192.168.0.254"}]}],"dns":["192.168.0.1"]}`},
+		{name: "DHCP on first with carrier", json: `{"interfaces":[{"dhcp":true}]}`},
+		{name: "Empty", json: `{}`},
+		{name: "No address", json: `{"interfaces":[{"device":"eth0"}]}`, wantErr: true},
+		{name: "DHCP with address", json: `{"interfaces":[{"device":"eth0","dhcp":true,"addresses":["192.168.0.10/24"]}]}`, wantErr: true},
+		{name: "Address without prefix", json: `{"interfaces":[{"device":"eth0","addresses":["192.168.0.10"]}]}`, wantErr: true},
+		{name: "VLAN without device", json: `{"interfaces":[{"vlan":100,"dhcp":true}]}`, wantErr: true},
+		{name: "VLAN out of range", json: `{"interfaces":[{"device":"eth0","vlan":4095,"dhcp":true}]}`, wantErr: true},
+		{name: "Invalid gateway", json: `{"interfaces":[{"device":"eth0","addresses":["192.168.0.10/24"],"gateway":"router"}]}`, wantErr: true},
+		{name: "Invalid route", json: `{"interfaces":[{"device":"eth0","addresses":["192.168.0.10/24"],"routes":[{"destination":"default"}]}]}`, wantErr: true},
+		{name: "Invalid DNS", json: `{"dns":["ns1"]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Config
+			if err := json.Unmarshal([]byte(tt.json), &c); err != nil {
+				t.Fatal(err)
+			}
+			err := c.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalid) {
+				t.Errorf("Config.Validate() error = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestInterface_Name(t *testing.T) {
+	if got := (Interface{Device: "ens1f0", VLAN: 100}).Name(); got != "ens1f0.100" {
+		t.Errorf("Interface.Name() = %s, want ens1f0.100", got)
+	}
+	if got := (Interface{Device: "ens1f0"}).Name(); got != "ens1f0" {
+		t.Errorf("Interface.Name() = %s, want ens1f0", got)
+	}
+}
@@ -0,0 +1,206 @@
+package netconf
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// attr is a netlink route attribute, with either data or nested attributes.
+type attr struct {
+	typ    uint16
+	data   []byte
+	nested []attr
+}
+
+// encode returns the attribute padded to a multiple of 4 bytes.
+func (a attr) encode() []byte {
+	data := a.data
+	if a.nested != nil {
+		data = encodeAttrs(a.nested)
+	}
+	b := make([]byte, rtaAlign(unix.SizeofRtAttr+len(data)))
+	*(*unix.RtAttr)(unsafe.Pointer(&b[0])) = unix.RtAttr{Len: uint16(unix.SizeofRtAttr + len(data)), Type: a.typ}
+	copy(b[unix.SizeofRtAttr:], data)
+	return b
+}
+
+func encodeAttrs(attrs []attr) []byte {
+	var b []byte
+	for _, a := range attrs {
+		b = append(b, a.encode()...)
+	}
+	return b
+}
+
+func rtaAlign(n int) int {
+	return (n + unix.RTA_ALIGNTO - 1) &^ (unix.RTA_ALIGNTO - 1)
+}
+
+// uint16Attr and uint32Attr are attributes with a value in host byte order.
+func uint16Attr(typ uint16, v uint16) attr {
+	b := make([]byte, 2)
+	*(*uint16)(unsafe.Pointer(&b[0])) = v
+	return attr{typ: typ, data: b}
+}
+
+func uint32Attr(typ uint16, v uint32) attr {
+	b := make([]byte, 4)
+	*(*uint32)(unsafe.Pointer(&b[0])) = v
+	return attr{typ: typ, data: b}
+}
+
+// stringAttr is a NUL terminated string attribute.
+func stringAttr(typ uint16, s string) attr {
+	return attr{typ: typ, data: append([]byte(s), 0)}
+}
+
+// ipAttr is an address attribute, 4 bytes for IPv4.
+func ipAttr(typ uint16, ip net.IP) attr {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return attr{typ: typ, data: []byte(ip)}
+}
+
+// family returns the address family of ip.
+func family(ip net.IP) uint8 {
+	if ip.To4() != nil {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+// structBytes returns a copy of the size bytes at p, the fixed size header of a
+// message, eg. a unix.IfInfomsg.
+func structBytes(p unsafe.Pointer, size int) []byte {
+	b := make([]byte, size)
+	copy(b, (*[1 << 16]byte)(p)[:size:size])
+	return b
+}
+
+// rtnl is a NETLINK_ROUTE socket.
+type rtnl struct {
+	fd  int
+	seq uint32
+}
+
+func dialRtnl() (*rtnl, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("netlink bind: %w", err)
+	}
+	return &rtnl{fd: fd}, nil
+}
+
+func (r *rtnl) Close() error {
+	return unix.Close(r.fd)
+}
+
+// request sends a message of typ with the header hdr, eg. an unix.IfInfomsg,
+// followed by attrs and waits for the kernel to acknowledge it.
+func (r *rtnl) request(typ uint16, flags uint16, hdr []byte, attrs ...attr) error {
+	r.seq++
+	body := append(hdr, encodeAttrs(attrs)...)
+	msg := make([]byte, unix.NLMSG_HDRLEN+len(body))
+	*(*unix.NlMsghdr)(unsafe.Pointer(&msg[0])) = unix.NlMsghdr{
+		Len:   uint32(len(msg)),
+		Type:  typ,
+		Flags: flags | unix.NLM_F_REQUEST | unix.NLM_F_ACK,
+		Seq:   r.seq,
+	}
+	copy(msg[unix.NLMSG_HDRLEN:], body)
+	if err := unix.Sendto(r.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 8192)
+	for {
+		n, _, err := unix.Recvfrom(r.fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != r.seq || m.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return fmt.Errorf("short netlink error message")
+			}
+			if errno := *(*int32)(unsafe.Pointer(&m.Data[0])); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+// setLinkUp brings up the interface index.
+func (r *rtnl) setLinkUp(index int) error {
+	info := unix.IfInfomsg{Family: unix.AF_UNSPEC, Index: int32(index), Flags: unix.IFF_UP, Change: unix.IFF_UP}
+	return r.request(unix.RTM_NEWLINK, 0, structBytes(unsafe.Pointer(&info), unix.SizeofIfInfomsg))
+}
+
+// setMTU sets the MTU of the interface index.
+func (r *rtnl) setMTU(index int, mtu int) error {
+	info := unix.IfInfomsg{Family: unix.AF_UNSPEC, Index: int32(index)}
+	return r.request(unix.RTM_NEWLINK, 0, structBytes(unsafe.Pointer(&info), unix.SizeofIfInfomsg), uint32Attr(unix.IFLA_MTU, uint32(mtu)))
+}
+
+// addVLAN creates the VLAN interface name with id on the interface parent.
+func (r *rtnl) addVLAN(name string, parent int, id int) error {
+	info := unix.IfInfomsg{Family: unix.AF_UNSPEC}
+	return r.request(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, structBytes(unsafe.Pointer(&info), unix.SizeofIfInfomsg),
+		stringAttr(unix.IFLA_IFNAME, name),
+		uint32Attr(unix.IFLA_LINK, uint32(parent)),
+		attr{typ: unix.IFLA_LINKINFO, nested: []attr{
+			{typ: unix.IFLA_INFO_KIND, data: []byte("vlan")},
+			{typ: unix.IFLA_INFO_DATA, nested: []attr{uint16Attr(unix.IFLA_VLAN_ID, uint16(id))}},
+		}},
+	)
+}
+
+// addAddr adds the address to the interface index, replacing it if present.
+func (r *rtnl) addAddr(index int, addr *net.IPNet) error {
+	ones, _ := addr.Mask.Size()
+	msg := unix.IfAddrmsg{Family: family(addr.IP), Prefixlen: uint8(ones), Scope: unix.RT_SCOPE_UNIVERSE, Index: uint32(index)}
+	return r.request(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, structBytes(unsafe.Pointer(&msg), unix.SizeofIfAddrmsg),
+		ipAttr(unix.IFA_LOCAL, addr.IP),
+		ipAttr(unix.IFA_ADDRESS, addr.IP),
+	)
+}
+
+// addRoute adds a route to dst via gw, or directly via the interface index if
+// gw is nil. A nil dst is the default route.
+func (r *rtnl) addRoute(index int, dst *net.IPNet, gw net.IP) error {
+	msg := unix.RtMsg{
+		Table:    unix.RT_TABLE_MAIN,
+		Protocol: unix.RTPROT_BOOT,
+		Scope:    unix.RT_SCOPE_UNIVERSE,
+		Type:     unix.RTN_UNICAST,
+	}
+	attrs := []attr{uint32Attr(unix.RTA_OIF, uint32(index))}
+	if dst != nil {
+		ones, _ := dst.Mask.Size()
+		msg.Family, msg.Dst_len = family(dst.IP), uint8(ones)
+		attrs = append(attrs, ipAttr(unix.RTA_DST, dst.IP))
+	}
+	if gw != nil {
+		msg.Family = family(gw)
+		attrs = append(attrs, ipAttr(unix.RTA_GATEWAY, gw))
+	} else {
+		msg.Scope = unix.RT_SCOPE_LINK
+	}
+	return r.request(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, structBytes(unsafe.Pointer(&msg), unix.SizeofRtMsg), attrs...)
+}
@@ -0,0 +1,92 @@
+package netconf
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAttr_encode(t *testing.T) {
+	// Lengths and types are in host byte order, these tests assume little endian.
+	tests := []struct {
+		name string
+		attr attr
+		want []byte
+	}{
+		{
+			name: "Padded string",
+			attr: stringAttr(unix.IFLA_IFNAME, "eth0.1"),
+			want: []byte{11, 0, unix.IFLA_IFNAME, 0, 'e', 't', 'h', '0', '.', '1', 0, 0},
+		},
+		{
+			name: "IPv4",
+			attr: ipAttr(unix.IFA_LOCAL, net.ParseIP("192.168.0.10")),
+			want: []byte{8, 0, unix.IFA_LOCAL, 0, 192, 168, 0, 10},
+		},
+		{
+			name: "Nested",
+			attr: attr{typ: unix.IFLA_LINKINFO, nested: []attr{
+				{typ: unix.IFLA_INFO_KIND, data: []byte("vlan")},
+				{typ: unix.IFLA_INFO_DATA, nested: []attr{uint16Attr(unix.IFLA_VLAN_ID, 100)}},
+			}},
+			want: []byte{
+				24, 0, unix.IFLA_LINKINFO, 0,
+				8, 0, unix.IFLA_INFO_KIND, 0, 'v', 'l', 'a', 'n',
+				12, 0, unix.IFLA_INFO_DATA, 0,
+				6, 0, unix.IFLA_VLAN_ID, 0, 100, 0, 0, 0,
+			},
+		},
+	}
+	one := uint16(1)
+	if *(*byte)(unsafe.Pointer(&one)) != 1 {
+		t.Skip("big endian host")
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.attr.encode(); !bytes.Equal(got, tt.want) {
+				t.Errorf("attr.encode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitCarrier(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = 10 * time.Millisecond
+
+	root, err := ioutil.TempDir("", "netconf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "class", "net", "eth0")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "carrier"), []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := waitCarrier(ctx, root, "eth0"); err != context.DeadlineExceeded {
+		t.Errorf("waitCarrier() error = %v, want deadline exceeded without carrier", err)
+	}
+
+	time.AfterFunc(20*time.Millisecond, func() {
+		ioutil.WriteFile(filepath.Join(dir, "carrier"), []byte("1\n"), 0644)
+	})
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := waitCarrier(ctx, root, "eth0"); err != nil {
+		t.Errorf("waitCarrier() error = %v", err)
+	}
+}
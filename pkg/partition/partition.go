@@ -0,0 +1,335 @@
+// Package partition reads GPT and MBR partition tables directly from a disk,
+// for when the kernel hasn't created partition devices, eg. a RBD device
+// mapped without partition scanning.
+package partition
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	// ErrNoTable is returned when neither a GPT nor a MBR partition table is found.
+	ErrNoTable = errors.New("no partition table found")
+	// ErrNotFound is returned when no partition matches.
+	ErrNotFound = errors.New("partition not found")
+)
+
+// Partition is an entry of a partition table. Start and Size are in bytes.
+type Partition struct {
+	// Number as used by the kernel in device names, eg. 1 for /dev/rbd0p1.
+	Number int
+	Start  int64
+	Size   int64
+	// Type is the GPT partition type GUID, or the hex MBR partition type, eg. 83.
+	Type string
+	// Label is the GPT partition name, empty for MBR.
+	Label string
+	// UUID is the GPT unique partition GUID, or for MBR the disk signature and
+	// partition number as the kernel's PARTUUID, eg. 0c3a8d6f-01.
+	UUID string
+}
+
+// Table is a partition table read from a disk.
+type Table struct {
+	// Scheme is "gpt" or "mbr".
+	Scheme     string
+	Partitions []Partition
+}
+
+const (
+	mbrSize      = 512
+	mbrSignature = 0x55aa
+
+	mbrTypeGPT = 0xee
+
+	gptSignature = "EFI PART"
+)
+
+// sectorSizes tried for a GPT header, the header is at LBA 1.
+var sectorSizes = []int64{512, 4096}
+
+// Read the partition table of the disk r, preferring GPT over the
+// protective or hybrid MBR.
+func Read(r io.ReaderAt) (*Table, error) {
+	mbr := make([]byte, mbrSize)
+	if _, err := r.ReadAt(mbr, 0); err != nil {
+		return nil, fmt.Errorf("reading MBR: %w", err)
+	}
+	if binary.BigEndian.Uint16(mbr[510:]) != mbrSignature {
+		return nil, ErrNoTable
+	}
+	for _, ss := range sectorSizes {
+		t, err := readGPT(r, ss)
+		if err == nil {
+			return t, nil
+		}
+		if !errors.Is(err, ErrNoTable) {
+			return nil, err
+		}
+	}
+	return readMBR(r, mbr)
+}
+
+// Find returns the partition matching spec, which is one of:
+//
+//	<number>              the partition number, eg. 1
+//	PARTUUID=<uuid>       the GPT partition GUID, or MBR PARTUUID
+//	PARTLABEL=<label>     the GPT partition name
+//	<uuid>                same as PARTUUID=
+//	<label>               same as PARTLABEL=
+func (t *Table) Find(spec string) (Partition, error) {
+	match := func(p Partition) bool { return p.Label == spec }
+	switch {
+	case strings.HasPrefix(spec, "PARTUUID="):
+		uuid := strings.ToLower(strings.TrimPrefix(spec, "PARTUUID="))
+		match = func(p Partition) bool { return p.UUID == uuid }
+	case strings.HasPrefix(spec, "PARTLABEL="):
+		label := strings.TrimPrefix(spec, "PARTLABEL=")
+		match = func(p Partition) bool { return p.Label == label }
+	default:
+		if n, err := strconv.Atoi(spec); err == nil {
+			match = func(p Partition) bool { return p.Number == n }
+		} else if isUUID(spec) {
+			uuid := strings.ToLower(spec)
+			match = func(p Partition) bool { return p.UUID == uuid }
+		}
+	}
+	for _, p := range t.Partitions {
+		if match(p) {
+			return p, nil
+		}
+	}
+	return Partition{}, fmt.Errorf("%w: %s", ErrNotFound, spec)
+}
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// readGPT reads the GPT with the primary header at LBA 1. Like the kernel, the
+// backup header at the last LBA is used instead when the primary header or its
+// partition entries are corrupt, if the size of r can be told.
+func readGPT(r io.ReaderAt, sectorSize int64) (*Table, error) {
+	t, err := readGPTAt(r, 1, sectorSize)
+	if err == nil {
+		return t, nil
+	}
+	size, ok := diskSize(r)
+	if !ok || size/sectorSize < 3 {
+		return nil, err
+	}
+	backup, berr := readGPTAt(r, size/sectorSize-1, sectorSize)
+	switch {
+	case berr == nil:
+		return backup, nil
+	case errors.Is(err, ErrNoTable) || errors.Is(berr, ErrNoTable):
+		return nil, err
+	}
+	return nil, fmt.Errorf("%v, backup %v", err, berr)
+}
+
+// readGPTAt reads the GPT with the header at lba, and its partition entries.
+func readGPTAt(r io.ReaderAt, lba int64, sectorSize int64) (*Table, error) {
+	hdr := make([]byte, 92)
+	if _, err := r.ReadAt(hdr, lba*sectorSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, ErrNoTable
+		}
+		return nil, fmt.Errorf("reading GPT header: %w", err)
+	}
+	if string(hdr[:8]) != gptSignature {
+		return nil, ErrNoTable
+	}
+
+	hdrSize := binary.LittleEndian.Uint32(hdr[12:])
+	if hdrSize < 92 || int64(hdrSize) > sectorSize {
+		return nil, fmt.Errorf("invalid GPT header size %d", hdrSize)
+	}
+	full := make([]byte, hdrSize)
+	if _, err := r.ReadAt(full, lba*sectorSize); err != nil {
+		return nil, fmt.Errorf("reading GPT header: %w", err)
+	}
+	want := binary.LittleEndian.Uint32(full[16:])
+	binary.LittleEndian.PutUint32(full[16:], 0)
+	if crc32.ChecksumIEEE(full) != want {
+		return nil, fmt.Errorf("GPT header at LBA %d checksum mismatch", lba)
+	}
+	// A header copied elsewhere, eg. the backup of a disk that has since grown
+	if my := binary.LittleEndian.Uint64(hdr[24:]); my != uint64(lba) {
+		return nil, fmt.Errorf("GPT header at LBA %d is for LBA %d", lba, my)
+	}
+
+	entriesLBA := int64(binary.LittleEndian.Uint64(hdr[72:]))
+	count := binary.LittleEndian.Uint32(hdr[80:])
+	entrySize := binary.LittleEndian.Uint32(hdr[84:])
+	if entrySize < 128 || count > 1024 {
+		return nil, fmt.Errorf("invalid GPT partition entries, %d of size %d", count, entrySize)
+	}
+	entries := make([]byte, int(count)*int(entrySize))
+	if _, err := r.ReadAt(entries, entriesLBA*sectorSize); err != nil {
+		return nil, fmt.Errorf("reading GPT partition entries: %w", err)
+	}
+	if crc32.ChecksumIEEE(entries) != binary.LittleEndian.Uint32(hdr[88:]) {
+		return nil, fmt.Errorf("GPT partition entries at LBA %d checksum mismatch", entriesLBA)
+	}
+
+	t := &Table{Scheme: "gpt"}
+	zero := make([]byte, 16)
+	for i := 0; i < int(count); i++ {
+		e := entries[i*int(entrySize) : (i+1)*int(entrySize)]
+		if bytes.Equal(e[:16], zero) {
+			continue
+		}
+		first := int64(binary.LittleEndian.Uint64(e[32:]))
+		last := int64(binary.LittleEndian.Uint64(e[40:]))
+		t.Partitions = append(t.Partitions, Partition{
+			Number: i + 1,
+			Start:  first * sectorSize,
+			Size:   (last - first + 1) * sectorSize,
+			Type:   guid(e[0:16]),
+			UUID:   guid(e[16:32]),
+			Label:  utf16String(e[56:128]),
+		})
+	}
+	return t, nil
+}
+
+// diskSize returns the size of r in bytes, eg. of a bytes.Reader or a block
+// device opened as an *os.File, if it can be told.
+func diskSize(r io.ReaderAt) (int64, bool) {
+	switch s := r.(type) {
+	case interface{ Size() int64 }:
+		return s.Size(), true
+	case io.Seeker:
+		n, err := s.Seek(0, io.SeekEnd)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// guid formats a mixed endian GUID as stored on disk.
+func guid(b []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b[0:]),
+		binary.LittleEndian.Uint16(b[4:]),
+		binary.LittleEndian.Uint16(b[6:]),
+		b[8:10], b[10:16])
+}
+
+func utf16String(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// mbrEntry is a partition entry of a MBR or EBR, with the start in sectors.
+type mbrEntry struct {
+	typ     byte
+	start   int64
+	sectors int64
+}
+
+func mbrEntries(sector []byte) []mbrEntry {
+	entries := make([]mbrEntry, 4)
+	for i := range entries {
+		e := sector[446+16*i:]
+		entries[i] = mbrEntry{
+			typ:     e[4],
+			start:   int64(binary.LittleEndian.Uint32(e[8:])),
+			sectors: int64(binary.LittleEndian.Uint32(e[12:])),
+		}
+	}
+	return entries
+}
+
+func isExtended(typ byte) bool {
+	return typ == 0x05 || typ == 0x0f || typ == 0x85
+}
+
+func readMBR(r io.ReaderAt, mbr []byte) (*Table, error) {
+	const sectorSize = mbrSize
+	sig := binary.LittleEndian.Uint32(mbr[440:])
+	t := &Table{Scheme: "mbr"}
+	add := func(n int, e mbrEntry, start int64) {
+		t.Partitions = append(t.Partitions, Partition{
+			Number: n,
+			Start:  start * sectorSize,
+			Size:   e.sectors * sectorSize,
+			Type:   fmt.Sprintf("%02x", e.typ),
+			UUID:   fmt.Sprintf("%08x-%02x", sig, n),
+		})
+	}
+
+	for i, e := range mbrEntries(mbr) {
+		switch {
+		case e.typ == 0:
+			continue
+		case e.typ == mbrTypeGPT:
+			return nil, errors.New("protective MBR found but no valid GPT")
+		case isExtended(e.typ):
+			if err := readEBRs(r, e.start, add); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		add(i+1, e, e.start)
+	}
+	return t, nil
+}
+
+// maxLogical bounds the EBR chain to guard against loops.
+const maxLogical = 128
+
+// readEBRs follows the chain of extended boot records of the extended partition
+// starting at sector ext, logical partitions are numbered from 5.
+func readEBRs(r io.ReaderAt, ext int64, add func(int, mbrEntry, int64)) error {
+	ebr := make([]byte, mbrSize)
+	next := int64(0)
+	for n := 5; n < 5+maxLogical; n++ {
+		at := ext + next
+		if _, err := r.ReadAt(ebr, at*mbrSize); err != nil {
+			return fmt.Errorf("reading EBR: %w", err)
+		}
+		if binary.BigEndian.Uint16(ebr[510:]) != mbrSignature {
+			return fmt.Errorf("invalid EBR signature at sector %d", at)
+		}
+		entries := mbrEntries(ebr)
+		if entries[0].typ != 0 {
+			add(n, entries[0], at+entries[0].start)
+		}
+		if !isExtended(entries[1].typ) || entries[1].start == 0 {
+			return nil
+		}
+		next = entries[1].start
+	}
+	return errors.New("too many logical partitions")
+}
@@ -0,0 +1,243 @@
+package partition
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+// errAny matches any error in tests.
+var errAny = errors.New("any error")
+
+// putGUID writes the canonical form of a GUID in the mixed endian on disk format.
+func putGUID(b []byte, a uint32, b1, c uint16, d [8]byte) {
+	binary.LittleEndian.PutUint32(b[0:], a)
+	binary.LittleEndian.PutUint16(b[4:], b1)
+	binary.LittleEndian.PutUint16(b[6:], c)
+	copy(b[8:], d[:])
+}
+
+// gptDisk returns a 1MiB disk with a GPT of two partitions using sectorSize,
+// with the primary header at LBA 1 and the backup header at the last LBA.
+func gptDisk(sectorSize int64) []byte {
+	disk := make([]byte, 1<<20)
+	binary.BigEndian.PutUint16(disk[510:], mbrSignature)
+	disk[446+4] = mbrTypeGPT
+
+	entries := make([]byte, 128*128)
+	// Linux filesystem data
+	putGUID(entries[0:], 0x0fc63daf, 0x8483, 0x4772, [8]byte{0x8e, 0x79, 0x3d, 0x69, 0xd8, 0x47, 0x7d, 0xe4})
+	putGUID(entries[16:], 0x6a4f2b1c, 0x1d2e, 0x4f3a, [8]byte{0x9b, 0x8c, 0x7d, 0x6e, 0x5f, 0x4a, 0x3b, 0x2c})
+	binary.LittleEndian.PutUint64(entries[32:], 34)
+	binary.LittleEndian.PutUint64(entries[40:], 99)
+	for i, c := range utf16.Encode([]rune("boot")) {
+		binary.LittleEndian.PutUint16(entries[56+2*i:], c)
+	}
+	// Second entry left empty, third used
+	e := entries[256:]
+	putGUID(e[0:], 0x0fc63daf, 0x8483, 0x4772, [8]byte{0x8e, 0x79, 0x3d, 0x69, 0xd8, 0x47, 0x7d, 0xe4})
+	putGUID(e[16:], 0x11111111, 0x2222, 0x3333, [8]byte{0x44, 0x44, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55})
+	binary.LittleEndian.PutUint64(e[32:], 100)
+	binary.LittleEndian.PutUint64(e[40:], 199)
+	for i, c := range utf16.Encode([]rune("root")) {
+		binary.LittleEndian.PutUint16(e[56+2*i:], c)
+	}
+
+	last := int64(len(disk))/sectorSize - 1
+	putGPTHeader(disk, sectorSize, 1, last, 2, entries)
+	putGPTHeader(disk, sectorSize, last, 1, last-int64(len(entries))/sectorSize, entries)
+	return disk
+}
+
+// putGPTHeader writes a GPT header at lba with its partition entries at
+// entriesLBA.
+func putGPTHeader(disk []byte, sectorSize int64, lba int64, alternate int64, entriesLBA int64, entries []byte) {
+	copy(disk[entriesLBA*sectorSize:], entries)
+	hdr := disk[lba*sectorSize : lba*sectorSize+92]
+	copy(hdr, gptSignature)
+	binary.LittleEndian.PutUint32(hdr[8:], 0x00010000)
+	binary.LittleEndian.PutUint32(hdr[12:], 92)
+	binary.LittleEndian.PutUint64(hdr[24:], uint64(lba))
+	binary.LittleEndian.PutUint64(hdr[32:], uint64(alternate))
+	binary.LittleEndian.PutUint64(hdr[72:], uint64(entriesLBA))
+	binary.LittleEndian.PutUint32(hdr[80:], 128)
+	binary.LittleEndian.PutUint32(hdr[84:], 128)
+	binary.LittleEndian.PutUint32(hdr[88:], crc32.ChecksumIEEE(entries))
+	binary.LittleEndian.PutUint32(hdr[16:], 0)
+	binary.LittleEndian.PutUint32(hdr[16:], crc32.ChecksumIEEE(hdr))
+}
+
+// gptDiskWith returns gptDisk(512) after applying each change to it.
+func gptDiskWith(changes ...func(disk []byte)) []byte {
+	disk := gptDisk(512)
+	for _, c := range changes {
+		c(disk)
+	}
+	return disk
+}
+
+// Changes of the GPT of gptDisk(512), which has its backup header at LBA 2047
+// and the backup partition entries at LBA 2015.
+var (
+	corruptPrimary        = func(disk []byte) { disk[512+32] ^= 0xff }
+	corruptPrimaryEntries = func(disk []byte) { disk[2*512+60] ^= 0xff }
+	corruptBackup         = func(disk []byte) { disk[2047*512+32] ^= 0xff }
+	corruptBackupEntries  = func(disk []byte) { disk[2015*512+60] ^= 0xff }
+	// The backup header is for another LBA, eg. copied from a smaller disk
+	misplaceBackup = func(disk []byte) {
+		hdr := disk[2047*512 : 2047*512+92]
+		binary.LittleEndian.PutUint64(hdr[24:], 2040)
+		binary.LittleEndian.PutUint32(hdr[16:], 0)
+		binary.LittleEndian.PutUint32(hdr[16:], crc32.ChecksumIEEE(hdr))
+	}
+)
+
+// mbrDisk returns a 1MiB disk with a MBR of a primary and an extended
+// partition containing two logical partitions.
+func mbrDisk() []byte {
+	disk := make([]byte, 1<<20)
+	entry := func(sector []byte, i int, typ byte, start, sectors uint32) {
+		e := sector[446+16*i:]
+		e[4] = typ
+		binary.LittleEndian.PutUint32(e[8:], start)
+		binary.LittleEndian.PutUint32(e[12:], sectors)
+		binary.BigEndian.PutUint16(sector[510:], mbrSignature)
+	}
+	binary.LittleEndian.PutUint32(disk[440:], 0x0c3a8d6f)
+	entry(disk, 0, 0x83, 64, 100)
+	entry(disk, 1, 0x05, 200, 1000)
+	// First EBR at the start of the extended partition
+	entry(disk[200*512:], 0, 0x83, 8, 50)
+	entry(disk[200*512:], 1, 0x05, 100, 200)
+	// Second EBR, relative to the extended partition
+	entry(disk[300*512:], 0, 0x82, 8, 80)
+	return disk
+}
+
+func TestRead(t *testing.T) {
+	gptParts := []Partition{
+		{Number: 1, Start: 34 * 512, Size: 66 * 512, Type: "0fc63daf-8483-4772-8e79-3d69d8477de4", Label: "boot", UUID: "6a4f2b1c-1d2e-4f3a-9b8c-7d6e5f4a3b2c"},
+		{Number: 3, Start: 100 * 512, Size: 100 * 512, Type: "0fc63daf-8483-4772-8e79-3d69d8477de4", Label: "root", UUID: "11111111-2222-3333-4444-555555555555"},
+	}
+	tests := []struct {
+		name    string
+		disk    []byte
+		want    *Table
+		wantErr error
+	}{
+		{
+			name: "GPT",
+			disk: gptDisk(512),
+			want: &Table{Scheme: "gpt", Partitions: gptParts},
+		},
+		{
+			name: "GPT 4K sectors",
+			disk: gptDisk(4096),
+			want: &Table{Scheme: "gpt", Partitions: []Partition{
+				{Number: 1, Start: 34 * 4096, Size: 66 * 4096, Type: gptParts[0].Type, Label: "boot", UUID: gptParts[0].UUID},
+				{Number: 3, Start: 100 * 4096, Size: 100 * 4096, Type: gptParts[1].Type, Label: "root", UUID: gptParts[1].UUID},
+			}},
+		},
+		{
+			name: "Corrupt backup GPT header is ignored",
+			disk: gptDiskWith(corruptBackup, corruptBackupEntries),
+			want: &Table{Scheme: "gpt", Partitions: gptParts},
+		},
+		{
+			name: "Backup GPT header of corrupt primary header",
+			disk: gptDiskWith(corruptPrimary),
+			want: &Table{Scheme: "gpt", Partitions: gptParts},
+		},
+		{
+			name: "Backup GPT header of corrupt primary partition entries",
+			disk: gptDiskWith(corruptPrimaryEntries),
+			want: &Table{Scheme: "gpt", Partitions: gptParts},
+		},
+		{
+			name:    "Corrupt primary and backup GPT headers",
+			disk:    gptDiskWith(corruptPrimary, corruptBackup),
+			wantErr: errAny,
+		},
+		{
+			name:    "Corrupt primary and backup GPT partition entries",
+			disk:    gptDiskWith(corruptPrimaryEntries, corruptBackupEntries),
+			wantErr: errAny,
+		},
+		{
+			name:    "Corrupt primary and misplaced backup GPT header",
+			disk:    gptDiskWith(corruptPrimary, misplaceBackup),
+			wantErr: errAny,
+		},
+		{
+			name: "MBR with logical partitions",
+			disk: mbrDisk(),
+			want: &Table{Scheme: "mbr", Partitions: []Partition{
+				{Number: 1, Start: 64 * 512, Size: 100 * 512, Type: "83", UUID: "0c3a8d6f-01"},
+				{Number: 5, Start: 208 * 512, Size: 50 * 512, Type: "83", UUID: "0c3a8d6f-05"},
+				{Number: 6, Start: 308 * 512, Size: 80 * 512, Type: "82", UUID: "0c3a8d6f-06"},
+			}},
+		},
+		{
+			name:    "No table",
+			disk:    make([]byte, 4096),
+			wantErr: ErrNoTable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(bytes.NewReader(tt.disk))
+			if tt.wantErr == errAny && err != nil {
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Read() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Read() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTable_Find(t *testing.T) {
+	gpt, err := Read(bytes.NewReader(gptDisk(512)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mbr, err := Read(bytes.NewReader(mbrDisk()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		table   *Table
+		spec    string
+		want    int
+		wantErr bool
+	}{
+		{name: "Number", table: gpt, spec: "3", want: 3},
+		{name: "Label", table: gpt, spec: "root", want: 3},
+		{name: "PARTLABEL", table: gpt, spec: "PARTLABEL=boot", want: 1},
+		{name: "UUID", table: gpt, spec: "6A4F2B1C-1D2E-4F3A-9B8C-7D6E5F4A3B2C", want: 1},
+		{name: "PARTUUID", table: gpt, spec: "PARTUUID=11111111-2222-3333-4444-555555555555", want: 3},
+		{name: "MBR PARTUUID", table: mbr, spec: "PARTUUID=0c3a8d6f-05", want: 5},
+		{name: "MBR number", table: mbr, spec: "6", want: 6},
+		{name: "Empty slot", table: gpt, spec: "2", wantErr: true},
+		{name: "Unknown label", table: gpt, spec: "home", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.table.Find(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Table.Find() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.Number != tt.want {
+				t.Errorf("Table.Find() = %+v, want number %d", got, tt.want)
+			}
+		})
+	}
+}